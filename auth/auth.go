@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const TracerName string = "auth"
+
+type ContextKey string
+
+const (
+	CtxClient ContextKey = "auth_client"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token expired")
+	ErrRevokedToken = errors.New("token revoked")
+)
+
+// Identity of client resolved from app token
+type Client struct {
+	ID        string         `json:"id" db:"id"`
+	Name      string         `json:"name" db:"client_name"`
+	Scopes    pq.StringArray `json:"scopes" db:"scopes"`
+	ExpiresAt *time.Time     `json:"expires_at" db:"expires_at"`
+	RevokedAt *time.Time     `json:"revoked_at" db:"revoked_at"`
+}
+
+// Returns true if client has all provided scopes
+func (c *Client) HasScopes(scopes ...string) bool {
+	for _, scope := range scopes {
+		if !slices.Contains(c.Scopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns error if token of client is revoked or expired at provided time
+func (c *Client) Validate(now time.Time) error {
+	if c.RevokedAt != nil {
+		return ErrRevokedToken
+	}
+	if c.ExpiresAt != nil && !now.Before(*c.ExpiresAt) {
+		return ErrExpiredToken
+	}
+	return nil
+}
+
+func NewContext(ctx context.Context, client *Client) context.Context {
+	return context.WithValue(ctx, CtxClient, client)
+}
+
+func FromContext(ctx context.Context) (*Client, bool) {
+	client, ok := ctx.Value(CtxClient).(*Client)
+	return client, ok
+}
+
+// Tokens are stored only as SHA-256 hash
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	DEFAULT_JWKS_TTL = 10 * time.Minute
+	// Minimal interval between attempts to fetch JWKS, failed attempts included
+	JWKS_MIN_REFRESH = 10 * time.Second
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// Public keys fetched from JWKS URL and cached for ttl.
+// Keys are refreshed earlier when token is signed with unknown key id.
+// Concurrent refreshes are joined into one fetch and attempts are made at most once per JWKS_MIN_REFRESH.
+type KeySet struct {
+	url    string
+	ttl    time.Duration
+	client *http.Client
+	now    func() time.Time
+	group  singleflight.Group
+
+	mu          sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	attemptErr  error
+}
+
+func NewKeySet(url string, ttl time.Duration) *KeySet {
+	if ttl <= 0 {
+		ttl = DEFAULT_JWKS_TTL
+	}
+	return &KeySet{
+		url:    url,
+		ttl:    ttl,
+		client: &http.Client{Timeout: 10 * time.Second},
+		now:    time.Now,
+	}
+}
+
+func (k *KeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	k.mu.RLock()
+	key, ok := k.keys[kid]
+	age := k.now().Sub(k.fetchedAt)
+	k.mu.RUnlock()
+
+	if ok && age < k.ttl {
+		return key, nil
+	}
+
+	if err := k.refresh(ctx); err != nil {
+		// keep using cached key when JWKS is temporary unavailable
+		if ok {
+			return key, nil
+		}
+		return nil, err
+	}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if key, ok := k.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+// Refreshes keys unless previous attempt was made less than JWKS_MIN_REFRESH ago,
+// in which case error of that attempt is returned.
+// Callers waiting for the same refresh share its result.
+func (k *KeySet) refresh(ctx context.Context) error {
+	ch := k.group.DoChan("refresh", func() (any, error) {
+		k.mu.Lock()
+		if !k.attemptedAt.IsZero() && k.now().Sub(k.attemptedAt) < JWKS_MIN_REFRESH {
+			err := k.attemptErr
+			k.mu.Unlock()
+			return nil, err
+		}
+		k.attemptedAt = k.now()
+		k.mu.Unlock()
+
+		// fetch is shared with other callers, so it must not be cancelled by the first one
+		err := k.fetch(context.WithoutCancel(ctx))
+
+		k.mu.Lock()
+		k.attemptErr = err
+		k.mu.Unlock()
+		return nil, err
+	})
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case res := <-ch:
+		return res.Err
+	}
+}
+
+func (k *KeySet) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetch jwks: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch jwks: %s", resp.Status)
+	}
+
+	var body struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("decode jwks: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(body.Keys))
+	for _, key := range body.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		publicKey, err := key.publicKey()
+		if err != nil {
+			// skip keys of unsupported types
+			continue
+		}
+		keys[key.Kid] = publicKey
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys = keys
+	k.fetchedAt = k.now()
+	return nil
+}
+
+func (key jwk) publicKey() (crypto.PublicKey, error) {
+	switch key.Kty {
+	case "RSA":
+		n, err := decodeBigInt(key.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(key.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if key.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", key.Crv)
+		}
+		x, err := decodeBigInt(key.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(key.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", key.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
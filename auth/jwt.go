@@ -0,0 +1,258 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	ALG_RS256 = "RS256"
+	ALG_ES256 = "ES256"
+	ALG_HS256 = "HS256"
+)
+
+const (
+	CtxClaims ContextKey = "auth_claims"
+)
+
+var (
+	ErrInvalidJWT = errors.New("invalid jwt")
+	ErrExpiredJWT = errors.New("jwt expired")
+)
+
+type JWTConfig struct {
+	// URL of JSON Web Key Set with public keys for RS256 and ES256
+	JWKSURL string
+	// Cache duration of JWKS keys
+	JWKSTTL time.Duration
+	// Secret for HS256. HS256 tokens are rejected when empty.
+	HMACSecret []byte
+	// Expected "iss" claim, not checked when empty
+	Issuer string
+	// Expected value in "aud" claim, not checked when empty
+	Audience string
+	// Allowed clock difference for "exp", "nbf" and "iat" claims
+	ClockSkew time.Duration
+}
+
+// Audience can be a string or an array of strings
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(b, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  Audience `json:"aud"`
+	ExpiresAt float64  `json:"exp"`
+	NotBefore float64  `json:"nbf"`
+	IssuedAt  float64  `json:"iat"`
+	// Space separated list of scopes
+	Scope string   `json:"scope"`
+	Roles []string `json:"roles"`
+	// All claims of token
+	Raw map[string]any `json:"-"`
+}
+
+func (c *Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// Returns true if token has all provided scopes
+func (c *Claims) HasScopes(scopes ...string) bool {
+	granted := c.Scopes()
+	for _, scope := range scopes {
+		if !slices.Contains(granted, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if token has all provided roles
+func (c *Claims) HasRoles(roles ...string) bool {
+	for _, role := range roles {
+		if !slices.Contains(c.Roles, role) {
+			return false
+		}
+	}
+	return true
+}
+
+func NewClaimsContext(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, CtxClaims, claims)
+}
+
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(CtxClaims).(*Claims)
+	return claims, ok
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verifies signature and registered claims of JWT
+type Verifier struct {
+	cfg  JWTConfig
+	keys *KeySet
+	now  func() time.Time
+}
+
+func NewVerifier(cfg JWTConfig) *Verifier {
+	v := &Verifier{
+		cfg: cfg,
+		now: time.Now,
+	}
+	if cfg.JWKSURL != "" {
+		v.keys = NewKeySet(cfg.JWKSURL, cfg.JWKSTTL)
+	}
+	return v
+}
+
+// Returns claims of valid token.
+// Returns ErrExpiredJWT for expired token and error wrapping ErrInvalidJWT for every other problem.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidJWT)
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalidJWT, err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrInvalidJWT, err)
+	}
+
+	if err := v.verifySignature(ctx, header, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrInvalidJWT, err)
+	}
+	if err := decodeSegment(parts[1], &claims.Raw); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrInvalidJWT, err)
+	}
+
+	if err := v.validateClaims(&claims); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+func (v *Verifier) verifySignature(ctx context.Context, header jwtHeader, signed string, signature []byte) error {
+	hash := sha256.Sum256([]byte(signed))
+
+	switch header.Alg {
+	case ALG_HS256:
+		// HS256 uses only configured secret, so public keys can never be used as HMAC secret
+		if len(v.cfg.HMACSecret) == 0 {
+			return fmt.Errorf("%w: %s is not allowed", ErrInvalidJWT, header.Alg)
+		}
+		mac := hmac.New(sha256.New, v.cfg.HMACSecret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return fmt.Errorf("%w: signature mismatch", ErrInvalidJWT)
+		}
+		return nil
+	case ALG_RS256, ALG_ES256:
+		if v.keys == nil {
+			return fmt.Errorf("%w: %s is not allowed", ErrInvalidJWT, header.Alg)
+		}
+		key, err := v.keys.Key(ctx, header.Kid)
+		if errors.Is(err, ErrUnknownKey) {
+			return fmt.Errorf("%w: %v", ErrInvalidJWT, err)
+		}
+		if err != nil {
+			return err
+		}
+
+		if header.Alg == ALG_RS256 {
+			rsaKey, ok := key.(*rsa.PublicKey)
+			if !ok || rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, hash[:], signature) != nil {
+				return fmt.Errorf("%w: signature mismatch", ErrInvalidJWT)
+			}
+			return nil
+		}
+
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return fmt.Errorf("%w: signature mismatch", ErrInvalidJWT)
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, hash[:], r, s) {
+			return fmt.Errorf("%w: signature mismatch", ErrInvalidJWT)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidJWT, header.Alg)
+}
+
+func (v *Verifier) validateClaims(claims *Claims) error {
+	now := v.now()
+	skew := v.cfg.ClockSkew
+
+	if claims.ExpiresAt == 0 {
+		return fmt.Errorf("%w: exp is required", ErrInvalidJWT)
+	}
+	if now.After(numericDate(claims.ExpiresAt).Add(skew)) {
+		return ErrExpiredJWT
+	}
+	if claims.NotBefore != 0 && now.Add(skew).Before(numericDate(claims.NotBefore)) {
+		return fmt.Errorf("%w: token is not valid yet", ErrInvalidJWT)
+	}
+	if claims.IssuedAt != 0 && now.Add(skew).Before(numericDate(claims.IssuedAt)) {
+		return fmt.Errorf("%w: token is issued in the future", ErrInvalidJWT)
+	}
+	if v.cfg.Issuer != "" && claims.Issuer != v.cfg.Issuer {
+		return fmt.Errorf("%w: unexpected issuer", ErrInvalidJWT)
+	}
+	if v.cfg.Audience != "" && !slices.Contains(claims.Audience, v.cfg.Audience) {
+		return fmt.Errorf("%w: unexpected audience", ErrInvalidJWT)
+	}
+	return nil
+}
+
+func numericDate(v float64) time.Time {
+	return time.Unix(0, int64(v*float64(time.Second)))
+}
+
+func decodeSegment(segment string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
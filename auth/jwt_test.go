@@ -0,0 +1,246 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testKeys struct {
+	rsa   *rsa.PrivateKey
+	ec    *ecdsa.PrivateKey
+	hmac  []byte
+	jwks  *httptest.Server
+	calls int
+}
+
+func newTestKeys(t *testing.T) *testKeys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := &testKeys{rsa: rsaKey, ec: ecKey, hmac: []byte("secret")}
+	encode := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	keys.jwks = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys.calls++
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{
+				{
+					"kty": "RSA",
+					"kid": "rsa",
+					"use": "sig",
+					"n":   encode(rsaKey.N.Bytes()),
+					"e":   encode(big.NewInt(int64(rsaKey.E)).Bytes()),
+				},
+				{
+					"kty": "EC",
+					"kid": "ec",
+					"crv": "P-256",
+					"x":   encode(ecKey.X.FillBytes(make([]byte, 32))),
+					"y":   encode(ecKey.Y.FillBytes(make([]byte, 32))),
+				},
+			},
+		})
+	}))
+	t.Cleanup(keys.jwks.Close)
+	return keys
+}
+
+func (k *testKeys) sign(t *testing.T, alg, kid string, claims map[string]any) string {
+	encode := func(v any) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := encode(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + encode(claims)
+	hash := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch alg {
+	case ALG_RS256:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	case ALG_ES256:
+		r, s, err := ecdsa.Sign(rand.Reader, k.ec, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case ALG_HS256:
+		mac := hmac.New(sha256.New, k.hmac)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerify(t *testing.T) {
+	keys := newTestKeys(t)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	verifier := NewVerifier(JWTConfig{
+		JWKSURL:    keys.jwks.URL,
+		HMACSecret: keys.hmac,
+		Issuer:     "https://issuer.example.com",
+		Audience:   "http_template",
+		ClockSkew:  30 * time.Second,
+	})
+	verifier.now = func() time.Time { return now }
+	verifier.keys.now = verifier.now
+
+	claims := func(overrides map[string]any) map[string]any {
+		c := map[string]any{
+			"sub":   "user-1",
+			"iss":   "https://issuer.example.com",
+			"aud":   []string{"http_template", "other"},
+			"exp":   now.Add(time.Minute).Unix(),
+			"iat":   now.Unix(),
+			"scope": "files admin",
+			"roles": []string{"admin"},
+		}
+		for k, v := range overrides {
+			c[k] = v
+		}
+		return c
+	}
+
+	t.Run("Valid tokens", func(t *testing.T) {
+		for _, alg := range []string{ALG_RS256, ALG_ES256, ALG_HS256} {
+			kid := map[string]string{ALG_RS256: "rsa", ALG_ES256: "ec"}[alg]
+			result, err := verifier.Verify(context.Background(), keys.sign(t, alg, kid, claims(nil)))
+			if assert.NoError(t, err, alg) {
+				assert.Equal(t, "user-1", result.Subject)
+				assert.True(t, result.HasScopes("files", "admin"))
+				assert.True(t, result.HasRoles("admin"))
+				assert.Equal(t, "user-1", result.Raw["sub"])
+			}
+		}
+	})
+
+	t.Run("Expired within clock skew", func(t *testing.T) {
+		token := keys.sign(t, ALG_RS256, "rsa", claims(map[string]any{"exp": now.Add(-10 * time.Second).Unix()}))
+		_, err := verifier.Verify(context.Background(), token)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		token := keys.sign(t, ALG_RS256, "rsa", claims(map[string]any{"exp": now.Add(-time.Minute).Unix()}))
+		_, err := verifier.Verify(context.Background(), token)
+		assert.ErrorIs(t, err, ErrExpiredJWT)
+	})
+
+	invalid := map[string]string{
+		"Not valid yet":      keys.sign(t, ALG_RS256, "rsa", claims(map[string]any{"nbf": now.Add(time.Minute).Unix()})),
+		"Wrong issuer":       keys.sign(t, ALG_RS256, "rsa", claims(map[string]any{"iss": "other"})),
+		"Wrong audience":     keys.sign(t, ALG_RS256, "rsa", claims(map[string]any{"aud": "other"})),
+		"Missing exp":        keys.sign(t, ALG_RS256, "rsa", claims(map[string]any{"exp": nil})),
+		"Unknown key":        keys.sign(t, ALG_RS256, "unknown", claims(nil)),
+		"Key of other type":  keys.sign(t, ALG_RS256, "ec", claims(nil)),
+		"Unsupported alg":    keys.sign(t, "none", "", claims(nil)),
+		"Malformed":          "token",
+		"Tampered signature": keys.sign(t, ALG_HS256, "", claims(nil)) + "a",
+	}
+	for name, token := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, err := verifier.Verify(context.Background(), token)
+			assert.ErrorIs(t, err, ErrInvalidJWT)
+		})
+	}
+
+	t.Run("HS256 without secret", func(t *testing.T) {
+		verifier := NewVerifier(JWTConfig{JWKSURL: keys.jwks.URL})
+		_, err := verifier.Verify(context.Background(), keys.sign(t, ALG_HS256, "", claims(nil)))
+		assert.ErrorIs(t, err, ErrInvalidJWT)
+	})
+}
+
+func TestKeySetCache(t *testing.T) {
+	keys := newTestKeys(t)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	keySet := NewKeySet(keys.jwks.URL, time.Minute)
+	keySet.now = func() time.Time { return now }
+
+	_, err := keySet.Key(context.Background(), "rsa")
+	assert.NoError(t, err)
+	_, err = keySet.Key(context.Background(), "ec")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, keys.calls)
+
+	// unknown key does not cause refresh right after previous one
+	_, err = keySet.Key(context.Background(), "unknown")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	assert.Equal(t, 1, keys.calls)
+
+	now = now.Add(2 * time.Minute)
+	_, err = keySet.Key(context.Background(), "rsa")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, keys.calls)
+}
+
+func TestKeySetFailingJWKS(t *testing.T) {
+	var calls atomic.Int32
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(jwks.Close)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var mu sync.Mutex
+	keySet := NewKeySet(jwks.URL, time.Minute)
+	keySet.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+
+	lookup := func(n int) {
+		var wg sync.WaitGroup
+		for i := range n {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := keySet.Key(context.Background(), fmt.Sprintf("kid-%d", i))
+				assert.Error(t, err)
+			}()
+		}
+		wg.Wait()
+	}
+
+	lookup(50)
+	assert.Equal(t, int32(1), calls.Load())
+
+	// failed attempt throttles next ones
+	lookup(50)
+	assert.Equal(t, int32(1), calls.Load())
+
+	mu.Lock()
+	now = now.Add(JWKS_MIN_REFRESH)
+	mu.Unlock()
+	lookup(50)
+	assert.Equal(t, int32(2), calls.Load())
+}
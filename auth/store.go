@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/Moranilt/http-utils/clients/database"
+	"github.com/Moranilt/http-utils/logger"
+	"github.com/Moranilt/http_template/cache"
+	"go.opentelemetry.io/otel"
+)
+
+const (
+	QUERY_GetToken = "SELECT id, client_name, scopes, expires_at, revoked_at FROM app_tokens WHERE token_hash = $1"
+)
+
+const (
+	// Revoked token is accepted until its cache entry expires
+	CACHE_TTL = 30 * time.Second
+	// Unknown token is rejected without query to database until its cache entry expires
+	NEGATIVE_CACHE_TTL = 10 * time.Second
+	// Cached value of unknown token
+	CACHE_INVALID = "invalid"
+)
+
+// Store of hashed app tokens in Postgres with read-through cache
+type Store struct {
+	db    *database.Client
+	cache cache.Cache
+	log   logger.Logger
+	now   func() time.Time
+}
+
+func NewStore(db *database.Client, cache cache.Cache, log logger.Logger) *Store {
+	return &Store{
+		db:    db,
+		cache: cache,
+		log:   log,
+		now:   time.Now,
+	}
+}
+
+// Resolves client by token.
+// Returns ErrInvalidToken, ErrExpiredToken or ErrRevokedToken if token can not be used.
+func (s *Store) Lookup(ctx context.Context, token string) (*Client, error) {
+	newCtx, span := otel.Tracer(TracerName).Start(ctx, "Lookup")
+	defer span.End()
+
+	hash := HashToken(token)
+	client, invalid := s.getCached(newCtx, hash)
+	if invalid {
+		return nil, ErrInvalidToken
+	}
+	if client == nil {
+		client = &Client{}
+		err := s.db.GetContext(newCtx, client, QUERY_GetToken, hash)
+		if errors.Is(err, sql.ErrNoRows) {
+			s.setCachedInvalid(newCtx, hash)
+			return nil, ErrInvalidToken
+		}
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+		s.setCached(newCtx, hash, client)
+	}
+
+	if err := client.Validate(s.now()); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// Returns cached client or true if token is cached as unknown
+func (s *Store) getCached(ctx context.Context, hash string) (*Client, bool) {
+	b, err := s.cache.Get(ctx, cacheKey(hash))
+	if err != nil {
+		if !errors.Is(err, cache.ErrNotFound) {
+			s.log.WithRequestId(ctx).Error("token cache get", "error", err)
+		}
+		return nil, false
+	}
+	if string(b) == CACHE_INVALID {
+		return nil, true
+	}
+
+	var client Client
+	if err := json.Unmarshal(b, &client); err != nil {
+		s.log.WithRequestId(ctx).Error("token cache unmarshal", "error", err)
+		return nil, false
+	}
+	return &client, false
+}
+
+func (s *Store) setCached(ctx context.Context, hash string, client *Client) {
+	b, err := json.Marshal(client)
+	if err != nil {
+		s.log.WithRequestId(ctx).Error("token cache marshal", "error", err)
+		return
+	}
+	if err := s.cache.Set(ctx, cacheKey(hash), b, CACHE_TTL); err != nil {
+		s.log.WithRequestId(ctx).Error("token cache set", "error", err)
+	}
+}
+
+func (s *Store) setCachedInvalid(ctx context.Context, hash string) {
+	if err := s.cache.Set(ctx, cacheKey(hash), []byte(CACHE_INVALID), NEGATIVE_CACHE_TTL); err != nil {
+		s.log.WithRequestId(ctx).Error("token cache set", "error", err)
+	}
+}
+
+func cacheKey(hash string) string {
+	return "app_token:" + hash
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Moranilt/http-utils/clients/database"
+	database_mock "github.com/Moranilt/http-utils/clients/database/mock"
+	redis_mock "github.com/Moranilt/http-utils/clients/redis/mock"
+	"github.com/Moranilt/http-utils/logger"
+	"github.com/Moranilt/http_template/cache"
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	tokenColumns = []string{"id", "client_name", "scopes", "expires_at", "revoked_at"}
+	now          = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+func mockStore(t *testing.T) (*Store, sqlmock.Sqlmock, redismock.ClientMock) {
+	mockDb, sqlMock := database_mock.NewSQlMock(t)
+	mockRedis, redisMock := redis_mock.New()
+	store := NewStore(&database.Client{DB: mockDb}, cache.NewRedis(mockRedis), logger.New(io.Discard, logger.TYPE_JSON))
+	store.now = func() time.Time { return now }
+	return store, sqlMock, redisMock
+}
+
+func TestLookup(t *testing.T) {
+	token := "secret-token"
+	hash := HashToken(token)
+
+	t.Run("Cache miss", func(t *testing.T) {
+		store, sqlMock, redisMock := mockStore(t)
+		expectedClient := &Client{ID: "1", Name: "billing", Scopes: []string{"files", "admin"}}
+		cached, _ := json.Marshal(expectedClient)
+
+		redisMock.ExpectGet(cacheKey(hash)).RedisNil()
+		sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_GetToken)).
+			WithArgs(hash).
+			WillReturnRows(sqlmock.NewRows(tokenColumns).AddRow("1", "billing", "{files,admin}", nil, nil))
+		redisMock.ExpectSet(cacheKey(hash), cached, CACHE_TTL).SetVal("OK")
+
+		client, err := store.Lookup(context.Background(), token)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedClient, client)
+		assert.NoError(t, sqlMock.ExpectationsWereMet())
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("Cache hit", func(t *testing.T) {
+		store, _, redisMock := mockStore(t)
+		expectedClient := &Client{ID: "1", Name: "billing", Scopes: []string{"files"}}
+		cached, _ := json.Marshal(expectedClient)
+
+		redisMock.ExpectGet(cacheKey(hash)).SetVal(string(cached))
+
+		client, err := store.Lookup(context.Background(), token)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedClient, client)
+	})
+
+	t.Run("Unknown token", func(t *testing.T) {
+		store, sqlMock, redisMock := mockStore(t)
+
+		redisMock.ExpectGet(cacheKey(hash)).RedisNil()
+		sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_GetToken)).
+			WithArgs(hash).
+			WillReturnError(sql.ErrNoRows)
+		redisMock.ExpectSet(cacheKey(hash), []byte(CACHE_INVALID), NEGATIVE_CACHE_TTL).SetVal("OK")
+
+		client, err := store.Lookup(context.Background(), token)
+		assert.Nil(t, client)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+		assert.NoError(t, sqlMock.ExpectationsWereMet())
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("Cached unknown token", func(t *testing.T) {
+		store, sqlMock, redisMock := mockStore(t)
+
+		redisMock.ExpectGet(cacheKey(hash)).SetVal(CACHE_INVALID)
+
+		client, err := store.Lookup(context.Background(), token)
+		assert.Nil(t, client)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+		assert.NoError(t, sqlMock.ExpectationsWereMet())
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("Expired token", func(t *testing.T) {
+		store, _, redisMock := mockStore(t)
+		expiresAt := now.Add(-time.Second)
+		cached, _ := json.Marshal(&Client{ID: "1", ExpiresAt: &expiresAt})
+
+		redisMock.ExpectGet(cacheKey(hash)).SetVal(string(cached))
+
+		client, err := store.Lookup(context.Background(), token)
+		assert.Nil(t, client)
+		assert.ErrorIs(t, err, ErrExpiredToken)
+	})
+
+	t.Run("Revoked token", func(t *testing.T) {
+		store, _, redisMock := mockStore(t)
+		cached, _ := json.Marshal(&Client{ID: "1", RevokedAt: &now})
+
+		redisMock.ExpectGet(cacheKey(hash)).SetVal(string(cached))
+
+		client, err := store.Lookup(context.Background(), token)
+		assert.Nil(t, client)
+		assert.ErrorIs(t, err, ErrRevokedToken)
+	})
+}
+
+func TestHasScopes(t *testing.T) {
+	client := &Client{Scopes: []string{"files", "admin"}}
+
+	assert.True(t, client.HasScopes())
+	assert.True(t, client.HasScopes("files", "admin"))
+	assert.False(t, client.HasScopes("files", "users"))
+}
@@ -0,0 +1,66 @@
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Set with -ldflags "-X github.com/Moranilt/http_template/buildinfo.Version=v1.0.0".
+// Commit and BuildTime are taken from VCS info of binary when empty.
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildTime = ""
+)
+
+var buildInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "your_app_build_info",
+	Help: "Build information of application, value is always 1",
+}, []string{"version", "commit", "go_version"})
+
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+func init() {
+	info := Get()
+	buildInfo.WithLabelValues(info.Version, info.Commit, info.GoVersion).Set(1)
+}
+
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	var modified bool
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = setting.Value
+			}
+		case "vcs.time":
+			if info.BuildTime == "" {
+				info.BuildTime = setting.Value
+			}
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if modified && Commit == "" && info.Commit != "" {
+		info.Commit += "-dirty"
+	}
+	return info
+}
@@ -0,0 +1,22 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const TracerName string = "cache"
+
+var ErrNotFound = errors.New("key not found")
+
+// Key-value cache with expiration. Zero ttl means that key does not expire.
+type Cache interface {
+	// Returns ErrNotFound if key does not exist or is expired
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Sets value only if key does not exist, reports whether value is set
+	SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+	// Deleting of not existing key is not an error
+	Del(ctx context.Context, key string) error
+}
@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// How often expired keys are removed from memory
+const MEMORY_SWEEP_INTERVAL = time.Minute
+
+// Maximal number of keys kept in memory, least recently used key is evicted when it is exceeded
+const MEMORY_MAX_ENTRIES = 100_000
+
+type entry struct {
+	key   string
+	value []byte
+	// zero time when key does not expire
+	expiresAt time.Time
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// Cache of single instance, used when Redis is disabled.
+// Keeps at most maxEntries keys, so keys written for every request(unknown tokens, idempotency keys)
+// can not grow memory without bound.
+type Memory struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// most recently used entries are in front
+	order      *list.List
+	maxEntries int
+	lastSweep  time.Time
+	// replaceable in tests
+	now func() time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+		maxEntries: MEMORY_MAX_ENTRIES,
+		now:        time.Now,
+	}
+}
+
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, ok := m.entries[key]
+	if !ok || el.Value.(*entry).expired(m.now()) {
+		return nil, ErrNotFound
+	}
+	m.order.MoveToFront(el)
+	return el.Value.(*entry).value, nil
+}
+
+func (m *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.set(key, value, ttl)
+	return nil
+}
+
+func (m *Memory) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.entries[key]; ok && !el.Value.(*entry).expired(m.now()) {
+		return false, nil
+	}
+	m.set(key, value, ttl)
+	return true, nil
+}
+
+func (m *Memory) Del(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.entries[key]; ok {
+		m.remove(el)
+	}
+	return nil
+}
+
+// Must be called with locked mu
+func (m *Memory) set(key string, value []byte, ttl time.Duration) {
+	now := m.now()
+	e := &entry{key: key, value: value}
+	if ttl > 0 {
+		e.expiresAt = now.Add(ttl)
+	}
+	if el, ok := m.entries[key]; ok {
+		el.Value = e
+		m.order.MoveToFront(el)
+	} else {
+		m.entries[key] = m.order.PushFront(e)
+	}
+
+	if now.Sub(m.lastSweep) >= MEMORY_SWEEP_INTERVAL {
+		m.lastSweep = now
+		for _, el := range m.entries {
+			if el.Value.(*entry).expired(now) {
+				m.remove(el)
+			}
+		}
+	}
+	for m.order.Len() > m.maxEntries {
+		m.remove(m.order.Back())
+	}
+}
+
+// Must be called with locked mu
+func (m *Memory) remove(el *list.Element) {
+	m.order.Remove(el)
+	delete(m.entries, el.Value.(*entry).key)
+}
@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+
+	_, err := m.Get(ctx, "user:1")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.NoError(t, m.Set(ctx, "user:1", []byte("john"), time.Second))
+	assert.NoError(t, m.Set(ctx, "user:2", []byte("jane"), 0))
+	value, err := m.Get(ctx, "user:1")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("john"), value)
+
+	set, err := m.SetNX(ctx, "user:1", []byte("other"), time.Second)
+	assert.NoError(t, err)
+	assert.False(t, set, "existing key must not be replaced")
+
+	now = now.Add(time.Second)
+	_, err = m.Get(ctx, "user:1")
+	assert.ErrorIs(t, err, ErrNotFound, "key must expire")
+	set, err = m.SetNX(ctx, "user:1", []byte("other"), time.Second)
+	assert.NoError(t, err)
+	assert.True(t, set, "expired key must be replaced")
+
+	assert.NoError(t, m.Del(ctx, "user:1"))
+	_, err = m.Get(ctx, "user:1")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	t.Run("Sweep", func(t *testing.T) {
+		assert.NoError(t, m.Set(ctx, "session", []byte("1"), time.Second))
+		now = now.Add(MEMORY_SWEEP_INTERVAL)
+		assert.NoError(t, m.Set(ctx, "other", []byte("1"), time.Second))
+
+		assert.NotContains(t, m.entries, "session")
+		assert.Contains(t, m.entries, "user:2", "key without ttl must be kept")
+	})
+
+	t.Run("Evict least recently used", func(t *testing.T) {
+		m := NewMemory()
+		m.maxEntries = 2
+		assert.NoError(t, m.Set(ctx, "a", []byte("1"), time.Minute))
+		assert.NoError(t, m.Set(ctx, "b", []byte("1"), time.Minute))
+		_, err := m.Get(ctx, "a")
+		assert.NoError(t, err)
+		assert.NoError(t, m.Set(ctx, "c", []byte("1"), time.Minute))
+
+		assert.Len(t, m.entries, 2)
+		_, err = m.Get(ctx, "b")
+		assert.ErrorIs(t, err, ErrNotFound, "least recently used key must be evicted")
+		_, err = m.Get(ctx, "a")
+		assert.NoError(t, err)
+	})
+}
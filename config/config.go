@@ -1,18 +1,36 @@
 package config
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/netip"
+	"net/url"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Moranilt/http-utils/clients/database"
 	"github.com/Moranilt/http-utils/clients/rabbitmq"
 	"github.com/Moranilt/http-utils/clients/redis"
+	"github.com/Moranilt/http-utils/logger"
+	"github.com/Moranilt/http_template/ratelimit"
+	"github.com/Moranilt/http_template/secrets"
+	"github.com/Moranilt/http_template/storage"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
 const (
+	ENV_CONFIG_FILE = "CONFIG_FILE"
+
 	ENV_PRODUCTION = "PRODUCTION"
 	ENV_PORT       = "PORT"
+	ENV_ADMIN_PORT = "ADMIN_PORT"
+	ENV_LOG_LEVEL  = "LOG_LEVEL"
 
 	ENV_DB_NAME     = "DB_NAME"
 	ENV_DB_HOST     = "DB_HOST"
@@ -20,94 +38,693 @@ const (
 	ENV_DB_PASSWORD = "DB_PASSWORD"
 	ENV_DB_SSL_MODE = "DB_SSL_MODE"
 
+	ENV_RABBITMQ_ENABLED  = "RABBITMQ_ENABLED"
 	ENV_RABBITMQ_HOST     = "RABBITMQ_HOST"
 	ENV_RABBITMQ_USERNAME = "RABBITMQ_USERNAME"
 	ENV_RABBITMQ_PASSWORD = "RABBITMQ_PASSWORD"
 
+	ENV_REDIS_ENABLED  = "REDIS_ENABLED"
 	ENV_REDIS_HOST     = "REDIS_HOST"
 	ENV_REDIS_PASSWORD = "REDIS_PASSWORD"
 
-	ENV_TRACER_URL  = "TRACER_URL"
-	ENV_TRACER_NAME = "TRACER_NAME"
+	ENV_TRACER_ENABLED = "TRACER_ENABLED"
+	ENV_TRACER_URL     = "TRACER_URL"
+	ENV_TRACER_NAME    = "TRACER_NAME"
+
+	ENV_STORAGE_DRIVER    = "STORAGE_DRIVER"
+	ENV_STORAGE_LOCAL_DIR = "STORAGE_LOCAL_DIR"
+
+	ENV_S3_ENDPOINT   = "S3_ENDPOINT"
+	ENV_S3_REGION     = "S3_REGION"
+	ENV_S3_BUCKET     = "S3_BUCKET"
+	ENV_S3_ACCESS_KEY = "S3_ACCESS_KEY"
+	ENV_S3_SECRET_KEY = "S3_SECRET_KEY"
+
+	ENV_JWT_JWKS_URL    = "JWT_JWKS_URL"
+	ENV_JWT_JWKS_TTL    = "JWT_JWKS_TTL"
+	ENV_JWT_HMAC_SECRET = "JWT_HMAC_SECRET"
+	ENV_JWT_ISSUER      = "JWT_ISSUER"
+	ENV_JWT_AUDIENCE    = "JWT_AUDIENCE"
+	ENV_JWT_CLOCK_SKEW  = "JWT_CLOCK_SKEW"
+
+	ENV_CORS_ALLOWED_ORIGINS   = "CORS_ALLOWED_ORIGINS"
+	ENV_CORS_ALLOWED_METHODS   = "CORS_ALLOWED_METHODS"
+	ENV_CORS_ALLOWED_HEADERS   = "CORS_ALLOWED_HEADERS"
+	ENV_CORS_EXPOSED_HEADERS   = "CORS_EXPOSED_HEADERS"
+	ENV_CORS_ALLOW_CREDENTIALS = "CORS_ALLOW_CREDENTIALS"
+	ENV_CORS_MAX_AGE           = "CORS_MAX_AGE"
+
+	ENV_VAULT_ADDR  = "VAULT_ADDR"
+	ENV_VAULT_TOKEN = "VAULT_TOKEN"
+	ENV_VAULT_MOUNT = "VAULT_MOUNT"
+	ENV_VAULT_PATH  = "VAULT_PATH"
+)
+
+const (
+	FLAG_CONFIG     = "config"
+	FLAG_PORT       = "port"
+	FLAG_PRODUCTION = "production"
+)
+
+const (
+	DEFAULT_PORT                 = "8080"
+	DEFAULT_ADMIN_PORT           = "8081"
+	DEFAULT_LOG_LEVEL            = "trace"
+	DEFAULT_SERVER_READ_TIMEOUT  = 40 * time.Second
+	DEFAULT_SERVER_WRITE_TIMEOUT = 40 * time.Second
+	// less than default terminationGracePeriodSeconds of Kubernetes
+	DEFAULT_SERVER_GRACE_PERIOD    = 25 * time.Second
+	DEFAULT_SERVER_DRAIN_DELAY     = 5 * time.Second
+	DEFAULT_RABBITMQ_QUEUE         = "test_queue"
+	DEFAULT_STORAGE_LOCAL_DIR      = "uploads"
+	DEFAULT_JWT_CLOCK_SKEW         = 30 * time.Second
+	DEFAULT_HEALTH_INTERVAL        = 10 * time.Second
+	DEFAULT_HEALTH_TIMEOUT         = 5 * time.Second
+	DEFAULT_OUTBOX_INTERVAL        = 1 * time.Second
+	DEFAULT_OUTBOX_BATCH_SIZE      = 100
+	DEFAULT_OUTBOX_PUBLISH_TIMEOUT = 5 * time.Second
+	DEFAULT_CONSUMER_MAX_MESSAGES  = 5
+	DEFAULT_CONSUMER_WAIT          = 5 * time.Second
+	DEFAULT_CONSUMER_MAX_RETRIES   = 5
+	DEFAULT_CONSUMER_MIN_BACKOFF   = 1 * time.Second
+	DEFAULT_CONSUMER_MAX_BACKOFF   = 5 * time.Minute
 )
 
-var envVariables []string = []string{
-	ENV_PORT,
-	ENV_DB_NAME,
-	ENV_DB_HOST,
-	ENV_DB_USER,
-	ENV_DB_PASSWORD,
-	ENV_DB_SSL_MODE,
-	ENV_RABBITMQ_HOST,
-	ENV_RABBITMQ_USERNAME,
-	ENV_RABBITMQ_PASSWORD,
-	ENV_REDIS_HOST,
-	ENV_TRACER_URL,
-	ENV_TRACER_NAME,
+// Default value of every config key. Keys missing here are not read from env.
+var defaults = map[string]any{
+	"production": false,
+
+	"log.level": DEFAULT_LOG_LEVEL,
+
+	"server.port":                   DEFAULT_PORT,
+	"server.admin_port":             DEFAULT_ADMIN_PORT,
+	"server.read_timeout":           DEFAULT_SERVER_READ_TIMEOUT,
+	"server.write_timeout":          DEFAULT_SERVER_WRITE_TIMEOUT,
+	"server.grace_period":           DEFAULT_SERVER_GRACE_PERIOD,
+	"server.drain_delay":            DEFAULT_SERVER_DRAIN_DELAY,
+	"server.ip_rate_limit.requests": 0,
+	"server.ip_rate_limit.period":   time.Second,
+	"server.ip_rate_limit.burst":    0,
+	"server.trusted_proxies":        []string{},
+
+	"db.host":     "",
+	"db.name":     "",
+	"db.user":     "",
+	"db.password": "",
+	"db.ssl_mode": "",
+
+	"rabbitmq.enabled":  true,
+	"rabbitmq.host":     "",
+	"rabbitmq.username": "",
+	"rabbitmq.password": "",
+	"rabbitmq.queue":    DEFAULT_RABBITMQ_QUEUE,
+
+	"redis.enabled":  true,
+	"redis.host":     "",
+	"redis.password": "",
+
+	"tracer.enabled": true,
+	"tracer.url":     "",
+	"tracer.name":    "",
+
+	"storage.driver":        storage.DRIVER_Local,
+	"storage.local_dir":     DEFAULT_STORAGE_LOCAL_DIR,
+	"storage.s3.endpoint":   "",
+	"storage.s3.region":     "",
+	"storage.s3.bucket":     "",
+	"storage.s3.access_key": "",
+	"storage.s3.secret_key": "",
+
+	"jwt.jwks_url":    "",
+	"jwt.jwks_ttl":    time.Duration(0),
+	"jwt.hmac_secret": "",
+	"jwt.issuer":      "",
+	"jwt.audience":    "",
+	"jwt.clock_skew":  DEFAULT_JWT_CLOCK_SKEW,
+
+	"cors.allowed_origins":   []string{},
+	"cors.allowed_methods":   []string{},
+	"cors.allowed_headers":   []string{},
+	"cors.exposed_headers":   []string{},
+	"cors.allow_credentials": false,
+	"cors.max_age":           time.Duration(0),
+
+	"health.interval": DEFAULT_HEALTH_INTERVAL,
+	"health.timeout":  DEFAULT_HEALTH_TIMEOUT,
+
+	"outbox.interval":        DEFAULT_OUTBOX_INTERVAL,
+	"outbox.batch_size":      DEFAULT_OUTBOX_BATCH_SIZE,
+	"outbox.publish_timeout": DEFAULT_OUTBOX_PUBLISH_TIMEOUT,
+
+	"consumer.max_messages": DEFAULT_CONSUMER_MAX_MESSAGES,
+	"consumer.wait":         DEFAULT_CONSUMER_WAIT,
+	"consumer.max_retries":  DEFAULT_CONSUMER_MAX_RETRIES,
+	"consumer.min_backoff":  DEFAULT_CONSUMER_MIN_BACKOFF,
+	"consumer.max_backoff":  DEFAULT_CONSUMER_MAX_BACKOFF,
+
+	"vault.addr":  "",
+	"vault.token": "",
+	"vault.mount": secrets.VAULT_DEFAULT_MOUNT,
+	"vault.path":  "",
+}
+
+var logLevels = map[string]slog.Level{
+	"trace":  logger.LevelTrace,
+	"debug":  logger.LevelDebug,
+	"info":   logger.LevelInfo,
+	"notice": logger.LevelNotice,
+	"error":  logger.LevelError,
+}
+
+// Env variables with names which differ from key, other keys are read from
+// env named as upper-cased key with "_" instead of ".", for example OUTBOX_BATCH_SIZE.
+var envBindings = map[string]string{
+	"server.port":           ENV_PORT,
+	"server.admin_port":     ENV_ADMIN_PORT,
+	"storage.s3.endpoint":   ENV_S3_ENDPOINT,
+	"storage.s3.region":     ENV_S3_REGION,
+	"storage.s3.bucket":     ENV_S3_BUCKET,
+	"storage.s3.access_key": ENV_S3_ACCESS_KEY,
+	"storage.s3.secret_key": ENV_S3_SECRET_KEY,
+}
+
+type LogConfig struct {
+	// trace, debug, info, notice or error
+	Level string `mapstructure:"level"`
+}
+
+func (c LogConfig) SlogLevel() slog.Level {
+	return logLevels[strings.ToLower(c.Level)]
+}
+
+type ServerConfig struct {
+	Port string `mapstructure:"port"`
+	// Port of metrics, health, pprof and build info, must not be exposed publicly
+	AdminPort    string        `mapstructure:"admin_port"`
+	ReadTimeout  time.Duration `mapstructure:"read_timeout"`
+	WriteTimeout time.Duration `mapstructure:"write_timeout"`
+	// How long shutdown waits for in-flight requests and consumer handlers
+	GracePeriod time.Duration `mapstructure:"grace_period"`
+	// How long server keeps accepting requests after readiness probe reports draining,
+	// so load balancer stops sending traffic. It is a part of grace period.
+	DrainDelay time.Duration `mapstructure:"drain_delay"`
+	// Applied before authorization to every endpoint, disabled by default
+	IPRateLimit RateLimitConfig `mapstructure:"ip_rate_limit"`
+	// IP addresses or CIDR ranges of proxies which are trusted to set X-Forwarded-For and X-Real-IP
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+}
+
+// Parsed trusted proxies, IP address is a range of single address. Invalid items are skipped.
+func (c ServerConfig) Proxies() []netip.Prefix {
+	var proxies []netip.Prefix
+	for _, item := range trimList(c.TrustedProxies) {
+		if proxy, err := parseProxy(item); err == nil {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+func parseProxy(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+type DBConfig struct {
+	Host     string `mapstructure:"host"`
+	Name     string `mapstructure:"name"`
+	User     string `mapstructure:"user"`
+	Password string `mapstructure:"password"`
+	SSLMode  string `mapstructure:"ssl_mode"`
+}
+
+func (c DBConfig) Credentials() *database.Credentials {
+	creds := &database.Credentials{
+		Username: c.User,
+		Password: c.Password,
+		DBName:   c.Name,
+		Host:     c.Host,
+	}
+	if c.SSLMode != "" {
+		sslMode := c.SSLMode
+		creds.SSLMode = &sslMode
+	}
+	return creds
+}
+
+// Without RabbitMQ events are dropped and consumers are not started
+type RabbitMQConfig struct {
+	Enabled  bool   `mapstructure:"enabled"`
+	Host     string `mapstructure:"host"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	Queue    string `mapstructure:"queue"`
+}
+
+func (c RabbitMQConfig) Credentials() *rabbitmq.Credentials {
+	return &rabbitmq.Credentials{
+		Host:     c.Host,
+		Username: c.Username,
+		Password: c.Password,
+	}
+}
+
+// Without Redis cache, rate limits and idempotency keys are kept in memory of instance
+type RedisConfig struct {
+	Enabled  bool   `mapstructure:"enabled"`
+	Host     string `mapstructure:"host"`
+	Password string `mapstructure:"password"`
+}
+
+func (c RedisConfig) Credentials() *redis.Credentials {
+	return &redis.Credentials{
+		Host:     c.Host,
+		Password: c.Password,
+	}
 }
 
 type TracerConfig struct {
-	URL  string `yaml:"url"`
-	Name string `yaml:"name"`
+	Enabled bool   `mapstructure:"enabled"`
+	URL     string `mapstructure:"url"`
+	Name    string `mapstructure:"name"`
+}
+
+type S3Config struct {
+	Endpoint  string `mapstructure:"endpoint"`
+	Region    string `mapstructure:"region"`
+	Bucket    string `mapstructure:"bucket"`
+	AccessKey string `mapstructure:"access_key"`
+	SecretKey string `mapstructure:"secret_key"`
+}
+
+type StorageConfig struct {
+	Driver   string   `mapstructure:"driver"`
+	LocalDir string   `mapstructure:"local_dir"`
+	S3       S3Config `mapstructure:"s3"`
+}
+
+func (c StorageConfig) Storage() *storage.Config {
+	cfg := &storage.Config{
+		Driver:   c.Driver,
+		LocalDir: c.LocalDir,
+	}
+	if c.Driver == storage.DRIVER_S3 {
+		cfg.S3 = &storage.S3Config{
+			Endpoint:  c.S3.Endpoint,
+			Region:    c.S3.Region,
+			Bucket:    c.S3.Bucket,
+			AccessKey: c.S3.AccessKey,
+			SecretKey: c.S3.SecretKey,
+		}
+	}
+	return cfg
+}
+
+type JWTConfig struct {
+	JWKSURL    string        `mapstructure:"jwks_url"`
+	JWKSTTL    time.Duration `mapstructure:"jwks_ttl"`
+	HMACSecret string        `mapstructure:"hmac_secret"`
+	Issuer     string        `mapstructure:"issuer"`
+	Audience   string        `mapstructure:"audience"`
+	ClockSkew  time.Duration `mapstructure:"clock_skew"`
+}
+
+// JWT verification is disabled when both JWKSURL and HMACSecret are empty
+func (c JWTConfig) Enabled() bool {
+	return c.JWKSURL != "" || c.HMACSecret != ""
+}
+
+type CORSConfig struct {
+	AllowedOrigins   []string      `mapstructure:"allowed_origins"`
+	AllowedMethods   []string      `mapstructure:"allowed_methods"`
+	AllowedHeaders   []string      `mapstructure:"allowed_headers"`
+	ExposedHeaders   []string      `mapstructure:"exposed_headers"`
+	AllowCredentials bool          `mapstructure:"allow_credentials"`
+	MaxAge           time.Duration `mapstructure:"max_age"`
+}
+
+// CORS is disabled when there are no allowed origins
+func (c CORSConfig) Enabled() bool {
+	return len(c.AllowedOrigins) > 0
+}
+
+// Lists from env are comma separated, spaces and empty items are removed
+func (c *CORSConfig) trim() {
+	c.AllowedOrigins = trimList(c.AllowedOrigins)
+	c.AllowedMethods = trimList(c.AllowedMethods)
+	c.AllowedHeaders = trimList(c.AllowedHeaders)
+	c.ExposedHeaders = trimList(c.ExposedHeaders)
+}
+
+// Background checks of clients used by /health and probes
+type HealthConfig struct {
+	Interval time.Duration `mapstructure:"interval"`
+	Timeout  time.Duration `mapstructure:"timeout"`
+}
+
+type OutboxConfig struct {
+	Interval  time.Duration `mapstructure:"interval"`
+	BatchSize int           `mapstructure:"batch_size"`
+	// Message which is not published in this time is retried later, batch is stopped
+	PublishTimeout time.Duration `mapstructure:"publish_timeout"`
+}
+
+type ConsumerConfig struct {
+	// Maximum number of messages handled at once
+	MaxMessages int           `mapstructure:"max_messages"`
+	Wait        time.Duration `mapstructure:"wait"`
+	MaxRetries  int           `mapstructure:"max_retries"`
+	MinBackoff  time.Duration `mapstructure:"min_backoff"`
+	MaxBackoff  time.Duration `mapstructure:"max_backoff"`
+}
+
+type RateLimitConfig struct {
+	// Zero value disables rate limit
+	Requests int           `mapstructure:"requests"`
+	Period   time.Duration `mapstructure:"period"`
+	Burst    int           `mapstructure:"burst"`
+}
+
+func (c RateLimitConfig) Limit() ratelimit.Limit {
+	return ratelimit.Limit{
+		Requests: c.Requests,
+		Period:   c.Period,
+		Burst:    c.Burst,
+	}
+}
+
+// Overrides settings of endpoint from code
+type EndpointConfig struct {
+	Timeout   time.Duration    `mapstructure:"timeout"`
+	RateLimit *RateLimitConfig `mapstructure:"rate_limit"`
+}
+
+// Vault is used to resolve credentials when Addr is set
+type VaultConfig struct {
+	Addr  string `mapstructure:"addr"`
+	Token string `mapstructure:"token"`
+	Mount string `mapstructure:"mount"`
+	Path  string `mapstructure:"path"`
 }
 
 type Config struct {
-	DB         *database.Credentials
-	RabbitMQ   *rabbitmq.Credentials
-	Redis      *redis.Credentials
-	Tracer     *TracerConfig
-	Port       string
-	Production bool
-}
-
-func Read() (*Config, error) {
-	var envCfg Config
-	viper.AutomaticEnv()
-	isProduction := viper.GetBool(ENV_PRODUCTION)
-
-	result := make(map[string]string, len(envVariables))
-	for _, name := range envVariables {
-		value := os.Getenv(name)
+	Production bool           `mapstructure:"production"`
+	Log        LogConfig      `mapstructure:"log"`
+	Server     ServerConfig   `mapstructure:"server"`
+	DB         DBConfig       `mapstructure:"db"`
+	RabbitMQ   RabbitMQConfig `mapstructure:"rabbitmq"`
+	Redis      RedisConfig    `mapstructure:"redis"`
+	Tracer     TracerConfig   `mapstructure:"tracer"`
+	Storage    StorageConfig  `mapstructure:"storage"`
+	JWT        JWTConfig      `mapstructure:"jwt"`
+	CORS       CORSConfig     `mapstructure:"cors"`
+	Health     HealthConfig   `mapstructure:"health"`
+	Outbox     OutboxConfig   `mapstructure:"outbox"`
+	Consumer   ConsumerConfig `mapstructure:"consumer"`
+	Vault      VaultConfig    `mapstructure:"vault"`
+	// Feature flags by lower-cased name
+	Features map[string]bool `mapstructure:"features"`
+	// Endpoint overrides by method and route pattern, for example "POST /user"
+	Endpoints map[string]EndpointConfig `mapstructure:"endpoints"`
+	// Path of config file, empty when config is read without file
+	File string `mapstructure:"-"`
+}
+
+// Credential fields by name of env variable, which is also name of secret in providers
+func (c *Config) secretFields() map[string]*string {
+	return map[string]*string{
+		ENV_DB_USER:           &c.DB.User,
+		ENV_DB_PASSWORD:       &c.DB.Password,
+		ENV_RABBITMQ_USERNAME: &c.RabbitMQ.Username,
+		ENV_RABBITMQ_PASSWORD: &c.RabbitMQ.Password,
+		ENV_REDIS_PASSWORD:    &c.Redis.Password,
+		ENV_S3_ACCESS_KEY:     &c.Storage.S3.AccessKey,
+		ENV_S3_SECRET_KEY:     &c.Storage.S3.SecretKey,
+		ENV_JWT_HMAC_SECRET:   &c.JWT.HMACSecret,
+	}
+}
+
+// Reads config from layers where every next one overrides previous:
+// defaults, YAML or TOML file from --config flag or CONFIG_FILE env, env variables and command line flags.
+// Credentials are resolved with secrets providers after that, see resolveSecrets.
+func Read(ctx context.Context, args []string) (*Config, error) {
+	v := viper.New()
+	for key, value := range defaults {
+		v.SetDefault(key, value)
+	}
+
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+	for key, env := range envBindings {
+		if err := v.BindEnv(key, env); err != nil {
+			return nil, err
+		}
+	}
+
+	flags := pflag.NewFlagSet("server", pflag.ContinueOnError)
+	configFile := flags.String(FLAG_CONFIG, os.Getenv(ENV_CONFIG_FILE), "path to YAML or TOML config file")
+	flags.String(FLAG_PORT, DEFAULT_PORT, "HTTP server port")
+	flags.Bool(FLAG_PRODUCTION, false, "production mode")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if err := v.BindPFlag("server.port", flags.Lookup(FLAG_PORT)); err != nil {
+		return nil, err
+	}
+	if err := v.BindPFlag("production", flags.Lookup(FLAG_PRODUCTION)); err != nil {
+		return nil, err
+	}
+
+	if *configFile != "" {
+		v.SetConfigFile(*configFile)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("read config file: %w", err)
+		}
+	}
+
+	var cfg Config
+	if err := v.Unmarshal(&cfg); err != nil {
+		return nil, fmt.Errorf("decode config: %w", err)
+	}
+	cfg.File = *configFile
+	cfg.CORS.trim()
+	if err := cfg.resolveSecrets(ctx); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Overrides credential fields with secrets found by providers in order:
+// file from "<NAME>_FILE" env, env variable and Vault KV v2 secret when VAULT_ADDR is set.
+// Vault token is resolved the same way, vault.token from config file is used when it is not found.
+// Fields without secret keep value from config file or defaults.
+func (c *Config) resolveSecrets(ctx context.Context) error {
+	local := secrets.Chain{secrets.File{}, secrets.Env{}}
+	providers := local
+
+	if c.Vault.Addr != "" {
+		token, err := local.Get(ctx, ENV_VAULT_TOKEN)
+		if errors.Is(err, secrets.ErrNotFound) {
+			token, err = c.Vault.Token, nil
+		}
+		if err != nil {
+			return fmt.Errorf("vault token: %w", err)
+		}
+		vault, err := secrets.NewVault(secrets.VaultConfig{
+			Addr:  c.Vault.Addr,
+			Token: token,
+			Mount: c.Vault.Mount,
+			Path:  c.Vault.Path,
+		})
+		if err != nil {
+			return err
+		}
+		providers = append(providers, vault)
+	}
+
+	for name, field := range c.secretFields() {
+		value, err := providers.Get(ctx, name)
+		if errors.Is(err, secrets.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("secret %s: %w", name, err)
+		}
+		*field = value
+	}
+	return nil
+}
+
+// Returns all problems of config at once
+func (c *Config) Validate() error {
+	var errs []error
+	required := func(key, value string) {
 		if value == "" {
-			return nil, fmt.Errorf("env %q is empty", name)
+			errs = append(errs, fmt.Errorf("%s is required", key))
+		}
+	}
+	positive := func(key string, value int64) {
+		if value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", key))
 		}
-		result[name] = value
 	}
 
-	dbCreds := &database.Credentials{
-		Username: result[ENV_DB_USER],
-		Password: result[ENV_DB_PASSWORD],
-		DBName:   result[ENV_DB_NAME],
-		Host:     result[ENV_DB_HOST],
+	if _, ok := logLevels[strings.ToLower(c.Log.Level)]; !ok {
+		errs = append(errs, fmt.Errorf("log.level %q is not supported", c.Log.Level))
 	}
 
-	if result[ENV_DB_SSL_MODE] != "" {
-		sslMode := result[ENV_DB_SSL_MODE]
-		dbCreds.SSLMode = &sslMode
+	validatePort(&errs, "server.port", c.Server.Port)
+	validatePort(&errs, "server.admin_port", c.Server.AdminPort)
+	if c.Server.Port == c.Server.AdminPort {
+		errs = append(errs, errors.New("server.admin_port must differ from server.port"))
 	}
+	positive("server.read_timeout", int64(c.Server.ReadTimeout))
+	positive("server.write_timeout", int64(c.Server.WriteTimeout))
+	positive("server.grace_period", int64(c.Server.GracePeriod))
+	validateRateLimit(&errs, "server.ip_rate_limit", c.Server.IPRateLimit)
+	for _, proxy := range trimList(c.Server.TrustedProxies) {
+		if _, err := parseProxy(proxy); err != nil {
+			errs = append(errs, fmt.Errorf("server.trusted_proxies %q is not valid IP address or CIDR", proxy))
+		}
+	}
+	if c.Server.DrainDelay < 0 || c.Server.DrainDelay >= c.Server.GracePeriod {
+		errs = append(errs, errors.New("server.drain_delay must not be negative and must be less than server.grace_period"))
+	}
+
+	required("db.host", c.DB.Host)
+	required("db.name", c.DB.Name)
+	required("db.user", c.DB.User)
+
+	if c.RabbitMQ.Enabled {
+		required("rabbitmq.host", c.RabbitMQ.Host)
+		required("rabbitmq.queue", c.RabbitMQ.Queue)
+	}
+
+	if c.Redis.Enabled {
+		required("redis.host", c.Redis.Host)
+	}
+
+	if c.Tracer.Enabled {
+		required("tracer.url", c.Tracer.URL)
+		required("tracer.name", c.Tracer.Name)
+		if c.Tracer.URL != "" {
+			validateURL(&errs, "tracer.url", c.Tracer.URL)
+		}
+	}
+
+	switch c.Storage.Driver {
+	case storage.DRIVER_Local:
+		required("storage.local_dir", c.Storage.LocalDir)
+	case storage.DRIVER_S3:
+		required("storage.s3.endpoint", c.Storage.S3.Endpoint)
+		required("storage.s3.bucket", c.Storage.S3.Bucket)
+		required("storage.s3.access_key", c.Storage.S3.AccessKey)
+		required("storage.s3.secret_key", c.Storage.S3.SecretKey)
+		if c.Storage.S3.Endpoint != "" {
+			validateURL(&errs, "storage.s3.endpoint", c.Storage.S3.Endpoint)
+		}
+	default:
+		errs = append(errs, fmt.Errorf("storage.driver %q is not supported", c.Storage.Driver))
+	}
+
+	if c.JWT.JWKSURL != "" {
+		validateURL(&errs, "jwt.jwks_url", c.JWT.JWKSURL)
+	}
+	if c.JWT.JWKSTTL < 0 || c.JWT.ClockSkew < 0 {
+		errs = append(errs, errors.New("jwt.jwks_ttl and jwt.clock_skew must not be negative"))
+	}
+
+	if c.CORS.MaxAge < 0 {
+		errs = append(errs, errors.New("cors.max_age must not be negative"))
+	}
+	if c.CORS.AllowCredentials && slices.Contains(trimList(c.CORS.AllowedOrigins), "*") {
+		errs = append(errs, errors.New("cors.allow_credentials can not be used with \"*\" in cors.allowed_origins"))
+	}
+
+	for key, endpoint := range c.Endpoints {
+		if method, path, ok := strings.Cut(key, " "); !ok || method == "" || !strings.HasPrefix(path, "/") {
+			errs = append(errs, fmt.Errorf("endpoints key %q must be method and route pattern", key))
+		}
+		if endpoint.Timeout < 0 {
+			errs = append(errs, fmt.Errorf("endpoints.%s.timeout must not be negative", key))
+		}
+		if err := ValidateEndpointTimeout("endpoints."+key+".timeout", endpoint.Timeout, c.Server.WriteTimeout); err != nil {
+			errs = append(errs, err)
+		}
+		if endpoint.RateLimit != nil {
+			validateRateLimit(&errs, "endpoints."+key+".rate_limit", *endpoint.RateLimit)
+		}
+	}
+
+	positive("health.interval", int64(c.Health.Interval))
+	positive("health.timeout", int64(c.Health.Timeout))
 
-	rabbitMQCreds := &rabbitmq.Credentials{
-		Host:     result[ENV_RABBITMQ_HOST],
-		Username: result[ENV_RABBITMQ_USERNAME],
-		Password: result[ENV_RABBITMQ_PASSWORD],
+	positive("outbox.interval", int64(c.Outbox.Interval))
+	positive("outbox.batch_size", int64(c.Outbox.BatchSize))
+	positive("outbox.publish_timeout", int64(c.Outbox.PublishTimeout))
+
+	positive("consumer.max_messages", int64(c.Consumer.MaxMessages))
+	positive("consumer.wait", int64(c.Consumer.Wait))
+	positive("consumer.min_backoff", int64(c.Consumer.MinBackoff))
+	if c.Consumer.MaxRetries < 0 {
+		errs = append(errs, errors.New("consumer.max_retries must not be negative"))
+	}
+	if c.Consumer.MaxBackoff < c.Consumer.MinBackoff {
+		errs = append(errs, errors.New("consumer.max_backoff must not be less than consumer.min_backoff"))
 	}
 
-	redisCreds := &redis.Credentials{
-		Host:     result[ENV_REDIS_HOST],
-		Password: result[ENV_REDIS_PASSWORD],
+	return errors.Join(errs...)
+}
+
+// Server needs time to send 504 before it closes connection on write timeout.
+// Applied to timeouts of endpoints from code and from config, name describes checked timeout in error.
+func ValidateEndpointTimeout(name string, timeout time.Duration, writeTimeout time.Duration) error {
+	if writeTimeout > 0 && timeout+time.Second > writeTimeout {
+		return fmt.Errorf("%s must be at least 1s less than server.write_timeout %s", name, writeTimeout)
 	}
+	return nil
+}
 
-	envCfg = Config{
-		DB:       dbCreds,
-		RabbitMQ: rabbitMQCreds,
-		Redis:    redisCreds,
-		Tracer: &TracerConfig{
-			URL:  result[ENV_TRACER_URL],
-			Name: result[ENV_TRACER_NAME],
-		},
-		Port:       result[ENV_PORT],
-		Production: isProduction,
+func validateRateLimit(errs *[]error, key string, limit RateLimitConfig) {
+	if limit.Requests < 0 || limit.Burst < 0 {
+		*errs = append(*errs, fmt.Errorf("%s must not be negative", key))
 	}
+	if limit.Requests > 0 && limit.Period <= 0 {
+		*errs = append(*errs, fmt.Errorf("%s.period must be positive", key))
+	}
+}
+
+func validatePort(errs *[]error, key, value string) {
+	if port, err := strconv.Atoi(value); err != nil || port < 1 || port > 65535 {
+		*errs = append(*errs, fmt.Errorf("%s %q is not valid port", key, value))
+	}
+}
 
-	return &envCfg, nil
+func validateURL(errs *[]error, key, value string) {
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		*errs = append(*errs, fmt.Errorf("%s %q is not valid URL", key, value))
+	}
+}
+
+// Trims items of comma separated list from env
+func trimList(items []string) []string {
+	var result []string
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
 }
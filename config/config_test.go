@@ -0,0 +1,219 @@
+package config
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setRequiredEnv(t *testing.T) {
+	t.Setenv(ENV_DB_HOST, "localhost")
+	t.Setenv(ENV_DB_NAME, "test")
+	t.Setenv(ENV_DB_USER, "root")
+	t.Setenv(ENV_RABBITMQ_HOST, "localhost:5672")
+	t.Setenv(ENV_REDIS_HOST, "localhost:6379")
+	t.Setenv(ENV_TRACER_URL, "http://localhost:14268/api/traces")
+	t.Setenv(ENV_TRACER_NAME, "test")
+}
+
+func TestRead(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		setRequiredEnv(t)
+		t.Setenv(ENV_REDIS_PASSWORD, "")
+
+		cfg, err := Read(context.Background(), nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, DEFAULT_PORT, cfg.Server.Port)
+		assert.Equal(t, DEFAULT_ADMIN_PORT, cfg.Server.AdminPort)
+		assert.Equal(t, DEFAULT_SERVER_WRITE_TIMEOUT, cfg.Server.WriteTimeout)
+		assert.Equal(t, DEFAULT_RABBITMQ_QUEUE, cfg.RabbitMQ.Queue)
+		assert.True(t, cfg.RabbitMQ.Enabled && cfg.Redis.Enabled && cfg.Tracer.Enabled)
+		assert.Equal(t, DEFAULT_STORAGE_LOCAL_DIR, cfg.Storage.LocalDir)
+		assert.Equal(t, DEFAULT_CONSUMER_MAX_BACKOFF, cfg.Consumer.MaxBackoff)
+		assert.Empty(t, cfg.Redis.Password)
+		assert.False(t, cfg.JWT.Enabled())
+		assert.False(t, cfg.CORS.Enabled())
+		assert.Nil(t, cfg.DB.Credentials().SSLMode)
+	})
+
+	t.Run("Layers", func(t *testing.T) {
+		setRequiredEnv(t)
+		file := filepath.Join(t.TempDir(), "config.yaml")
+		err := os.WriteFile(file, []byte(`
+server:
+  port: "9000"
+  write_timeout: 1m
+db:
+  name: file
+  ssl_mode: disable
+outbox:
+  batch_size: 10
+cors:
+  allowed_origins:
+    - https://app.example.com
+`), 0o644)
+		if !assert.NoError(t, err) {
+			return
+		}
+		t.Setenv(ENV_CONFIG_FILE, file)
+		t.Setenv(ENV_PORT, "9001")
+		t.Setenv("OUTBOX_BATCH_SIZE", "20")
+		t.Setenv(ENV_CORS_ALLOWED_METHODS, "GET, POST")
+		t.Setenv(ENV_S3_BUCKET, "files")
+
+		cfg, err := Read(context.Background(), []string{"--port", "9002"})
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "9002", cfg.Server.Port)
+		assert.Equal(t, time.Minute, cfg.Server.WriteTimeout)
+		assert.Equal(t, "test", cfg.DB.Name)
+		assert.Equal(t, "disable", *cfg.DB.Credentials().SSLMode)
+		assert.Equal(t, 20, cfg.Outbox.BatchSize)
+		assert.Equal(t, "files", cfg.Storage.S3.Bucket)
+		assert.Equal(t, []string{"https://app.example.com"}, cfg.CORS.AllowedOrigins)
+		assert.Equal(t, []string{"GET", "POST"}, cfg.CORS.AllowedMethods)
+	})
+
+	t.Run("Secrets", func(t *testing.T) {
+		setRequiredEnv(t)
+		vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v1/secret/data/http_template" || r.Header.Get("X-Vault-Token") != "root" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Write([]byte(`{"data":{"data":{"DB_PASSWORD":"from-vault","REDIS_PASSWORD":"from-vault","RABBITMQ_PASSWORD":"from-vault"}}}`))
+		}))
+		defer vault.Close()
+
+		dir := t.TempDir()
+		tokenFile := filepath.Join(dir, "vault_token")
+		passwordFile := filepath.Join(dir, "redis_password")
+		assert.NoError(t, os.WriteFile(tokenFile, []byte("root\n"), 0o600))
+		assert.NoError(t, os.WriteFile(passwordFile, []byte("from-file\n"), 0o600))
+
+		t.Setenv(ENV_VAULT_ADDR, vault.URL)
+		t.Setenv(ENV_VAULT_PATH, "http_template")
+		t.Setenv(ENV_VAULT_TOKEN+"_FILE", tokenFile)
+		t.Setenv(ENV_REDIS_PASSWORD+"_FILE", passwordFile)
+		t.Setenv(ENV_RABBITMQ_PASSWORD, "from-env")
+
+		cfg, err := Read(context.Background(), nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "from-vault", cfg.DB.Password)
+		assert.Equal(t, "from-file", cfg.Redis.Password)
+		assert.Equal(t, "from-env", cfg.RabbitMQ.Password)
+		assert.Equal(t, "root", cfg.DB.User)
+	})
+
+	t.Run("Vault token from config file", func(t *testing.T) {
+		setRequiredEnv(t)
+		vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Vault-Token") != "from-file" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Write([]byte(`{"data":{"data":{"DB_PASSWORD":"from-vault"}}}`))
+		}))
+		defer vault.Close()
+
+		file := filepath.Join(t.TempDir(), "config.yaml")
+		assert.NoError(t, os.WriteFile(file, []byte("vault:\n  addr: "+vault.URL+"\n  token: from-file\n  path: http_template\n"), 0o600))
+		t.Setenv(ENV_VAULT_TOKEN, "")
+
+		cfg, err := Read(context.Background(), []string{"--config", file})
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "from-vault", cfg.DB.Password)
+	})
+
+	t.Run("Vault unavailable", func(t *testing.T) {
+		setRequiredEnv(t)
+		t.Setenv(ENV_VAULT_ADDR, "http://localhost:8200")
+		t.Setenv(ENV_VAULT_TOKEN, "")
+		t.Setenv(ENV_VAULT_PATH, "http_template")
+
+		_, err := Read(context.Background(), nil)
+		assert.ErrorContains(t, err, "vault token is required")
+	})
+
+	t.Run("Disabled integrations", func(t *testing.T) {
+		setRequiredEnv(t)
+		for _, env := range []string{ENV_RABBITMQ_HOST, ENV_REDIS_HOST, ENV_TRACER_URL, ENV_TRACER_NAME} {
+			t.Setenv(env, "")
+		}
+		t.Setenv(ENV_RABBITMQ_ENABLED, "false")
+		t.Setenv(ENV_REDIS_ENABLED, "false")
+		t.Setenv(ENV_TRACER_ENABLED, "false")
+
+		cfg, err := Read(context.Background(), nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.False(t, cfg.RabbitMQ.Enabled)
+		assert.False(t, cfg.Redis.Enabled)
+		assert.False(t, cfg.Tracer.Enabled)
+	})
+
+	t.Run("Invalid file", func(t *testing.T) {
+		setRequiredEnv(t)
+		_, err := Read(context.Background(), []string{"--config", filepath.Join(t.TempDir(), "missing.yaml")})
+		assert.ErrorContains(t, err, "read config file")
+	})
+
+	t.Run("Invalid duration", func(t *testing.T) {
+		setRequiredEnv(t)
+		t.Setenv("CONSUMER_WAIT", "five seconds")
+		_, err := Read(context.Background(), nil)
+		assert.ErrorContains(t, err, "decode config")
+	})
+}
+
+func TestValidate(t *testing.T) {
+	t.Setenv(ENV_CONFIG_FILE, "")
+	t.Setenv(ENV_PORT, "port")
+	t.Setenv(ENV_ADMIN_PORT, "70000")
+	t.Setenv(ENV_STORAGE_DRIVER, "s3")
+	t.Setenv(ENV_S3_ENDPOINT, "minio:9000")
+	t.Setenv(ENV_TRACER_URL, "http://localhost:14268/api/traces")
+	t.Setenv("CONSUMER_MIN_BACKOFF", "1m")
+	t.Setenv("CONSUMER_MAX_BACKOFF", "1s")
+	t.Setenv(ENV_CORS_ALLOWED_ORIGINS, "https://example.com, *")
+	t.Setenv(ENV_CORS_ALLOW_CREDENTIALS, "true")
+	t.Setenv("SERVER_DRAIN_DELAY", "30s")
+	t.Setenv("SERVER_IP_RATE_LIMIT_REQUESTS", "-1")
+	t.Setenv("SERVER_TRUSTED_PROXIES", "10.0.0.0/8,proxy")
+
+	_, err := Read(context.Background(), nil)
+	for _, expected := range []string{
+		`server.port "port" is not valid port`,
+		`server.admin_port "70000" is not valid port`,
+		"db.host is required",
+		"db.name is required",
+		"db.user is required",
+		"rabbitmq.host is required",
+		"redis.host is required",
+		"tracer.name is required",
+		"storage.s3.bucket is required",
+		`storage.s3.endpoint "minio:9000" is not valid URL`,
+		"consumer.max_backoff must not be less than consumer.min_backoff",
+		"server.drain_delay must not be negative and must be less than server.grace_period",
+		"server.ip_rate_limit must not be negative",
+		`server.trusted_proxies "proxy" is not valid IP address or CIDR`,
+		`cors.allow_credentials can not be used with "*" in cors.allowed_origins`,
+	} {
+		assert.ErrorContains(t, err, expected)
+	}
+	assert.NotContains(t, err.Error(), "tracer.url")
+}
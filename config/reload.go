@@ -0,0 +1,158 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Moranilt/http-utils/logger"
+	"github.com/fsnotify/fsnotify"
+)
+
+// Editors and Kubernetes produce several events for one change
+const RELOAD_DEBOUNCE = 100 * time.Millisecond
+
+// Keeps current config and replaces it on reload.
+// Only log, cors, features and endpoints sections are applied, changes of other fields
+// require restart and are reverted with warning.
+type Reloader struct {
+	args   []string
+	logger logger.Logger
+
+	current atomic.Pointer[Config]
+	// serializes reloads and notifications
+	mu          sync.Mutex
+	subscribers []func(cfg *Config)
+}
+
+// Args are command line arguments used to read cfg, they are used again on every reload
+func NewReloader(cfg *Config, args []string, log logger.Logger) *Reloader {
+	r := &Reloader{args: args, logger: log}
+	r.current.Store(cfg)
+	return r
+}
+
+// Current config, it must not be modified
+func (r *Reloader) Config() *Config {
+	return r.current.Load()
+}
+
+// Calls fn with current config and after every successful reload
+func (r *Reloader) Subscribe(fn func(cfg *Config)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subscribers = append(r.subscribers, fn)
+	fn(r.current.Load())
+}
+
+// Reads and validates config again. Current config is kept when new one is not valid.
+func (r *Reloader) Reload(ctx context.Context) error {
+	loaded, err := Read(ctx, r.args)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next := *r.current.Load()
+	// write timeout of running server is not changed by reload
+	var errs []error
+	for key, endpoint := range loaded.Endpoints {
+		errs = append(errs, ValidateEndpointTimeout("endpoints."+key+".timeout", endpoint.Timeout, next.Server.WriteTimeout))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	next.Log = loaded.Log
+	next.CORS = loaded.CORS
+	next.Features = loaded.Features
+	next.Endpoints = loaded.Endpoints
+	for _, field := range changedFields("", reflect.ValueOf(next), reflect.ValueOf(*loaded)) {
+		r.logger.Notice("config field can not be changed without restart", "field", field)
+	}
+
+	r.current.Store(&next)
+	for _, fn := range r.subscribers {
+		fn(&next)
+	}
+	r.logger.Info("config reloaded", "file", next.File)
+	return nil
+}
+
+// Reloads config when its file is changed until ctx is done. Returns immediately when config has no file.
+func (r *Reloader) Watch(ctx context.Context) error {
+	file := r.Config().File
+	if file == "" {
+		return nil
+	}
+	file = filepath.Clean(file)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	// directory is watched because editors and Kubernetes replace file instead of writing it
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		return err
+	}
+
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			// Kubernetes swaps "..data" symlink when ConfigMap is updated
+			if filepath.Clean(event.Name) == file || filepath.Base(event.Name) == "..data" {
+				debounce = time.After(RELOAD_DEBOUNCE)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			r.logger.Error("config watcher", "error", err)
+		case <-debounce:
+			debounce = nil
+			if err := r.Reload(ctx); err != nil {
+				r.logger.Error("config reload", "error", err)
+			}
+		}
+	}
+}
+
+// Returns keys of fields which differ, nested structures are compared field by field
+func changedFields(prefix string, old, new reflect.Value) []string {
+	if old.Kind() != reflect.Struct {
+		if reflect.DeepEqual(old.Interface(), new.Interface()) {
+			return nil
+		}
+		return []string{prefix}
+	}
+
+	var fields []string
+	for i := 0; i < old.NumField(); i++ {
+		tag := old.Type().Field(i).Tag.Get("mapstructure")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		key := tag
+		if prefix != "" {
+			key = prefix + "." + tag
+		}
+		fields = append(fields, changedFields(key, old.Field(i), new.Field(i))...)
+	}
+	return fields
+}
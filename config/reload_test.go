@@ -0,0 +1,161 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Moranilt/http-utils/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+const reloadConfig = `
+server:
+  port: "9000"
+log:
+  level: info
+features:
+  new_checkout: false
+`
+
+func writeConfig(t *testing.T, file, content string) {
+	t.Helper()
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv(ENV_PORT, "")
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, file, reloadConfig)
+
+	args := []string{"--config", file}
+	cfg, err := Read(context.Background(), args)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var logs bytes.Buffer
+	reloader := NewReloader(cfg, args, logger.New(&logs, logger.TYPE_JSON))
+	var notified []*Config
+	reloader.Subscribe(func(cfg *Config) {
+		notified = append(notified, cfg)
+	})
+	assert.Equal(t, []*Config{cfg}, notified)
+
+	t.Run("Applied", func(t *testing.T) {
+		writeConfig(t, file, `
+server:
+  port: "9001"
+log:
+  level: error
+features:
+  New_Checkout: true
+endpoints:
+  POST /user:
+    timeout: 2s
+    rate_limit:
+      requests: 1
+      period: 1m
+cors:
+  allowed_origins:
+    - https://app.example.com
+`)
+		if !assert.NoError(t, reloader.Reload(context.Background())) {
+			return
+		}
+
+		current := reloader.Config()
+		assert.Len(t, notified, 2)
+		assert.Same(t, current, notified[1])
+		assert.Equal(t, "9000", current.Server.Port)
+		assert.Contains(t, logs.String(), `"field":"server.port"`)
+		assert.Equal(t, logger.LevelError, current.Log.SlogLevel())
+		assert.True(t, current.Features["new_checkout"])
+		assert.True(t, current.CORS.Enabled())
+		if endpoint, ok := current.Endpoints["post /user"]; assert.True(t, ok) {
+			assert.Equal(t, 2*time.Second, endpoint.Timeout)
+			assert.Equal(t, 1, endpoint.RateLimit.Requests)
+		}
+		assert.False(t, cfg.Features["new_checkout"], "previous config must not be modified")
+	})
+
+	t.Run("Not valid", func(t *testing.T) {
+		writeConfig(t, file, `
+log:
+  level: verbose
+`)
+		err := reloader.Reload(context.Background())
+		assert.ErrorContains(t, err, `log.level "verbose" is not supported`)
+		assert.Len(t, notified, 2)
+		assert.Equal(t, logger.LevelError, reloader.Config().Log.SlogLevel())
+	})
+
+	t.Run("Endpoint timeout exceeds write timeout", func(t *testing.T) {
+		writeConfig(t, file, `
+server:
+  write_timeout: 5m
+endpoints:
+  POST /user:
+    timeout: 2m
+`)
+		err := reloader.Reload(context.Background())
+		assert.ErrorContains(t, err, "endpoints.post /user.timeout must be at least 1s less than server.write_timeout 40s")
+		assert.Len(t, notified, 2)
+	})
+}
+
+func TestWatch(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv(ENV_PORT, "")
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, file, reloadConfig)
+
+	args := []string{"--config", file}
+	cfg, err := Read(context.Background(), args)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	reloader := NewReloader(cfg, args, logger.New(io.Discard, logger.TYPE_JSON))
+	notified := make(chan *Config, 10)
+	reloader.Subscribe(func(cfg *Config) {
+		notified <- cfg
+	})
+	<-notified
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- reloader.Watch(ctx)
+	}()
+	// let watcher start before file is changed
+	time.Sleep(50 * time.Millisecond)
+
+	// replace file like editors do
+	tmp := file + ".tmp"
+	writeConfig(t, tmp, `
+features:
+  new_checkout: true
+`)
+	if !assert.NoError(t, os.Rename(tmp, file)) {
+		cancel()
+		return
+	}
+
+	select {
+	case cfg := <-notified:
+		assert.True(t, cfg.Features["new_checkout"])
+	case <-time.After(5 * time.Second):
+		t.Error("config is not reloaded")
+	}
+
+	cancel()
+	assert.NoError(t, <-done)
+}
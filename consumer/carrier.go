@@ -0,0 +1,28 @@
+package consumer
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// tableCarrier adapts amqp.Table to propagation.TextMapCarrier
+type tableCarrier amqp.Table
+
+func (c tableCarrier) Get(key string) string {
+	value, ok := c[key].(string)
+	if !ok {
+		return ""
+	}
+	return value
+}
+
+func (c tableCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+func (c tableCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -0,0 +1,353 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Moranilt/http-utils/clients/rabbitmq"
+	"github.com/Moranilt/http-utils/logger"
+	"github.com/Moranilt/http_template/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const TracerName string = "consumer"
+
+// Policy describes what to do with delivery when handler returns an error.
+type Policy int
+
+const (
+	// Nack message and put it back to the queue
+	POLICY_Requeue Policy = iota
+	// Nack message without requeue. Message will be dropped or dead-lettered by broker
+	POLICY_Reject
+	// Ack message even if handler failed
+	POLICY_Ack
+	// Schedule delayed redelivery with exponential backoff.
+	// Message is moved to dead letters when retries are exhausted. Requires Registry.SetRetry
+	POLICY_Retry
+	// Store message in dead letters. Message is rejected when Registry.SetRetry is not configured
+	POLICY_DeadLetter
+)
+
+func (p Policy) String() string {
+	switch p {
+	case POLICY_Requeue:
+		return "requeue"
+	case POLICY_Reject:
+		return "reject"
+	case POLICY_Ack:
+		return "ack"
+	case POLICY_Retry:
+		return "retry"
+	case POLICY_DeadLetter:
+		return "dead_letter"
+	}
+	return fmt.Sprintf("policy(%d)", int(p))
+}
+
+// Typed handler of decoded message payload
+type HandlerFunc[T any] func(ctx context.Context, msg *T) error
+
+type Options struct {
+	// Policy applied when handler returns an error
+	OnError Policy
+}
+
+type handler struct {
+	handle func(ctx context.Context, payload []byte) error
+	opts   Options
+}
+
+// Registry routes deliveries to handlers registered by message type.
+type Registry struct {
+	log       logger.Logger
+	handlers  map[string]*handler
+	unknown   Policy
+	retrier   Retrier
+	retryCfg  RetryConfig
+	propagate propagation.TextMapPropagator
+	mu        sync.RWMutex
+
+	// number of running handlers
+	running  atomic.Int64
+	stopping atomic.Bool
+	// canceled when Stop stops waiting for running handlers
+	abortCtx context.Context
+	abort    context.CancelFunc
+}
+
+// Interval of checking running handlers in Stop
+const STOP_POLL_INTERVAL = 50 * time.Millisecond
+
+func New(log logger.Logger) *Registry {
+	abortCtx, abort := context.WithCancel(context.Background())
+	return &Registry{
+		log:       log.With("component", TracerName),
+		handlers:  make(map[string]*handler),
+		unknown:   POLICY_DeadLetter,
+		propagate: otel.GetTextMapPropagator(),
+		abortCtx:  abortCtx,
+		abort:     abort,
+	}
+}
+
+// Stops handling new deliveries(they are requeued) and waits for running handlers until ctx is done.
+// Handlers which are still running after that get canceled context.
+func (r *Registry) Stop(ctx context.Context) error {
+	r.stopping.Store(true)
+	ticker := time.NewTicker(STOP_POLL_INTERVAL)
+	defer ticker.Stop()
+	for r.running.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			r.abort()
+			return fmt.Errorf("%d handlers are still running: %w", r.running.Load(), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// Set policy for messages without registered handler. Default is POLICY_DeadLetter,
+// so messages of unknown type are kept. Use POLICY_Ack to drop them.
+func (r *Registry) SetUnknownPolicy(p Policy) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.unknown = p
+}
+
+// Enable POLICY_Retry and dead letters for decode errors
+func (r *Registry) SetRetry(retrier Retrier, cfg RetryConfig) {
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = DEFAULT_MIN_BACKOFF
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = DEFAULT_MAX_BACKOFF
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.retrier = retrier
+	r.retryCfg = cfg
+}
+
+// Register typed handler for message type. Payload is decoded from JSON into T.
+func Handle[T any](r *Registry, msgType string, fn HandlerFunc[T], opts Options) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[msgType] = &handler{
+		handle: func(ctx context.Context, payload []byte) error {
+			var msg T
+			if err := json.Unmarshal(payload, &msg); err != nil {
+				return &decodeError{err: err}
+			}
+			return fn(ctx, &msg)
+		},
+		opts: opts,
+	}
+}
+
+// Consume implements rabbitmq.ReadMsgCallback.
+// Handler is not canceled together with ctx, so it can finish while application is stopping, see Stop.
+func (r *Registry) Consume(ctx context.Context, d rabbitmq.RabbitDelivery) error {
+	// counter is increased before check, so Stop does not miss handler which is starting
+	r.running.Add(1)
+	defer r.running.Add(-1)
+	if r.stopping.Load() {
+		return d.Nack(false, true)
+	}
+
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+	defer context.AfterFunc(r.abortCtx, cancel)()
+
+	env := parseEnvelope(d)
+
+	ctx = r.propagate.Extract(ctx, tableCarrier(d.Header()))
+	if env.Traceparent != "" {
+		ctx = r.propagate.Extract(ctx, propagation.MapCarrier{
+			"traceparent": env.Traceparent,
+			"tracestate":  env.Tracestate,
+		})
+	}
+
+	ctx, span := otel.Tracer(TracerName).Start(ctx, env.Type, trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(
+		attribute.String("messaging.system", "rabbitmq"),
+		attribute.String("messaging.message.type", env.Type),
+		attribute.String("messaging.message.id", env.ID),
+		attribute.Int64("messaging.delivery_tag", int64(d.DeliveryTag())),
+		attribute.Bool("messaging.redelivered", d.Redelivered()),
+	))
+	defer span.End()
+
+	log := r.log.With(
+		"message_type", env.Type,
+		"event_id", env.ID,
+		"request_id", env.RequestID,
+		"delivery_tag", d.DeliveryTag(),
+		"redelivered", d.Redelivered(),
+		"trace_id", span.SpanContext().TraceID().String(),
+	)
+
+	r.mu.RLock()
+	h, ok := r.handlers[env.Type]
+	unknown := r.unknown
+	r.mu.RUnlock()
+
+	if !ok {
+		log.Notice("no handler for message type", "policy", unknown.String())
+		return r.apply(ctx, log, d, env, unknown, fmt.Errorf("no handler for message type %q", env.Type))
+	}
+
+	err := h.handle(ctx, env.Payload)
+	if err == nil {
+		if ackErr := d.Ack(false); ackErr != nil {
+			log.Error("ack", "error", ackErr)
+			return ackErr
+		}
+		log.Debug("message handled")
+		return nil
+	}
+
+	span.RecordError(err)
+	span.SetStatus(codes.Error, "handle")
+
+	policy := h.opts.OnError
+	if isDecodeError(err) {
+		// message will never be decoded, so there is no reason to requeue it
+		policy = POLICY_Reject
+		if r.hasRetrier() {
+			log.Error("handle message", "error", err, "policy", "dead_letter")
+			return r.deadLetter(ctx, log, d, env, err)
+		}
+	}
+	log.Error("handle message", "error", err, "policy", policy.String())
+	return r.apply(ctx, log, d, env, policy, err)
+}
+
+func (r *Registry) apply(ctx context.Context, log logger.Logger, d rabbitmq.RabbitDelivery, env models.Event, policy Policy, cause error) error {
+	switch policy {
+	case POLICY_Retry:
+		return r.retry(ctx, log, d, env, cause)
+	case POLICY_DeadLetter:
+		if !r.hasRetrier() {
+			log.Error("dead letters are not configured", "policy", POLICY_Reject.String())
+			return r.settle(log, d, POLICY_Reject, cause)
+		}
+		return r.deadLetter(ctx, log, d, env, cause)
+	}
+	return r.settle(log, d, policy, cause)
+}
+
+func (r *Registry) settle(log logger.Logger, d rabbitmq.RabbitDelivery, policy Policy, cause error) error {
+	var err error
+	switch policy {
+	case POLICY_Requeue:
+		err = d.Nack(false, true)
+	case POLICY_Reject:
+		err = d.Nack(false, false)
+	default:
+		err = d.Ack(false)
+	}
+	if err != nil {
+		log.Error("settle", "policy", policy.String(), "error", err)
+		return err
+	}
+	return cause
+}
+
+func (r *Registry) hasRetrier() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.retrier != nil
+}
+
+func (r *Registry) retry(ctx context.Context, log logger.Logger, d rabbitmq.RabbitDelivery, env models.Event, cause error) error {
+	r.mu.RLock()
+	retrier, cfg := r.retrier, r.retryCfg
+	r.mu.RUnlock()
+
+	if retrier == nil {
+		log.Error("retry is not configured", "policy", POLICY_Reject.String())
+		return r.settle(log, d, POLICY_Reject, cause)
+	}
+
+	attempt := env.Attempt + 1
+	if attempt > cfg.MaxRetries {
+		return r.deadLetter(ctx, log, d, env, cause)
+	}
+
+	body, err := SetAttempt(d.Body(), env.Type, attempt)
+	if err != nil {
+		log.Error("set attempt", "error", err)
+		return r.deadLetter(ctx, log, d, env, cause)
+	}
+
+	delay := backoff(cfg, attempt)
+	if err := retrier.ScheduleRetry(ctx, env.Type, body, time.Now().Add(delay)); err != nil {
+		log.Error("schedule retry", "error", err)
+		return r.settle(log, d, POLICY_Requeue, cause)
+	}
+
+	retriesCounter.WithLabelValues(env.Type).Inc()
+	log.Info("retry scheduled", "attempt", attempt, "max_retries", cfg.MaxRetries, "delay", delay.String())
+	return r.settle(log, d, POLICY_Ack, cause)
+}
+
+func (r *Registry) deadLetter(ctx context.Context, log logger.Logger, d rabbitmq.RabbitDelivery, env models.Event, cause error) error {
+	r.mu.RLock()
+	retrier := r.retrier
+	r.mu.RUnlock()
+
+	if err := retrier.StoreDeadLetter(ctx, env.Type, d.Body(), env.Attempt+1, cause.Error()); err != nil {
+		// message which can not be decoded will fail again, so it is not requeued
+		policy := POLICY_Requeue
+		if isDecodeError(cause) {
+			policy = POLICY_Reject
+		}
+		log.Error("store dead letter", "error", err, "policy", policy.String())
+		return r.settle(log, d, policy, cause)
+	}
+
+	deadLettersCounter.WithLabelValues(env.Type).Inc()
+	log.Notice("message moved to dead letters", "attempts", env.Attempt+1)
+	return r.settle(log, d, POLICY_Ack, cause)
+}
+
+// Parse models.Event from delivery.
+// If message has no envelope the whole body is used as payload.
+func parseEnvelope(d rabbitmq.RabbitDelivery) models.Event {
+	var env models.Event
+	if err := json.Unmarshal(d.Body(), &env); err != nil || env.Payload == nil {
+		env.Payload = d.Body()
+	}
+	if d.Type() != "" {
+		env.Type = d.Type()
+	}
+	return env
+}
+
+type decodeError struct {
+	err error
+}
+
+func (e *decodeError) Error() string {
+	return "decode payload: " + e.err.Error()
+}
+
+func (e *decodeError) Unwrap() error {
+	return e.err
+}
+
+func isDecodeError(err error) bool {
+	var target *decodeError
+	return errors.As(err, &target)
+}
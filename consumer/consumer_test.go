@@ -0,0 +1,374 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	rabbitmq_mock "github.com/Moranilt/http-utils/clients/rabbitmq/mock"
+	"github.com/Moranilt/http-utils/logger"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type testMessage struct {
+	Name string `json:"name"`
+}
+
+func TestConsume(t *testing.T) {
+	log := logger.New(io.Discard, logger.TYPE_JSON)
+
+	t.Run("Envelope with registered handler", func(t *testing.T) {
+		registry := New(log)
+		var received *testMessage
+		Handle(registry, "test.created", func(ctx context.Context, msg *testMessage) error {
+			received = msg
+			return nil
+		}, Options{OnError: POLICY_Requeue})
+
+		d := rabbitmq_mock.NewDelivery(t, rabbitmq_mock.MockRabbitDeliveryFields{
+			Body: []byte(`{"type":"test.created","payload":{"name":"John"}}`),
+		})
+		d.ExpectAck(false, nil)
+
+		err := registry.Consume(context.Background(), d)
+		assert.NoError(t, err)
+		assert.Equal(t, &testMessage{Name: "John"}, received)
+		assert.NoError(t, d.AllExpectationsDone())
+	})
+
+	t.Run("Type from delivery property", func(t *testing.T) {
+		registry := New(log)
+		var received *testMessage
+		Handle(registry, "test.created", func(ctx context.Context, msg *testMessage) error {
+			received = msg
+			return nil
+		}, Options{})
+
+		d := rabbitmq_mock.NewDelivery(t, rabbitmq_mock.MockRabbitDeliveryFields{
+			MsgType: "test.created",
+			Body:    []byte(`{"name":"John"}`),
+		})
+		d.ExpectAck(false, nil)
+
+		err := registry.Consume(context.Background(), d)
+		assert.NoError(t, err)
+		assert.Equal(t, &testMessage{Name: "John"}, received)
+	})
+
+	t.Run("Handler error uses policy", func(t *testing.T) {
+		expectedError := errors.New("handler error")
+		registry := New(log)
+		Handle(registry, "test.created", func(ctx context.Context, msg *testMessage) error {
+			return expectedError
+		}, Options{OnError: POLICY_Requeue})
+
+		d := rabbitmq_mock.NewDelivery(t, rabbitmq_mock.MockRabbitDeliveryFields{
+			Body: []byte(`{"type":"test.created","payload":{"name":"John"}}`),
+		})
+		d.ExpectNack(false, true, nil)
+
+		err := registry.Consume(context.Background(), d)
+		assert.ErrorIs(t, err, expectedError)
+		assert.NoError(t, d.AllExpectationsDone())
+	})
+
+	t.Run("Decode error rejects message", func(t *testing.T) {
+		registry := New(log)
+		Handle(registry, "test.created", func(ctx context.Context, msg *testMessage) error {
+			return nil
+		}, Options{OnError: POLICY_Requeue})
+
+		d := rabbitmq_mock.NewDelivery(t, rabbitmq_mock.MockRabbitDeliveryFields{
+			Body: []byte(`{"type":"test.created","payload":{"name":1}}`),
+		})
+		d.ExpectNack(false, false, nil)
+
+		err := registry.Consume(context.Background(), d)
+		assert.Error(t, err)
+		assert.NoError(t, d.AllExpectationsDone())
+	})
+
+	t.Run("Unknown type without dead letters is rejected", func(t *testing.T) {
+		registry := New(log)
+
+		d := rabbitmq_mock.NewDelivery(t, rabbitmq_mock.MockRabbitDeliveryFields{
+			Body: []byte(`{"type":"unknown","payload":{}}`),
+		})
+		d.ExpectNack(false, false, nil)
+
+		err := registry.Consume(context.Background(), d)
+		assert.Error(t, err)
+		assert.NoError(t, d.AllExpectationsDone())
+	})
+}
+
+type scheduledRetry struct {
+	msgType string
+	body    []byte
+	at      time.Time
+}
+
+type fakeRetrier struct {
+	retries     []scheduledRetry
+	deadLetters []string
+	err         error
+}
+
+func (f *fakeRetrier) ScheduleRetry(ctx context.Context, msgType string, body []byte, at time.Time) error {
+	f.retries = append(f.retries, scheduledRetry{msgType, body, at})
+	return f.err
+}
+
+func (f *fakeRetrier) StoreDeadLetter(ctx context.Context, msgType string, body []byte, attempts int, reason string) error {
+	f.deadLetters = append(f.deadLetters, string(body))
+	return f.err
+}
+
+func TestRetry(t *testing.T) {
+	log := logger.New(io.Discard, logger.TYPE_JSON)
+	failing := func(ctx context.Context, msg *testMessage) error {
+		return errors.New("handler error")
+	}
+
+	t.Run("Schedule retry", func(t *testing.T) {
+		retrier := &fakeRetrier{}
+		registry := New(log)
+		registry.SetRetry(retrier, RetryConfig{MaxRetries: 3, MinBackoff: time.Second, MaxBackoff: time.Minute})
+		Handle(registry, "test.created", failing, Options{OnError: POLICY_Retry})
+
+		d := rabbitmq_mock.NewDelivery(t, rabbitmq_mock.MockRabbitDeliveryFields{
+			Body: []byte(`{"type":"test.created","attempt":1,"payload":{"name":"John"}}`),
+		})
+		d.ExpectAck(false, nil)
+
+		before := time.Now()
+		err := registry.Consume(context.Background(), d)
+		assert.Error(t, err)
+		assert.NoError(t, d.AllExpectationsDone())
+		if assert.Len(t, retrier.retries, 1) {
+			assert.JSONEq(t, `{"type":"test.created","attempt":2,"payload":{"name":"John"}}`, string(retrier.retries[0].body))
+			assert.WithinDuration(t, before.Add(2*time.Second), retrier.retries[0].at, time.Second)
+		}
+		assert.Empty(t, retrier.deadLetters)
+	})
+
+	t.Run("Retries exhausted", func(t *testing.T) {
+		retrier := &fakeRetrier{}
+		registry := New(log)
+		registry.SetRetry(retrier, RetryConfig{MaxRetries: 3})
+		Handle(registry, "test.created", failing, Options{OnError: POLICY_Retry})
+
+		body := `{"type":"test.created","attempt":3,"payload":{"name":"John"}}`
+		d := rabbitmq_mock.NewDelivery(t, rabbitmq_mock.MockRabbitDeliveryFields{
+			Body: []byte(body),
+		})
+		d.ExpectAck(false, nil)
+
+		err := registry.Consume(context.Background(), d)
+		assert.Error(t, err)
+		assert.Empty(t, retrier.retries)
+		assert.Equal(t, []string{body}, retrier.deadLetters)
+	})
+
+	t.Run("Unknown type goes to dead letters", func(t *testing.T) {
+		retrier := &fakeRetrier{}
+		registry := New(log)
+		registry.SetRetry(retrier, RetryConfig{MaxRetries: 3})
+
+		body := `{"type":"unknown","payload":{}}`
+		d := rabbitmq_mock.NewDelivery(t, rabbitmq_mock.MockRabbitDeliveryFields{
+			Body: []byte(body),
+		})
+		d.ExpectAck(false, nil)
+
+		err := registry.Consume(context.Background(), d)
+		assert.Error(t, err)
+		assert.NoError(t, d.AllExpectationsDone())
+		assert.Equal(t, []string{body}, retrier.deadLetters)
+	})
+
+	t.Run("Unknown type with ack policy", func(t *testing.T) {
+		retrier := &fakeRetrier{}
+		registry := New(log)
+		registry.SetRetry(retrier, RetryConfig{MaxRetries: 3})
+		registry.SetUnknownPolicy(POLICY_Ack)
+
+		d := rabbitmq_mock.NewDelivery(t, rabbitmq_mock.MockRabbitDeliveryFields{
+			Body: []byte(`{"type":"unknown","payload":{}}`),
+		})
+		d.ExpectAck(false, nil)
+
+		err := registry.Consume(context.Background(), d)
+		assert.Error(t, err)
+		assert.NoError(t, d.AllExpectationsDone())
+		assert.Empty(t, retrier.deadLetters)
+	})
+
+	t.Run("Requeue when retry can not be stored", func(t *testing.T) {
+		retrier := &fakeRetrier{err: errors.New("database error")}
+		registry := New(log)
+		registry.SetRetry(retrier, RetryConfig{MaxRetries: 3})
+		Handle(registry, "test.created", failing, Options{OnError: POLICY_Retry})
+
+		d := rabbitmq_mock.NewDelivery(t, rabbitmq_mock.MockRabbitDeliveryFields{
+			Body: []byte(`{"type":"test.created","payload":{"name":"John"}}`),
+		})
+		d.ExpectNack(false, true, nil)
+
+		err := registry.Consume(context.Background(), d)
+		assert.Error(t, err)
+		assert.NoError(t, d.AllExpectationsDone())
+	})
+
+	t.Run("Decode error goes to dead letters", func(t *testing.T) {
+		retrier := &fakeRetrier{}
+		registry := New(log)
+		registry.SetRetry(retrier, RetryConfig{MaxRetries: 3})
+		Handle(registry, "test.created", failing, Options{OnError: POLICY_Retry})
+
+		d := rabbitmq_mock.NewDelivery(t, rabbitmq_mock.MockRabbitDeliveryFields{
+			Body: []byte(`{"type":"test.created","payload":{"name":1}}`),
+		})
+		d.ExpectAck(false, nil)
+
+		err := registry.Consume(context.Background(), d)
+		assert.Error(t, err)
+		assert.Len(t, retrier.deadLetters, 1)
+	})
+
+	t.Run("Reject decode error when dead letter can not be stored", func(t *testing.T) {
+		retrier := &fakeRetrier{err: errors.New("database error")}
+		registry := New(log)
+		registry.SetRetry(retrier, RetryConfig{MaxRetries: 3})
+		Handle(registry, "test.created", failing, Options{OnError: POLICY_Retry})
+
+		d := rabbitmq_mock.NewDelivery(t, rabbitmq_mock.MockRabbitDeliveryFields{
+			MsgType: "test.created",
+			Body:    []byte(`not json`),
+		})
+		d.ExpectNack(false, false, nil)
+
+		err := registry.Consume(context.Background(), d)
+		assert.Error(t, err)
+		assert.NoError(t, d.AllExpectationsDone())
+		assert.Equal(t, []string{"not json"}, retrier.deadLetters)
+	})
+}
+
+func TestSetAttempt(t *testing.T) {
+	t.Run("Envelope", func(t *testing.T) {
+		b, err := SetAttempt([]byte(`{"type":"test","attempt":2,"payload":{"name":"John"}}`), "test", 0)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"type":"test","attempt":0,"payload":{"name":"John"}}`, string(b))
+	})
+
+	t.Run("Raw body is wrapped", func(t *testing.T) {
+		b, err := SetAttempt([]byte(`{"name":"John"}`), "test", 1)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"type":"test","attempt":1,"payload":{"name":"John"}}`, string(b))
+	})
+
+	t.Run("Not valid body", func(t *testing.T) {
+		_, err := SetAttempt([]byte(`not json`), "test", 1)
+		assert.Error(t, err)
+	})
+}
+
+func TestConsumeEnvelopeTrace(t *testing.T) {
+	log := logger.New(io.Discard, logger.TYPE_JSON)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+	defer otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+
+	registry := New(log)
+	var traceID trace.TraceID
+	Handle(registry, "test.created", func(ctx context.Context, msg *testMessage) error {
+		traceID = trace.SpanContextFromContext(ctx).TraceID()
+		return nil
+	}, Options{})
+
+	d := rabbitmq_mock.NewDelivery(t, rabbitmq_mock.MockRabbitDeliveryFields{
+		Body: []byte(`{"id":"1","type":"test.created","version":1,"traceparent":"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01","payload":{"name":"John"}}`),
+	})
+	d.ExpectAck(false, nil)
+
+	err := registry.Consume(context.Background(), d)
+	assert.NoError(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", traceID.String())
+}
+
+func TestStop(t *testing.T) {
+	log := logger.New(io.Discard, logger.TYPE_JSON)
+	body := []byte(`{"type":"test.created","payload":{"name":"John"}}`)
+
+	t.Run("Waits for running handlers", func(t *testing.T) {
+		registry := New(log)
+		started := make(chan struct{})
+		release := make(chan struct{})
+		var handlerErr error
+		Handle(registry, "test.created", func(ctx context.Context, msg *testMessage) error {
+			close(started)
+			<-release
+			handlerErr = ctx.Err()
+			return nil
+		}, Options{})
+
+		d := rabbitmq_mock.NewDelivery(t, rabbitmq_mock.MockRabbitDeliveryFields{Body: body})
+		d.ExpectAck(false, nil)
+		consumeCtx, stopConsuming := context.WithCancel(context.Background())
+		consumed := make(chan error)
+		go func() {
+			consumed <- registry.Consume(consumeCtx, d)
+		}()
+		<-started
+		stopConsuming()
+
+		stopped := make(chan error)
+		go func() {
+			stopped <- registry.Stop(context.Background())
+		}()
+
+		// new delivery is put back to the queue while stopping
+		assert.Eventually(t, registry.stopping.Load, time.Second, time.Millisecond)
+		next := rabbitmq_mock.NewDelivery(t, rabbitmq_mock.MockRabbitDeliveryFields{Body: body})
+		next.ExpectNack(false, true, nil)
+		assert.NoError(t, registry.Consume(context.Background(), next))
+		assert.NoError(t, next.AllExpectationsDone())
+
+		close(release)
+		assert.NoError(t, <-consumed)
+		assert.NoError(t, <-stopped)
+		assert.NoError(t, handlerErr, "handler must not be canceled when consuming is stopped")
+		assert.NoError(t, d.AllExpectationsDone())
+	})
+
+	t.Run("Grace period exceeded", func(t *testing.T) {
+		registry := New(log)
+		started := make(chan struct{})
+		Handle(registry, "test.created", func(ctx context.Context, msg *testMessage) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		}, Options{OnError: POLICY_Requeue})
+
+		d := rabbitmq_mock.NewDelivery(t, rabbitmq_mock.MockRabbitDeliveryFields{Body: body})
+		d.ExpectNack(false, true, nil)
+		consumed := make(chan error)
+		go func() {
+			consumed <- registry.Consume(context.Background(), d)
+		}()
+		<-started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		err := registry.Stop(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.ErrorIs(t, <-consumed, context.Canceled)
+	})
+}
@@ -0,0 +1,81 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	DEFAULT_MIN_BACKOFF = 1 * time.Second
+	DEFAULT_MAX_BACKOFF = 10 * time.Minute
+)
+
+var (
+	retriesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "your_app_consumer_retries_total",
+		Help: "Total number of scheduled message retries by message type",
+	},
+		[]string{"message_type"},
+	)
+	deadLettersCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "your_app_consumer_dead_letters_total",
+		Help: "Total number of messages moved to dead letters by message type",
+	},
+		[]string{"message_type"},
+	)
+)
+
+type RetryConfig struct {
+	// Amount of retries before message is moved to dead letters
+	MaxRetries int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// Retrier schedules delayed redelivery of failed messages
+// and stores messages which exhausted all retries.
+type Retrier interface {
+	ScheduleRetry(ctx context.Context, msgType string, body []byte, at time.Time) error
+	StoreDeadLetter(ctx context.Context, msgType string, body []byte, attempts int, reason string) error
+}
+
+// Set attempt number into message envelope.
+// Body without envelope is wrapped into a new one with provided message type.
+func SetAttempt(body []byte, msgType string, attempt int) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil || fields["payload"] == nil {
+		if !json.Valid(body) {
+			return nil, err
+		}
+		rawType, err := json.Marshal(msgType)
+		if err != nil {
+			return nil, err
+		}
+		fields = map[string]json.RawMessage{
+			"type":    rawType,
+			"payload": body,
+		}
+	}
+
+	rawAttempt, err := json.Marshal(attempt)
+	if err != nil {
+		return nil, err
+	}
+	fields["attempt"] = rawAttempt
+	return json.Marshal(fields)
+}
+
+func backoff(cfg RetryConfig, attempt int) time.Duration {
+	delay := cfg.MinBackoff
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= cfg.MaxBackoff {
+			return cfg.MaxBackoff
+		}
+	}
+	return delay
+}
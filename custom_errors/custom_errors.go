@@ -12,6 +12,11 @@ const (
 	ERR_CODE_Exists
 	ERR_CODE_Redis
 	ERR_CODE_RabbitMQ
+	ERR_CODE_Storage
+	ERR_CODE_RateLimit
+	ERR_CODE_Internal
+	ERR_CODE_Idempotency
+	ERR_CODE_Timeout
 )
 
 var ERRORS = map[int]string{
@@ -25,4 +30,9 @@ var ERRORS = map[int]string{
 	ERR_CODE_Exists:         "already exists",
 	ERR_CODE_Redis:          "redis error",
 	ERR_CODE_RabbitMQ:       "rabbitmq error",
+	ERR_CODE_Storage:        "storage error",
+	ERR_CODE_RateLimit:      "too many requests",
+	ERR_CODE_Internal:       "internal server error",
+	ERR_CODE_Idempotency:    "idempotency key conflict",
+	ERR_CODE_Timeout:        "request timeout",
 }
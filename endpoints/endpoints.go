@@ -2,14 +2,25 @@ package endpoints
 
 import (
 	"net/http"
+	"time"
 
-	"github.com/Moranilt/http-utils/clients/database"
-	"github.com/Moranilt/http-utils/clients/rabbitmq"
-	"github.com/Moranilt/http-utils/clients/redis"
-	"github.com/Moranilt/http_template/healthcheck"
 	"github.com/Moranilt/http_template/middleware"
+	"github.com/Moranilt/http_template/ratelimit"
 	"github.com/Moranilt/http_template/service"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	SCOPE_Files = "files"
+	SCOPE_Admin = "admin"
+)
+
+const (
+	DEFAULT_TIMEOUT = 10 * time.Second
+)
+
+var (
+	createUserRateLimit = ratelimit.Limit{Requests: 5, Period: time.Minute}
+	uploadRateLimit     = ratelimit.Limit{Requests: 10, Period: time.Minute}
 )
 
 type Endpoint struct {
@@ -17,6 +28,16 @@ type Endpoint struct {
 	HandleFunc http.HandlerFunc
 	Methods    []string
 	Middleware []middleware.EndpointMiddlewareFunc
+	// Scopes required from app token or JWT. Requires AppTokenRequired or JWTRequired in Middleware.
+	Scopes []string
+	// Roles required from JWT. Requires JWTRequired in Middleware.
+	Roles []string
+	// Requests limit per client, not limited when nil
+	RateLimit *ratelimit.Limit
+	// CORS policy of endpoint, default policy from config is used when nil
+	CORS *middleware.CORSPolicy
+	// Deadline of request context, client gets 504 when it is exceeded. Not limited when empty.
+	Timeout time.Duration
 }
 
 func MakeEndpoints(service service.Service, mw *middleware.Middleware) []Endpoint {
@@ -25,43 +46,84 @@ func MakeEndpoints(service service.Service, mw *middleware.Middleware) []Endpoin
 			Pattern:    "/user",
 			HandleFunc: service.CreateUser,
 			Methods:    []string{http.MethodPost},
+			Timeout:    DEFAULT_TIMEOUT,
+			Middleware: []middleware.EndpointMiddlewareFunc{mw.Idempotency},
+			RateLimit:  &createUserRateLimit,
+		},
+		{
+			Pattern:    "/user/{id}",
+			HandleFunc: service.GetUser,
+			Methods:    []string{http.MethodGet},
+			Timeout:    DEFAULT_TIMEOUT,
+		},
+		{
+			Pattern:    "/user/{id}",
+			HandleFunc: service.ReplaceUser,
+			Methods:    []string{http.MethodPut},
+			Timeout:    DEFAULT_TIMEOUT,
+		},
+		{
+			Pattern:    "/user/{id}",
+			HandleFunc: service.UpdateUser,
+			Methods:    []string{http.MethodPatch},
+			Timeout:    DEFAULT_TIMEOUT,
+		},
+		{
+			Pattern:    "/user/{id}",
+			HandleFunc: service.DeleteUser,
+			Methods:    []string{http.MethodDelete},
+			Timeout:    DEFAULT_TIMEOUT,
+		},
+		{
+			Pattern:    "/users",
+			HandleFunc: service.ListUsers,
+			Methods:    []string{http.MethodGet},
+			Timeout:    DEFAULT_TIMEOUT,
 		},
 		{
 			Pattern:    "/files",
 			HandleFunc: service.Files,
 			Methods:    []string{http.MethodPost},
 			Middleware: []middleware.EndpointMiddlewareFunc{mw.AppTokenRequired},
+			Scopes:     []string{SCOPE_Files},
+			RateLimit:  &uploadRateLimit,
+		},
+		{
+			Pattern:    "/files/stream",
+			HandleFunc: service.StreamFiles,
+			Methods:    []string{http.MethodPost},
+			Middleware: []middleware.EndpointMiddlewareFunc{mw.AppTokenRequired},
+			Scopes:     []string{SCOPE_Files},
+			RateLimit:  &uploadRateLimit,
+		},
+		{
+			Pattern:    "/files/{id}",
+			HandleFunc: service.DownloadFile,
+			Methods:    []string{http.MethodGet},
+			Middleware: []middleware.EndpointMiddlewareFunc{mw.AppTokenRequired},
+			Scopes:     []string{SCOPE_Files},
 		},
 		{
 			Pattern:    "/random-number",
 			HandleFunc: service.GetRandomNumber,
 			Methods:    []string{http.MethodGet},
+			Timeout:    DEFAULT_TIMEOUT,
 		},
 		{
-			Pattern:    "/metrics",
-			HandleFunc: promhttp.Handler().ServeHTTP,
+			Pattern:    "/admin/dead-letters",
+			HandleFunc: service.ListDeadLetters,
 			Methods:    []string{http.MethodGet},
+			Timeout:    DEFAULT_TIMEOUT,
+			Middleware: []middleware.EndpointMiddlewareFunc{mw.AppTokenRequired},
+			Scopes:     []string{SCOPE_Admin},
+		},
+		{
+			Pattern:    "/admin/dead-letters/{id}/replay",
+			HandleFunc: service.ReplayDeadLetter,
+			Methods:    []string{http.MethodPost},
+			Timeout:    DEFAULT_TIMEOUT,
+			Middleware: []middleware.EndpointMiddlewareFunc{mw.AppTokenRequired},
+			Scopes:     []string{SCOPE_Admin},
 		},
-	}
-}
-
-func MakeHealth(db *database.Client, rabbitmq rabbitmq.RabbitMQClient, redis *redis.Client) Endpoint {
-	return Endpoint{
-		Pattern: "/health",
-		HandleFunc: healthcheck.HandlerFunc(
-			healthcheck.HealthItem{
-				Name:    "database",
-				Checker: db,
-			},
-			healthcheck.HealthItem{
-				Name:    "rabbitmq",
-				Checker: rabbitmq,
-			},
-			healthcheck.HealthItem{
-				Name:    "redis",
-				Checker: redis,
-			},
-		),
-		Methods: []string{http.MethodGet},
 	}
 }
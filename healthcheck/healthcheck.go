@@ -9,9 +9,52 @@ import (
 	"github.com/Moranilt/http-utils/response"
 )
 
+const (
+	STATUS_OK          = "ok"
+	STATUS_Degraded    = "degraded"
+	STATUS_Unavailable = "unavailable"
+)
+
+const DEFAULT_TIMEOUT = 30 * time.Second
+
+type Criticality int
+
+const (
+	// Instance can not serve requests when check fails
+	CRITICALITY_Critical Criticality = iota
+	// Instance works with limited functionality when check fails, for example without cache
+	CRITICALITY_Degraded
+)
+
 type HealthItem struct {
 	Name    string
 	Checker Checker
+	// Critical by default
+	Criticality Criticality
+}
+
+type Checker interface {
+	Check(context.Context) error
+}
+
+type CheckResult struct {
+	// ok or status of criticality when check failed
+	Status              string     `json:"status"`
+	Latency             string     `json:"latency"`
+	CheckedAt           time.Time  `json:"checked_at"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+}
+
+// Result of checks, status is the worst status of checks
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// Provides result of checks with errors of failed checks by name
+type Reporter interface {
+	Report(ctx context.Context) (Report, map[string]string)
 }
 
 type health struct {
@@ -19,45 +62,103 @@ type health struct {
 	timeout  time.Duration
 }
 
-type Checker interface {
-	Check(context.Context) error
-}
-
+// Runs checks on every request. Responds with 503 when critical check fails,
+// failed degraded checks make status "degraded" with 200 response.
+// Use Monitor to not run checks on every request.
 func Handler(items ...HealthItem) http.Handler {
-	return &health{
+	return ReportHandler(&health{
 		checkers: items,
-		timeout:  30 * time.Second,
-	}
+		timeout:  DEFAULT_TIMEOUT,
+	})
 }
 
 func HandlerFunc(items ...HealthItem) http.HandlerFunc {
 	return Handler(items...).ServeHTTP
 }
 
-func (h *health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	errors := make(map[string]string, len(h.checkers))
-	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+func (h *health) Report(ctx context.Context) (Report, map[string]string) {
+	results := make(map[string]CheckResult, len(h.checkers))
+	errors := make(map[string]string)
+	for i, outcome := range runChecks(ctx, h.checkers, h.timeout) {
+		item := h.checkers[i]
+		result := CheckResult{
+			Status:    STATUS_OK,
+			Latency:   outcome.latency.String(),
+			CheckedAt: outcome.checkedAt,
+		}
+		if outcome.err != nil {
+			result.Status = failedStatus(item)
+			result.ConsecutiveFailures = 1
+			errors[item.Name] = outcome.err.Error()
+		} else {
+			result.LastSuccess = &outcome.checkedAt
+		}
+		results[item.Name] = result
+	}
+	return newReport(results), errors
+}
+
+// Responds with report of reporter
+func ReportHandler(reporter Reporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report, errors := reporter.Report(r.Context())
+		writeReport(w, report, errors)
+	})
+}
+
+type outcome struct {
+	err       error
+	latency   time.Duration
+	checkedAt time.Time
+}
+
+// Runs checks concurrently, every check is limited with timeout. Outcomes are in order of items.
+func runChecks(ctx context.Context, items []HealthItem, timeout time.Duration) []outcome {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	var mu sync.Mutex
+	outcomes := make([]outcome, len(items))
 	var wg sync.WaitGroup
-	wg.Add(len(h.checkers))
-	code := http.StatusOK
+	wg.Add(len(items))
+	for i, item := range items {
+		go func(i int, item HealthItem) {
+			defer wg.Done()
+			start := time.Now()
+			err := item.Checker.Check(ctx)
+			outcomes[i] = outcome{err: err, latency: time.Since(start), checkedAt: start}
+		}(i, item)
+	}
+	wg.Wait()
+	return outcomes
+}
+
+func failedStatus(item HealthItem) string {
+	if item.Criticality == CRITICALITY_Degraded {
+		return STATUS_Degraded
+	}
+	return STATUS_Unavailable
+}
 
-	for _, checker := range h.checkers {
-		go func(item HealthItem) {
-			if err := item.Checker.Check(ctx); err != nil {
-				mu.Lock()
-				errors[item.Name] = err.Error()
-				code = http.StatusServiceUnavailable
-				mu.Unlock()
+func newReport(results map[string]CheckResult) Report {
+	report := Report{Status: STATUS_OK, Checks: results}
+	for _, result := range results {
+		switch result.Status {
+		case STATUS_Unavailable:
+			report.Status = STATUS_Unavailable
+		case STATUS_Degraded:
+			if report.Status == STATUS_OK {
+				report.Status = STATUS_Degraded
 			}
-			wg.Done()
-		}(checker)
+		}
 	}
+	return report
+}
 
-	wg.Wait()
+func writeReport(w http.ResponseWriter, report Report, errors map[string]string) {
+	code := http.StatusOK
+	if report.Status == STATUS_Unavailable {
+		code = http.StatusServiceUnavailable
+	}
 	w.Header().Set("Content-Type", "application/json")
-
-	response.Default(w, http.StatusText(code), errors, code)
+	response.Default(w, report, errors, code)
 }
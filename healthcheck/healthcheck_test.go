@@ -0,0 +1,209 @@
+package healthcheck
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type checkerFunc func(ctx context.Context) error
+
+func (f checkerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+var (
+	healthy = checkerFunc(func(ctx context.Context) error { return nil })
+	failed  = checkerFunc(func(ctx context.Context) error { return errors.New("connection refused") })
+)
+
+type reportResponse struct {
+	Body  Report            `json:"body"`
+	Error map[string]string `json:"error"`
+}
+
+func serve(t *testing.T, handler http.Handler) (int, reportResponse) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	var resp reportResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, resp
+}
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		items  []HealthItem
+		code   int
+		status string
+		checks map[string]string
+	}{
+		{
+			name: "Healthy",
+			items: []HealthItem{
+				{Name: "database", Checker: healthy},
+				{Name: "redis", Checker: healthy, Criticality: CRITICALITY_Degraded},
+			},
+			code:   http.StatusOK,
+			status: STATUS_OK,
+			checks: map[string]string{"database": STATUS_OK, "redis": STATUS_OK},
+		},
+		{
+			name: "Degraded",
+			items: []HealthItem{
+				{Name: "database", Checker: healthy},
+				{Name: "redis", Checker: failed, Criticality: CRITICALITY_Degraded},
+			},
+			code:   http.StatusOK,
+			status: STATUS_Degraded,
+			checks: map[string]string{"database": STATUS_OK, "redis": STATUS_Degraded},
+		},
+		{
+			name: "Unavailable",
+			items: []HealthItem{
+				{Name: "database", Checker: failed},
+				{Name: "redis", Checker: failed, Criticality: CRITICALITY_Degraded},
+			},
+			code:   http.StatusServiceUnavailable,
+			status: STATUS_Unavailable,
+			checks: map[string]string{"database": STATUS_Unavailable, "redis": STATUS_Degraded},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, resp := serve(t, Handler(test.items...))
+			assert.Equal(t, test.code, code)
+			assert.Equal(t, test.status, resp.Body.Status)
+			assert.Len(t, resp.Body.Checks, len(test.checks))
+			for name, status := range test.checks {
+				assert.Equal(t, status, resp.Body.Checks[name].Status)
+				if status != STATUS_OK {
+					assert.Equal(t, "connection refused", resp.Error[name])
+				}
+			}
+		})
+	}
+}
+
+func TestProbes(t *testing.T) {
+	monitor := NewMonitor(MonitorConfig{},
+		HealthItem{Name: "database", Checker: healthy},
+		HealthItem{Name: "redis", Checker: failed, Criticality: CRITICALITY_Degraded},
+	)
+	monitor.Check(context.Background())
+	probes := NewProbes(monitor)
+
+	t.Run("Starting", func(t *testing.T) {
+		code, _ := serve(t, probes.Liveness())
+		assert.Equal(t, http.StatusOK, code)
+
+		code, resp := serve(t, probes.Startup())
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, "starting", resp.Error["state"])
+
+		code, _ = serve(t, probes.Readiness())
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+	})
+
+	t.Run("Ready", func(t *testing.T) {
+		probes.SetState(STATE_Ready)
+
+		code, _ := serve(t, probes.Startup())
+		assert.Equal(t, http.StatusOK, code)
+
+		code, resp := serve(t, probes.Readiness())
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, STATUS_Degraded, resp.Body.Status)
+	})
+
+	t.Run("Draining", func(t *testing.T) {
+		probes.SetState(STATE_Draining)
+		probes.SetState(STATE_Ready)
+		assert.Equal(t, STATE_Draining, probes.State())
+
+		code, resp := serve(t, probes.Readiness())
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, "draining", resp.Error["state"])
+
+		code, _ = serve(t, probes.Liveness())
+		assert.Equal(t, http.StatusOK, code)
+	})
+}
+
+func TestMonitor(t *testing.T) {
+	var calls atomic.Int32
+	var down atomic.Bool
+	redis := checkerFunc(func(ctx context.Context) error {
+		calls.Add(1)
+		if down.Load() {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	monitor := NewMonitor(MonitorConfig{Interval: time.Hour},
+		HealthItem{Name: "redis", Checker: redis, Criticality: CRITICALITY_Degraded},
+	)
+
+	t.Run("Not checked", func(t *testing.T) {
+		code, resp := serve(t, ReportHandler(monitor))
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, STATUS_Unavailable, resp.Body.Status)
+		assert.Equal(t, int32(0), calls.Load())
+	})
+
+	t.Run("Cached", func(t *testing.T) {
+		monitor.Check(context.Background())
+		for range 3 {
+			code, resp := serve(t, ReportHandler(monitor))
+			assert.Equal(t, http.StatusOK, code)
+			assert.Equal(t, STATUS_OK, resp.Body.Status)
+		}
+		assert.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("Consecutive failures", func(t *testing.T) {
+		report, _ := monitor.Report(context.Background())
+		lastSuccess := report.Checks["redis"].LastSuccess
+
+		down.Store(true)
+		monitor.Check(context.Background())
+		monitor.Check(context.Background())
+
+		report, errs := monitor.Report(context.Background())
+		result := report.Checks["redis"]
+		assert.Equal(t, STATUS_Degraded, report.Status)
+		assert.Equal(t, STATUS_Degraded, result.Status)
+		assert.Equal(t, 2, result.ConsecutiveFailures)
+		assert.Equal(t, lastSuccess, result.LastSuccess)
+		assert.Equal(t, "connection refused", errs["redis"])
+
+		down.Store(false)
+		monitor.Check(context.Background())
+		report, _ = monitor.Report(context.Background())
+		assert.Equal(t, 0, report.Checks["redis"].ConsecutiveFailures)
+		assert.True(t, report.Checks["redis"].LastSuccess.After(*lastSuccess))
+	})
+
+	t.Run("Run", func(t *testing.T) {
+		before := calls.Load()
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- monitor.Run(ctx)
+		}()
+		assert.Eventually(t, func() bool { return calls.Load() > before }, time.Second, time.Millisecond)
+		cancel()
+		assert.NoError(t, <-done)
+	})
+}
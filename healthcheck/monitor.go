@@ -0,0 +1,128 @@
+package healthcheck
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	DEFAULT_MONITOR_INTERVAL = 10 * time.Second
+	DEFAULT_MONITOR_TIMEOUT  = 5 * time.Second
+)
+
+var (
+	checkStatus = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "your_app_health_check_status",
+		Help: "Result of last health check, 1 is ok and 0 is failed",
+	}, []string{"check", "criticality"})
+	checkDuration = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "your_app_health_check_duration_seconds",
+		Help: "Duration of last health check in seconds",
+	}, []string{"check"})
+	checkFailures = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "your_app_health_check_consecutive_failures",
+		Help: "Number of health check failures in a row",
+	}, []string{"check"})
+)
+
+var criticalityNames = map[Criticality]string{
+	CRITICALITY_Critical: "critical",
+	CRITICALITY_Degraded: "degraded",
+}
+
+func (c Criticality) String() string {
+	return criticalityNames[c]
+}
+
+type MonitorConfig struct {
+	// How often checks are run, DEFAULT_MONITOR_INTERVAL when empty
+	Interval time.Duration
+	// Timeout of checks, DEFAULT_MONITOR_TIMEOUT when empty
+	Timeout time.Duration
+}
+
+// Runs checks in background and keeps their last results,
+// so requests to health endpoints do not reach dependencies
+type Monitor struct {
+	items []HealthItem
+	cfg   MonitorConfig
+
+	mu      sync.RWMutex
+	checked bool
+	results map[string]CheckResult
+	errors  map[string]string
+}
+
+func NewMonitor(cfg MonitorConfig, items ...HealthItem) *Monitor {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DEFAULT_MONITOR_INTERVAL
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DEFAULT_MONITOR_TIMEOUT
+	}
+	return &Monitor{items: items, cfg: cfg}
+}
+
+// Runs checks immediately and then every interval until ctx is done
+func (m *Monitor) Run(ctx context.Context) error {
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		m.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Runs checks once and stores their results
+func (m *Monitor) Check(ctx context.Context) {
+	outcomes := runChecks(ctx, m.items, m.cfg.Timeout)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	results := make(map[string]CheckResult, len(m.items))
+	errors := make(map[string]string)
+	for i, outcome := range outcomes {
+		item := m.items[i]
+		previous := m.results[item.Name]
+		result := CheckResult{
+			Status:      STATUS_OK,
+			Latency:     outcome.latency.String(),
+			CheckedAt:   outcome.checkedAt,
+			LastSuccess: previous.LastSuccess,
+		}
+		status := 1.0
+		if outcome.err != nil {
+			result.Status = failedStatus(item)
+			result.ConsecutiveFailures = previous.ConsecutiveFailures + 1
+			errors[item.Name] = outcome.err.Error()
+			status = 0
+		} else {
+			result.LastSuccess = &outcome.checkedAt
+		}
+		results[item.Name] = result
+
+		checkStatus.WithLabelValues(item.Name, item.Criticality.String()).Set(status)
+		checkDuration.WithLabelValues(item.Name).Set(outcome.latency.Seconds())
+		checkFailures.WithLabelValues(item.Name).Set(float64(result.ConsecutiveFailures))
+	}
+	// maps are replaced instead of modifying, so reports returned before stay unchanged
+	m.results, m.errors, m.checked = results, errors, true
+}
+
+// Returns results of last checks, instance is unavailable until checks are run first time
+func (m *Monitor) Report(ctx context.Context) (Report, map[string]string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.checked {
+		return Report{Status: STATUS_Unavailable}, map[string]string{"monitor": "checks are not run yet"}
+	}
+	return newReport(m.results), m.errors
+}
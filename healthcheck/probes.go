@@ -0,0 +1,87 @@
+package healthcheck
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+type State int32
+
+const (
+	// Instance is initializing, startup and readiness probes fail
+	STATE_Starting State = iota
+	// Instance receives traffic
+	STATE_Ready
+	// Instance is shutting down, readiness probe fails so load balancer stops sending traffic
+	STATE_Draining
+)
+
+var stateNames = map[State]string{
+	STATE_Starting: "starting",
+	STATE_Ready:    "ready",
+	STATE_Draining: "draining",
+}
+
+func (s State) String() string {
+	return stateNames[s]
+}
+
+// Kubernetes probes of instance:
+//   - liveness fails only when process must be restarted, so failed dependencies do not restart it
+//   - readiness fails while instance is starting or draining and when critical check fails
+//   - startup fails until instance is started
+type Probes struct {
+	state    atomic.Int32
+	reporter Reporter
+}
+
+// Reporter is usually Monitor, so probes do not run checks on every request
+func NewProbes(reporter Reporter) *Probes {
+	return &Probes{reporter: reporter}
+}
+
+func (p *Probes) State() State {
+	return State(p.state.Load())
+}
+
+// Draining state is final, instance does not become ready again
+func (p *Probes) SetState(state State) {
+	for {
+		current := p.state.Load()
+		if State(current) == STATE_Draining || p.state.CompareAndSwap(current, int32(state)) {
+			return
+		}
+	}
+}
+
+// Report of all checks
+func (p *Probes) Health() http.Handler {
+	return ReportHandler(p.reporter)
+}
+
+func (p *Probes) Liveness() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, Report{Status: STATUS_OK}, nil)
+	})
+}
+
+func (p *Probes) Readiness() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if state := p.State(); state != STATE_Ready {
+			writeReport(w, Report{Status: STATUS_Unavailable}, map[string]string{"state": state.String()})
+			return
+		}
+		report, errors := p.reporter.Report(r.Context())
+		writeReport(w, report, errors)
+	})
+}
+
+func (p *Probes) Startup() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if state := p.State(); state == STATE_Starting {
+			writeReport(w, Report{Status: STATUS_Unavailable}, map[string]string{"state": state.String()})
+			return
+		}
+		writeReport(w, Report{Status: STATUS_OK}, nil)
+	})
+}
@@ -0,0 +1,102 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Moranilt/http_template/cache"
+)
+
+const TracerName string = "idempotency"
+
+const (
+	KEY_PREFIX = "idempotency:"
+	// How long stored response is replayed
+	DEFAULT_TTL = 24 * time.Hour
+	// How long key is locked by in-flight request, should be longer than server write timeout
+	DEFAULT_LOCK_TTL = time.Minute
+)
+
+var ErrLockFailed = errors.New("unable to lock idempotency key")
+
+// Request processed with idempotency key.
+// Record without Status is locked by in-flight request.
+type Record struct {
+	// Hash of request method, route and body
+	Fingerprint string      `json:"fingerprint"`
+	Status      int         `json:"status,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+}
+
+func (r *Record) Completed() bool {
+	return r.Status != 0
+}
+
+// Stores responses of requests with idempotency key in cache.
+// Use Redis cache, so retries reaching other instances are replayed too.
+type Store struct {
+	cache   cache.Cache
+	ttl     time.Duration
+	lockTTL time.Duration
+}
+
+func New(cache cache.Cache) *Store {
+	return &Store{
+		cache:   cache,
+		ttl:     DEFAULT_TTL,
+		lockTTL: DEFAULT_LOCK_TTL,
+	}
+}
+
+// Locks key for request with fingerprint. Returns nil when lock is acquired,
+// otherwise returns existing record which is either in-flight or completed.
+func (s *Store) Acquire(ctx context.Context, key string, fingerprint string) (*Record, error) {
+	lock, err := json.Marshal(&Record{Fingerprint: fingerprint})
+	if err != nil {
+		return nil, err
+	}
+
+	// lock may expire between SetNX and Get, so try once more
+	for attempt := 0; attempt < 2; attempt++ {
+		acquired, err := s.cache.SetNX(ctx, KEY_PREFIX+key, lock, s.lockTTL)
+		if err != nil {
+			return nil, err
+		}
+		if acquired {
+			return nil, nil
+		}
+
+		data, err := s.cache.Get(ctx, KEY_PREFIX+key)
+		if errors.Is(err, cache.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var record Record
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, err
+		}
+		return &record, nil
+	}
+	return nil, ErrLockFailed
+}
+
+// Stores response of request, so it will be replayed for retries
+func (s *Store) Complete(ctx context.Context, key string, record *Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.cache.Set(ctx, KEY_PREFIX+key, data, s.ttl)
+}
+
+// Removes lock, so request can be retried with the same key
+func (s *Store) Release(ctx context.Context, key string) error {
+	return s.cache.Del(ctx, KEY_PREFIX+key)
+}
@@ -0,0 +1,65 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	redis_mock "github.com/Moranilt/http-utils/clients/redis/mock"
+	"github.com/Moranilt/http_template/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcquire(t *testing.T) {
+	lock, _ := json.Marshal(&Record{Fingerprint: "hash"})
+
+	t.Run("Acquired", func(t *testing.T) {
+		mockRedis, redisMock := redis_mock.New()
+		redisMock.ExpectSetNX(KEY_PREFIX+"key", lock, DEFAULT_LOCK_TTL).SetVal(true)
+
+		record, err := New(cache.NewRedis(mockRedis)).Acquire(context.Background(), "key", "hash")
+		assert.NoError(t, err)
+		assert.Nil(t, record)
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("Completed", func(t *testing.T) {
+		mockRedis, redisMock := redis_mock.New()
+		stored := &Record{Fingerprint: "hash", Status: http.StatusCreated, Body: []byte(`{"id":"1"}`)}
+		data, _ := json.Marshal(stored)
+		redisMock.ExpectSetNX(KEY_PREFIX+"key", lock, DEFAULT_LOCK_TTL).SetVal(false)
+		redisMock.ExpectGet(KEY_PREFIX + "key").SetVal(string(data))
+
+		record, err := New(cache.NewRedis(mockRedis)).Acquire(context.Background(), "key", "hash")
+		assert.NoError(t, err)
+		assert.Equal(t, stored, record)
+		assert.True(t, record.Completed())
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("Lock expired", func(t *testing.T) {
+		mockRedis, redisMock := redis_mock.New()
+		redisMock.ExpectSetNX(KEY_PREFIX+"key", lock, DEFAULT_LOCK_TTL).SetVal(false)
+		redisMock.ExpectGet(KEY_PREFIX + "key").RedisNil()
+		redisMock.ExpectSetNX(KEY_PREFIX+"key", lock, DEFAULT_LOCK_TTL).SetVal(true)
+
+		record, err := New(cache.NewRedis(mockRedis)).Acquire(context.Background(), "key", "hash")
+		assert.NoError(t, err)
+		assert.Nil(t, record)
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+}
+
+func TestCompleteAndRelease(t *testing.T) {
+	mockRedis, redisMock := redis_mock.New()
+	record := &Record{Fingerprint: "hash", Status: http.StatusCreated, Body: []byte(`{"id":"1"}`)}
+	data, _ := json.Marshal(record)
+	redisMock.ExpectSet(KEY_PREFIX+"key", data, DEFAULT_TTL).SetVal("OK")
+	redisMock.ExpectDel(KEY_PREFIX + "key").SetVal(1)
+
+	store := New(cache.NewRedis(mockRedis))
+	assert.NoError(t, store.Complete(context.Background(), "key", record))
+	assert.NoError(t, store.Release(context.Background(), "key"))
+	assert.NoError(t, redisMock.ExpectationsWereMet())
+}
@@ -0,0 +1,110 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Moranilt/http-utils/logger"
+	"github.com/Moranilt/http_template/healthcheck"
+	"golang.org/x/sync/errgroup"
+)
+
+const TracerName string = "lifecycle"
+
+const DEFAULT_GRACE_PERIOD = 30 * time.Second
+
+// Part of application with optional hooks. Empty hooks are skipped.
+type Component struct {
+	Name string
+	// Runs component until ctx is done or Stop is called. Returned error stops application.
+	Start func(ctx context.Context) error
+	// Releases resources of component, ctx expires at the end of grace period
+	Stop func(ctx context.Context) error
+	// Checks run by health monitor
+	Health []healthcheck.HealthItem
+}
+
+// Container of application components.
+// Components are started together and stopped one by one in reverse order they were added,
+// so add component after components it depends on.
+type App struct {
+	log        logger.Logger
+	grace      time.Duration
+	components []Component
+}
+
+// Grace period limits the whole shutdown, DEFAULT_GRACE_PERIOD is used when it is empty
+func New(log logger.Logger, grace time.Duration) *App {
+	if grace <= 0 {
+		grace = DEFAULT_GRACE_PERIOD
+	}
+	return &App{
+		log:   log.With("component", TracerName),
+		grace: grace,
+	}
+}
+
+func (a *App) Add(c Component) {
+	a.components = append(a.components, c)
+}
+
+// Health checks of all components in order they were added
+func (a *App) HealthItems() []healthcheck.HealthItem {
+	var items []healthcheck.HealthItem
+	for _, c := range a.components {
+		items = append(items, c.Health...)
+	}
+	return items
+}
+
+// Starts all components and waits until ctx is done or one of them fails, then stops application.
+// Returns error of failed component or errors of shutdown.
+func (a *App) Run(ctx context.Context) error {
+	g, gCtx := errgroup.WithContext(ctx)
+	for _, c := range a.components {
+		if c.Start == nil {
+			continue
+		}
+		g.Go(func() error {
+			if err := c.Start(gCtx); err != nil {
+				return fmt.Errorf("%s: %w", c.Name, err)
+			}
+			return nil
+		})
+	}
+
+	g.Go(func() error {
+		<-gCtx.Done()
+		return a.Shutdown(context.Background())
+	})
+
+	return g.Wait()
+}
+
+// Stops all components even if some of them fail and returns their errors.
+// Components left after grace period get done ctx, so they should only release resources.
+func (a *App) Shutdown(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, a.grace)
+	defer cancel()
+
+	a.log.Info("shutdown started", "grace_period", a.grace.String())
+	var errs []error
+	for i := len(a.components) - 1; i >= 0; i-- {
+		c := a.components[i]
+		if c.Stop == nil {
+			continue
+		}
+		start := time.Now()
+		err := c.Stop(ctx)
+		if err != nil {
+			a.log.Error("shutdown step failed", "step", c.Name, "duration", time.Since(start).String(), "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", c.Name, err))
+			continue
+		}
+		a.log.Info("shutdown step done", "step", c.Name, "duration", time.Since(start).String())
+	}
+	a.log.Info("shutdown finished")
+	return errors.Join(errs...)
+}
@@ -0,0 +1,151 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Moranilt/http-utils/logger"
+	"github.com/Moranilt/http_template/healthcheck"
+	"github.com/stretchr/testify/assert"
+)
+
+func newApp(grace time.Duration) *App {
+	return New(logger.New(io.Discard, logger.TYPE_JSON), grace)
+}
+
+func TestShutdown(t *testing.T) {
+	t.Run("Order", func(t *testing.T) {
+		app := newApp(time.Second)
+		var order []string
+		for _, name := range []string{"tracer", "database", "consumers", "http"} {
+			app.Add(Component{
+				Name: name,
+				Stop: func(ctx context.Context) error {
+					order = append(order, name)
+					return nil
+				},
+			})
+		}
+		app.Add(Component{Name: "without stop"})
+
+		assert.NoError(t, app.Shutdown(context.Background()))
+		assert.Equal(t, []string{"http", "consumers", "database", "tracer"}, order)
+	})
+
+	t.Run("Failed step", func(t *testing.T) {
+		app := newApp(time.Second)
+		failure := errors.New("connection is closed")
+		var closed bool
+		app.Add(Component{
+			Name: "database",
+			Stop: func(ctx context.Context) error {
+				closed = true
+				return nil
+			},
+		})
+		app.Add(Component{
+			Name: "rabbitmq",
+			Stop: func(ctx context.Context) error {
+				return failure
+			},
+		})
+
+		err := app.Shutdown(context.Background())
+		assert.ErrorIs(t, err, failure)
+		assert.ErrorContains(t, err, "rabbitmq")
+		assert.True(t, closed)
+	})
+
+	t.Run("Grace period", func(t *testing.T) {
+		app := newApp(10 * time.Millisecond)
+		var expired bool
+		app.Add(Component{
+			Name: "database",
+			Stop: func(ctx context.Context) error {
+				expired = ctx.Err() != nil
+				return nil
+			},
+		})
+		app.Add(Component{
+			Name: "http",
+			Stop: func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		})
+
+		err := app.Shutdown(context.Background())
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.True(t, expired)
+	})
+}
+
+func TestRun(t *testing.T) {
+	// component which runs until it is stopped
+	server := func(stopped *bool) Component {
+		done := make(chan struct{})
+		return Component{
+			Name: "server",
+			Start: func(ctx context.Context) error {
+				<-done
+				return nil
+			},
+			Stop: func(ctx context.Context) error {
+				*stopped = true
+				close(done)
+				return nil
+			},
+		}
+	}
+
+	t.Run("Stopped by context", func(t *testing.T) {
+		app := newApp(time.Second)
+		var stopped bool
+		app.Add(server(&stopped))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		assert.NoError(t, app.Run(ctx))
+		assert.True(t, stopped)
+	})
+
+	t.Run("Failed component", func(t *testing.T) {
+		app := newApp(time.Second)
+		var stopped bool
+		app.Add(server(&stopped))
+		failure := errors.New("address already in use")
+		app.Add(Component{
+			Name: "admin",
+			Start: func(ctx context.Context) error {
+				return failure
+			},
+		})
+
+		err := app.Run(context.Background())
+		assert.ErrorIs(t, err, failure)
+		assert.ErrorContains(t, err, "admin")
+		assert.True(t, stopped)
+	})
+}
+
+func TestHealthItems(t *testing.T) {
+	app := newApp(time.Second)
+	app.Add(Component{
+		Name:   "database",
+		Health: []healthcheck.HealthItem{{Name: "database"}},
+	})
+	app.Add(Component{Name: "relay"})
+	app.Add(Component{
+		Name:   "redis",
+		Health: []healthcheck.HealthItem{{Name: "redis", Criticality: healthcheck.CRITICALITY_Degraded}},
+	})
+
+	var names []string
+	for _, item := range app.HealthItems() {
+		names = append(names, item.Name)
+	}
+	assert.Equal(t, []string{"database", "redis"}, names)
+}
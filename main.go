@@ -6,6 +6,7 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/Moranilt/http-utils/logger"
 	"github.com/Moranilt/http_template/server"
 )
 
@@ -19,5 +20,12 @@ func main() {
 		cancel()
 	}()
 
-	server.Run(ctx)
+	// SIGHUP reloads config
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+
+	if err := server.Run(ctx, reload); err != nil {
+		logger.Default().Error("exit", "error", err)
+		os.Exit(1)
+	}
 }
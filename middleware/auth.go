@@ -0,0 +1,152 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Moranilt/http-utils/response"
+	"github.com/Moranilt/http-utils/tiny_errors"
+	"github.com/Moranilt/http_template/auth"
+	"github.com/Moranilt/http_template/custom_errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	AUTHORIZATION_HEADER = "Authorization"
+	BEARER_PREFIX        = "Bearer "
+)
+
+// Resolves client by app token
+type TokenStore interface {
+	Lookup(ctx context.Context, token string) (*auth.Client, error)
+}
+
+// Verifies JWT and returns its claims
+type JWTVerifier interface {
+	Verify(ctx context.Context, token string) (*auth.Claims, error)
+}
+
+// Verifies app token using TokenStore and stores resolved auth.Client in request context
+func (m *Middleware) AppTokenRequired(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(TOKEN_HEADER)
+		if token == "" {
+			authorizationError(w, http.StatusUnauthorized, fmt.Sprintf("%s required", TOKEN_HEADER))
+			return
+		}
+		if m.tokens == nil {
+			m.logger.WithRequestInfo(r).Error("token store is not configured")
+			authorizationError(w, http.StatusUnauthorized, auth.ErrInvalidToken.Error())
+			return
+		}
+
+		client, err := m.tokens.Lookup(r.Context(), token)
+		switch {
+		case errors.Is(err, auth.ErrInvalidToken), errors.Is(err, auth.ErrExpiredToken), errors.Is(err, auth.ErrRevokedToken):
+			m.logger.WithRequestInfo(r).Notice("unauthorized", "error", err)
+			authorizationError(w, http.StatusUnauthorized, err.Error())
+			return
+		case err != nil:
+			m.logger.WithRequestInfo(r).Error("token lookup", "error", err)
+			authorizationError(w, http.StatusInternalServerError, "unable to verify token")
+			return
+		}
+
+		trace.SpanFromContext(r.Context()).SetAttributes(
+			attribute.String("client.id", client.ID),
+			attribute.String("client.name", client.Name),
+		)
+		m.logger.WithRequestInfo(r).Info("authorized", "client_id", client.ID, "client_name", client.Name)
+		next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), client)))
+	})
+}
+
+// Verifies "Authorization: Bearer" JWT and stores its auth.Claims in request context
+func (m *Middleware) JWTRequired(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get(AUTHORIZATION_HEADER)
+		if len(header) < len(BEARER_PREFIX) || !strings.EqualFold(header[:len(BEARER_PREFIX)], BEARER_PREFIX) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			authorizationError(w, http.StatusUnauthorized, "bearer token required")
+			return
+		}
+		if m.jwt == nil {
+			m.logger.WithRequestInfo(r).Error("jwt verifier is not configured")
+			authorizationError(w, http.StatusUnauthorized, auth.ErrInvalidJWT.Error())
+			return
+		}
+
+		claims, err := m.jwt.Verify(r.Context(), strings.TrimSpace(header[len(BEARER_PREFIX):]))
+		switch {
+		case errors.Is(err, auth.ErrInvalidJWT), errors.Is(err, auth.ErrExpiredJWT):
+			m.logger.WithRequestInfo(r).Notice("unauthorized", "error", err)
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			authorizationError(w, http.StatusUnauthorized, err.Error())
+			return
+		case err != nil:
+			m.logger.WithRequestInfo(r).Error("jwt verify", "error", err)
+			authorizationError(w, http.StatusInternalServerError, "unable to verify token")
+			return
+		}
+
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("client.subject", claims.Subject))
+		m.logger.WithRequestInfo(r).Info("authorized", "subject", claims.Subject)
+		next.ServeHTTP(w, r.WithContext(auth.NewClaimsContext(r.Context(), claims)))
+	})
+}
+
+// Requires all scopes from JWT claims or client resolved by app token,
+// so it must be placed after AppTokenRequired or JWTRequired
+func (m *Middleware) ScopesRequired(scopes ...string) EndpointMiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var granted bool
+			if claims, ok := auth.ClaimsFromContext(r.Context()); ok {
+				granted = claims.HasScopes(scopes...)
+			} else if client, ok := auth.FromContext(r.Context()); ok {
+				granted = client.HasScopes(scopes...)
+			} else {
+				authorizationError(w, http.StatusUnauthorized, "client is not authorized")
+				return
+			}
+
+			if !granted {
+				m.logger.WithRequestInfo(r).Notice("forbidden", "scopes", scopes)
+				authorizationError(w, http.StatusForbidden, "insufficient scope", tiny_errors.Detail("scopes", strings.Join(scopes, " ")))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Requires all roles from JWT claims, so it must be placed after JWTRequired
+func (m *Middleware) RolesRequired(roles ...string) EndpointMiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := auth.ClaimsFromContext(r.Context())
+			if !ok {
+				authorizationError(w, http.StatusUnauthorized, "bearer token required")
+				return
+			}
+			if !claims.HasRoles(roles...) {
+				m.logger.WithRequestInfo(r).Notice("forbidden", "subject", claims.Subject, "roles", roles)
+				authorizationError(w, http.StatusForbidden, "insufficient role", tiny_errors.Detail("roles", strings.Join(roles, " ")))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func authorizationError(w http.ResponseWriter, status int, message string, options ...tiny_errors.ErrorOption) {
+	err := tiny_errors.New(
+		custom_errors.ERR_CODE_AUTHORIZATION,
+		append(options, tiny_errors.Message(message), tiny_errors.HTTPStatus(status))...,
+	)
+	response.ErrorResponse(w, err, status)
+}
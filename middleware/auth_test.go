@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Moranilt/http_template/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeTokenStore struct {
+	clients map[string]*auth.Client
+	err     error
+}
+
+func (f *fakeTokenStore) Lookup(ctx context.Context, token string) (*auth.Client, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	client, ok := f.clients[token]
+	if !ok {
+		return nil, auth.ErrInvalidToken
+	}
+	return client, nil
+}
+
+func TestAppTokenRequired(t *testing.T) {
+	client := &auth.Client{ID: "1", Name: "billing", Scopes: []string{"files"}}
+	m := newTestMiddleware()
+	m.tokens = &fakeTokenStore{clients: map[string]*auth.Client{"valid": client}}
+
+	var resolved *auth.Client
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resolved, _ = auth.FromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name     string
+		token    string
+		handler  http.Handler
+		expected int
+	}{
+		{name: "Valid token", token: "valid", handler: m.AppTokenRequired(next), expected: http.StatusOK},
+		{name: "Empty token", token: "", handler: m.AppTokenRequired(next), expected: http.StatusUnauthorized},
+		{name: "Unknown token", token: "unknown", handler: m.AppTokenRequired(next), expected: http.StatusUnauthorized},
+		{name: "Scope granted", token: "valid", handler: m.AppTokenRequired(m.ScopesRequired("files")(next)), expected: http.StatusOK},
+		{name: "Scope missing", token: "valid", handler: m.AppTokenRequired(m.ScopesRequired("admin")(next)), expected: http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolved = nil
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(TOKEN_HEADER, test.token)
+
+			w := serve(test.handler, r)
+
+			assert.Equal(t, test.expected, w.Code)
+			if test.expected == http.StatusOK {
+				assert.Equal(t, client, resolved)
+			}
+		})
+	}
+
+	t.Run("Store error", func(t *testing.T) {
+		m := newTestMiddleware()
+		m.tokens = &fakeTokenStore{err: errors.New("database error")}
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(TOKEN_HEADER, "valid")
+
+		w := serve(m.AppTokenRequired(next), r)
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+}
+
+type fakeJWTVerifier struct {
+	claims map[string]*auth.Claims
+}
+
+func (f *fakeJWTVerifier) Verify(ctx context.Context, token string) (*auth.Claims, error) {
+	claims, ok := f.claims[token]
+	if !ok {
+		return nil, auth.ErrInvalidJWT
+	}
+	return claims, nil
+}
+
+func TestJWTRequired(t *testing.T) {
+	claims := &auth.Claims{Subject: "user-1", Scope: "files", Roles: []string{"editor"}}
+	m := newTestMiddleware()
+	m.jwt = &fakeJWTVerifier{claims: map[string]*auth.Claims{"valid": claims}}
+
+	var resolved *auth.Claims
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resolved, _ = auth.ClaimsFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name          string
+		authorization string
+		handler       http.Handler
+		expected      int
+	}{
+		{name: "Valid token", authorization: "Bearer valid", handler: m.JWTRequired(next), expected: http.StatusOK},
+		{name: "Lowercase scheme", authorization: "bearer valid", handler: m.JWTRequired(next), expected: http.StatusOK},
+		{name: "Empty header", authorization: "", handler: m.JWTRequired(next), expected: http.StatusUnauthorized},
+		{name: "Basic scheme", authorization: "Basic dXNlcjpwYXNz", handler: m.JWTRequired(next), expected: http.StatusUnauthorized},
+		{name: "Invalid token", authorization: "Bearer invalid", handler: m.JWTRequired(next), expected: http.StatusUnauthorized},
+		{name: "Scope granted", authorization: "Bearer valid", handler: m.JWTRequired(m.ScopesRequired("files")(next)), expected: http.StatusOK},
+		{name: "Scope missing", authorization: "Bearer valid", handler: m.JWTRequired(m.ScopesRequired("admin")(next)), expected: http.StatusForbidden},
+		{name: "Role granted", authorization: "Bearer valid", handler: m.JWTRequired(m.RolesRequired("editor")(next)), expected: http.StatusOK},
+		{name: "Role missing", authorization: "Bearer valid", handler: m.JWTRequired(m.RolesRequired("admin")(next)), expected: http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolved = nil
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(AUTHORIZATION_HEADER, test.authorization)
+
+			w := serve(test.handler, r)
+
+			assert.Equal(t, test.expected, w.Code)
+			if test.expected == http.StatusOK {
+				assert.Equal(t, claims, resolved)
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	HEADER_AcceptEncoding  = "Accept-Encoding"
+	HEADER_ContentEncoding = "Content-Encoding"
+
+	ENCODING_Gzip   = "gzip"
+	ENCODING_Zstd   = "zstd"
+	ENCODING_Brotli = "br"
+
+	// Responses smaller than this are sent uncompressed
+	COMPRESS_MIN_SIZE = 1024
+)
+
+// Content types which are already compressed
+var COMPRESS_SKIP_TYPES = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"application/zip",
+	"application/gzip",
+	"application/zstd",
+	"application/x-brotli",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/octet-stream",
+}
+
+type compressor interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+	Flush() error
+}
+
+type encoding struct {
+	name string
+	pool sync.Pool
+}
+
+// Supported encodings in order of preference.
+// Add your encoding here to make it available for clients.
+var encodings = []*encoding{
+	{
+		name: ENCODING_Zstd,
+		pool: sync.Pool{New: func() any {
+			w, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+			return w
+		}},
+	},
+	{
+		name: ENCODING_Brotli,
+		pool: sync.Pool{New: func() any {
+			return brotli.NewWriter(nil)
+		}},
+	},
+	{
+		name: ENCODING_Gzip,
+		pool: sync.Pool{New: func() any {
+			return gzip.NewWriter(nil)
+		}},
+	},
+}
+
+// Compresses response with encoding negotiated from Accept-Encoding header.
+// Small responses, responses with compressed content types and responses already having Content-Encoding are sent as is.
+func (m *Middleware) Compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", HEADER_AcceptEncoding)
+		encoding := negotiateEncoding(r.Header.Get(HEADER_AcceptEncoding))
+		if encoding == nil || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		next.ServeHTTP(cw, r)
+		if err := cw.Close(); err != nil {
+			m.logger.WithRequestInfo(r).Error("compress response", "error", err)
+		}
+	})
+}
+
+// Returns supported encoding with highest quality, order of encodings is used for equal quality
+func negotiateEncoding(header string) *encoding {
+	if header == "" {
+		return nil
+	}
+
+	quality := make(map[string]float64)
+	for _, item := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(item, ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		quality[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+
+	var (
+		best        *encoding
+		bestQuality float64
+	)
+	for _, encoding := range encodings {
+		q, ok := quality[encoding.name]
+		if !ok {
+			q, ok = quality["*"]
+		}
+		if ok && q > bestQuality {
+			best, bestQuality = encoding, q
+		}
+	}
+	return best
+}
+
+// Buffers first COMPRESS_MIN_SIZE bytes of response to decide if it should be compressed
+type compressWriter struct {
+	http.ResponseWriter
+	encoding   *encoding
+	statusCode int
+	buf        []byte
+	// true when headers are sent
+	decided bool
+	writer  compressor
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.decided || cw.statusCode != 0 {
+		return
+	}
+	// informational responses are sent immediately
+	if code >= 100 && code < 200 {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	cw.statusCode = code
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.statusCode == 0 {
+		cw.statusCode = http.StatusOK
+	}
+	if cw.decided {
+		if cw.writer != nil {
+			return cw.writer.Write(b)
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+
+	cw.buf = append(cw.buf, b...)
+	if len(cw.buf) >= COMPRESS_MIN_SIZE {
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Sends buffered data. Streamed response is compressed regardless of its size.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if cw.statusCode == 0 {
+			cw.statusCode = http.StatusOK
+		}
+		if err := cw.decide(true); err != nil {
+			return
+		}
+	}
+	if cw.writer != nil {
+		cw.writer.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Sends rest of response and returns encoder to pool
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		if cw.statusCode == 0 {
+			return nil
+		}
+		if err := cw.decide(false); err != nil {
+			return err
+		}
+	}
+	if cw.writer == nil {
+		return nil
+	}
+	err := cw.writer.Close()
+	cw.writer.Reset(nil)
+	cw.encoding.pool.Put(cw.writer)
+	cw.writer = nil
+	return err
+}
+
+// Sends headers and buffered data compressed or as is
+func (cw *compressWriter) decide(compress bool) error {
+	cw.decided = true
+	header := cw.Header()
+	if compress && cw.compressible() {
+		header.Set(HEADER_ContentEncoding, cw.encoding.name)
+		header.Del("Content-Length")
+		// compressed body is not byte-equal to original one
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+		cw.writer = cw.encoding.pool.Get().(compressor)
+		cw.writer.Reset(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(cw.statusCode)
+
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.writer != nil {
+		_, err = cw.writer.Write(cw.buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf)
+	}
+	cw.buf = nil
+	return err
+}
+
+func (cw *compressWriter) compressible() bool {
+	switch cw.statusCode {
+	case http.StatusNoContent, http.StatusNotModified, http.StatusPartialContent:
+		return false
+	}
+	header := cw.Header()
+	if header.Get(HEADER_ContentEncoding) != "" {
+		return false
+	}
+	contentType := strings.ToLower(header.Get("Content-Type"))
+	return !slices.ContainsFunc(COMPRESS_SKIP_TYPES, func(skip string) bool {
+		return strings.HasPrefix(contentType, skip)
+	})
+}
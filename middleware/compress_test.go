@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                     "",
+		"gzip":                 ENCODING_Gzip,
+		"gzip, deflate, zstd":  ENCODING_Zstd,
+		"zstd;q=0.5, gzip":     ENCODING_Gzip,
+		"zstd;q=0, gzip;q=0":   "",
+		"*":                    ENCODING_Zstd,
+		"gzip;q=0.8, *;q=0.1":  ENCODING_Gzip,
+		"identity, deflate":    "",
+		"GZIP;q=1.0, br;q=0.9": ENCODING_Gzip,
+		"gzip;q=invalid, zstd": ENCODING_Zstd,
+		"br":                   ENCODING_Brotli,
+		"gzip, br":             ENCODING_Brotli,
+		"br, zstd":             ENCODING_Zstd,
+	}
+	for header, expected := range tests {
+		encoding := negotiateEncoding(header)
+		if expected == "" {
+			assert.Nil(t, encoding, header)
+			continue
+		}
+		if assert.NotNil(t, encoding, header) {
+			assert.Equal(t, expected, encoding.name, header)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	m := newTestMiddleware()
+	large := strings.Repeat(`{"firstname":"John","lastname":"Doe"}`, 100)
+
+	compress := func(acceptEncoding string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/users", nil)
+		r.Header.Set(HEADER_AcceptEncoding, acceptEncoding)
+		var rw *responseWriter
+		w := serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw = &responseWriter{ResponseWriter: w}
+			m.Compress(handler).ServeHTTP(rw, r)
+		}), r)
+		assert.Equal(t, w.Code, rw.statusCode)
+		return w
+	}
+	jsonHandler := func(body string, status int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+		}
+	}
+
+	t.Run("Gzip", func(t *testing.T) {
+		w := compress("gzip", jsonHandler(large, http.StatusCreated))
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, ENCODING_Gzip, w.Header().Get(HEADER_ContentEncoding))
+		assert.Equal(t, HEADER_AcceptEncoding, w.Header().Get("Vary"))
+
+		reader, err := gzip.NewReader(w.Body)
+		if assert.NoError(t, err) {
+			body, _ := io.ReadAll(reader)
+			assert.Equal(t, large, string(body))
+		}
+	})
+
+	t.Run("Zstd", func(t *testing.T) {
+		w := compress("gzip, zstd", jsonHandler(large, http.StatusOK))
+		assert.Equal(t, ENCODING_Zstd, w.Header().Get(HEADER_ContentEncoding))
+
+		decoder, _ := zstd.NewReader(w.Body)
+		defer decoder.Close()
+		body, err := io.ReadAll(decoder)
+		assert.NoError(t, err)
+		assert.Equal(t, large, string(body))
+	})
+
+	t.Run("Brotli", func(t *testing.T) {
+		w := compress("gzip, br", jsonHandler(large, http.StatusOK))
+		assert.Equal(t, ENCODING_Brotli, w.Header().Get(HEADER_ContentEncoding))
+
+		body, err := io.ReadAll(brotli.NewReader(w.Body))
+		assert.NoError(t, err)
+		assert.Equal(t, large, string(body))
+	})
+
+	t.Run("Small body", func(t *testing.T) {
+		w := compress("gzip", jsonHandler(`{"id":"1"}`, http.StatusOK))
+		assert.Empty(t, w.Header().Get(HEADER_ContentEncoding))
+		assert.Equal(t, `{"id":"1"}`, w.Body.String())
+	})
+
+	t.Run("Compressed content type", func(t *testing.T) {
+		w := compress("gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			w.Header().Set("Content-Length", strconv.Itoa(len(large)))
+			w.Write([]byte(large))
+		})
+		assert.Empty(t, w.Header().Get(HEADER_ContentEncoding))
+		assert.Equal(t, strconv.Itoa(len(large)), w.Header().Get("Content-Length"))
+		assert.Equal(t, large, w.Body.String())
+	})
+
+	t.Run("Without Accept-Encoding", func(t *testing.T) {
+		w := compress("", jsonHandler(large, http.StatusOK))
+		assert.Empty(t, w.Header().Get(HEADER_ContentEncoding))
+		assert.Equal(t, large, w.Body.String())
+	})
+
+	t.Run("No content", func(t *testing.T) {
+		w := compress("gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Empty(t, w.Header().Get(HEADER_ContentEncoding))
+	})
+
+	t.Run("Flush", func(t *testing.T) {
+		w := compress("gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", `"abc"`)
+			w.Write([]byte("first chunk\n"))
+			http.NewResponseController(w).Flush()
+			w.Write([]byte("second chunk\n"))
+		})
+		assert.True(t, w.Flushed)
+		assert.Equal(t, ENCODING_Gzip, w.Header().Get(HEADER_ContentEncoding))
+		assert.Equal(t, `W/"abc"`, w.Header().Get("ETag"))
+
+		reader, err := gzip.NewReader(w.Body)
+		if assert.NoError(t, err) {
+			body, _ := io.ReadAll(reader)
+			assert.Equal(t, "first chunk\nsecond chunk\n", string(body))
+		}
+	})
+}
@@ -0,0 +1,185 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Moranilt/http-utils/response"
+	"github.com/Moranilt/http-utils/tiny_errors"
+	"github.com/Moranilt/http_template/custom_errors"
+)
+
+const (
+	HEADER_Origin                        = "Origin"
+	HEADER_AccessControlRequestMethod    = "Access-Control-Request-Method"
+	HEADER_AccessControlRequestHeaders   = "Access-Control-Request-Headers"
+	HEADER_AccessControlAllowOrigin      = "Access-Control-Allow-Origin"
+	HEADER_AccessControlAllowMethods     = "Access-Control-Allow-Methods"
+	HEADER_AccessControlAllowHeaders     = "Access-Control-Allow-Headers"
+	HEADER_AccessControlAllowCredentials = "Access-Control-Allow-Credentials"
+	HEADER_AccessControlExposeHeaders    = "Access-Control-Expose-Headers"
+	HEADER_AccessControlMaxAge           = "Access-Control-Max-Age"
+)
+
+type CORSPolicy struct {
+	// "*" allows any origin, "https://*.example.com" allows any subdomain
+	AllowedOrigins []string
+	// Methods of endpoint are allowed when empty
+	AllowedMethods []string
+	// Request headers allowed in preflight, "*" allows any header
+	AllowedHeaders []string
+	// Response headers available for browser scripts
+	ExposedHeaders   []string
+	AllowCredentials bool
+	// How long browser can cache preflight response
+	MaxAge time.Duration
+}
+
+func (p *CORSPolicy) allowOrigin(origin string) bool {
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		prefix, suffix, wildcard := strings.Cut(strings.ToLower(allowed), "*")
+		if wildcard {
+			origin := strings.ToLower(origin)
+			if len(origin) > len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *CORSPolicy) allowMethod(method string) bool {
+	return len(p.AllowedMethods) == 0 || slices.Contains(p.AllowedMethods, method)
+}
+
+func (p *CORSPolicy) allowHeaders(headers []string) bool {
+	if slices.Contains(p.AllowedHeaders, "*") {
+		return true
+	}
+	for _, header := range headers {
+		if !slices.ContainsFunc(p.AllowedHeaders, func(allowed string) bool {
+			return strings.EqualFold(allowed, header)
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// Default policy for endpoints without own policy
+func WithCORS(policy *CORSPolicy) Option {
+	return func(m *Middleware) {
+		m.SetCORS(policy)
+	}
+}
+
+// Replaces default policy at runtime, nil disables CORS for endpoints without own policy
+func (m *Middleware) SetCORS(policy *CORSPolicy) {
+	m.cors.Store(policy)
+}
+
+func (m *Middleware) corsPolicy(policy *CORSPolicy) *CORSPolicy {
+	if policy != nil {
+		return policy
+	}
+	return m.cors.Load()
+}
+
+// Adds CORS headers to response of allowed origin. Default policy is used when policy is nil.
+func (m *Middleware) CORS(policy *CORSPolicy) EndpointMiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			policy := m.corsPolicy(policy)
+			origin := r.Header.Get(HEADER_Origin)
+			if policy == nil || origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", HEADER_Origin)
+			if policy.allowOrigin(origin) && policy.allowMethod(r.Method) {
+				setAllowOrigin(w, policy, origin)
+				if len(policy.ExposedHeaders) > 0 {
+					w.Header().Set(HEADER_AccessControlExposeHeaders, strings.Join(policy.ExposedHeaders, ", "))
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Handles OPTIONS requests for route. Policies contain CORS policy for every method of route,
+// nil policy means default one.
+func (m *Middleware) Preflight(policies map[string]*CORSPolicy) http.Handler {
+	methods := make([]string, 0, len(policies)+1)
+	for method := range policies {
+		methods = append(methods, method)
+	}
+	methods = append(methods, http.MethodOptions)
+	slices.Sort(methods)
+	allow := strings.Join(methods, ", ")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get(HEADER_Origin)
+		method := r.Header.Get(HEADER_AccessControlRequestMethod)
+		w.Header().Set("Allow", allow)
+		if origin == "" || method == "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Add("Vary", HEADER_Origin)
+		w.Header().Add("Vary", HEADER_AccessControlRequestMethod)
+		w.Header().Add("Vary", HEADER_AccessControlRequestHeaders)
+
+		policy, ok := policies[method]
+		if ok {
+			policy = m.corsPolicy(policy)
+		}
+		var headers []string
+		for _, header := range strings.Split(r.Header.Get(HEADER_AccessControlRequestHeaders), ",") {
+			if header = strings.TrimSpace(header); header != "" {
+				headers = append(headers, header)
+			}
+		}
+
+		if policy == nil || !policy.allowOrigin(origin) || !policy.allowMethod(method) || !policy.allowHeaders(headers) {
+			m.logger.WithRequestInfo(r).Notice("cors preflight rejected", "origin", origin, "method", method, "headers", headers)
+			err := tiny_errors.New(
+				custom_errors.ERR_CODE_AUTHORIZATION,
+				tiny_errors.Message("cross-origin request is not allowed"),
+				tiny_errors.HTTPStatus(http.StatusForbidden),
+			)
+			response.ErrorResponse(w, err, http.StatusForbidden)
+			return
+		}
+
+		setAllowOrigin(w, policy, origin)
+		w.Header().Set(HEADER_AccessControlAllowMethods, method)
+		if len(headers) > 0 {
+			w.Header().Set(HEADER_AccessControlAllowHeaders, strings.Join(headers, ", "))
+		}
+		if policy.MaxAge > 0 {
+			w.Header().Set(HEADER_AccessControlMaxAge, strconv.Itoa(int(policy.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// Policy allowing any origin sends literal "*" without credentials, otherwise origin is echoed
+func setAllowOrigin(w http.ResponseWriter, policy *CORSPolicy, origin string) {
+	if slices.Contains(policy.AllowedOrigins, "*") {
+		w.Header().Set(HEADER_AccessControlAllowOrigin, "*")
+		return
+	}
+	w.Header().Set(HEADER_AccessControlAllowOrigin, origin)
+	if policy.AllowCredentials {
+		w.Header().Set(HEADER_AccessControlAllowCredentials, "true")
+	}
+}
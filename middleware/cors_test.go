@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORS(t *testing.T) {
+	m := newTestMiddleware()
+	m.SetCORS(&CORSPolicy{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		ExposedHeaders:   []string{"X-Request-ID"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	})
+
+	t.Run("Allowed origin", func(t *testing.T) {
+		for _, origin := range []string{"https://app.example.com", "https://admin.example.org"} {
+			r := httptest.NewRequest(http.MethodPost, "/user", nil)
+			r.Header.Set(HEADER_Origin, origin)
+
+			w := serve(m.CORS(nil)(okHandler), r)
+			assert.Equal(t, origin, w.Header().Get(HEADER_AccessControlAllowOrigin))
+			assert.Equal(t, "true", w.Header().Get(HEADER_AccessControlAllowCredentials))
+			assert.Equal(t, "X-Request-ID", w.Header().Get(HEADER_AccessControlExposeHeaders))
+		}
+	})
+
+	t.Run("Not allowed origin", func(t *testing.T) {
+		for _, origin := range []string{"https://evil.com", "https://example.org", "http://app.example.org"} {
+			r := httptest.NewRequest(http.MethodPost, "/user", nil)
+			r.Header.Set(HEADER_Origin, origin)
+
+			w := serve(m.CORS(nil)(okHandler), r)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Empty(t, w.Header().Get(HEADER_AccessControlAllowOrigin), origin)
+		}
+	})
+
+	t.Run("Endpoint policy", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/user", nil)
+		r.Header.Set(HEADER_Origin, "https://other.com")
+
+		w := serve(m.CORS(&CORSPolicy{AllowedOrigins: []string{"https://other.com"}})(okHandler), r)
+		assert.Equal(t, "https://other.com", w.Header().Get(HEADER_AccessControlAllowOrigin))
+		assert.Empty(t, w.Header().Get(HEADER_AccessControlAllowCredentials))
+	})
+
+	t.Run("Any origin without credentials", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/user", nil)
+		r.Header.Set(HEADER_Origin, "https://other.com")
+
+		w := serve(m.CORS(&CORSPolicy{AllowedOrigins: []string{"*"}, AllowCredentials: true})(okHandler), r)
+		assert.Equal(t, "*", w.Header().Get(HEADER_AccessControlAllowOrigin))
+		assert.Empty(t, w.Header().Get(HEADER_AccessControlAllowCredentials))
+	})
+
+	preflight := m.Preflight(map[string]*CORSPolicy{
+		http.MethodGet:    nil,
+		http.MethodDelete: {AllowedOrigins: []string{"https://admin.example.com"}},
+	})
+	tests := []struct {
+		name     string
+		origin   string
+		method   string
+		headers  string
+		expected int
+	}{
+		{name: "Preflight", origin: "https://app.example.com", method: http.MethodGet, headers: "content-type, authorization", expected: http.StatusNoContent},
+		{name: "Preflight with endpoint policy", origin: "https://admin.example.com", method: http.MethodDelete, expected: http.StatusNoContent},
+		{name: "Not allowed origin", origin: "https://evil.com", method: http.MethodGet, expected: http.StatusForbidden},
+		{name: "Not allowed header", origin: "https://app.example.com", method: http.MethodGet, headers: "X-Custom", expected: http.StatusForbidden},
+		{name: "Not allowed method", origin: "https://app.example.com", method: http.MethodPut, expected: http.StatusForbidden},
+		{name: "Origin of other endpoint", origin: "https://app.example.com", method: http.MethodDelete, expected: http.StatusForbidden},
+		{name: "Plain OPTIONS", expected: http.StatusNoContent},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodOptions, "/user/1", nil)
+			if test.origin != "" {
+				r.Header.Set(HEADER_Origin, test.origin)
+				r.Header.Set(HEADER_AccessControlRequestMethod, test.method)
+			}
+			if test.headers != "" {
+				r.Header.Set(HEADER_AccessControlRequestHeaders, test.headers)
+			}
+
+			w := serve(preflight, r)
+			assert.Equal(t, test.expected, w.Code)
+			assert.Equal(t, "DELETE, GET, OPTIONS", w.Header().Get("Allow"))
+			if test.expected == http.StatusNoContent && test.origin != "" {
+				assert.Equal(t, test.origin, w.Header().Get(HEADER_AccessControlAllowOrigin))
+				assert.Equal(t, test.method, w.Header().Get(HEADER_AccessControlAllowMethods))
+			}
+		})
+	}
+
+	t.Run("Preflight headers", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodOptions, "/user/1", nil)
+		r.Header.Set(HEADER_Origin, "https://app.example.com")
+		r.Header.Set(HEADER_AccessControlRequestMethod, http.MethodGet)
+		r.Header.Set(HEADER_AccessControlRequestHeaders, "content-type")
+
+		w := serve(preflight, r)
+		assert.Equal(t, "content-type", w.Header().Get(HEADER_AccessControlAllowHeaders))
+		assert.Equal(t, "600", w.Header().Get(HEADER_AccessControlMaxAge))
+		assert.Equal(t, "true", w.Header().Get(HEADER_AccessControlAllowCredentials))
+	})
+}
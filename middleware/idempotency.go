@@ -0,0 +1,180 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"slices"
+
+	"github.com/Moranilt/http-utils/response"
+	"github.com/Moranilt/http-utils/tiny_errors"
+	"github.com/Moranilt/http_template/custom_errors"
+	"github.com/Moranilt/http_template/idempotency"
+)
+
+const (
+	IDEMPOTENCY_KEY_HEADER      = "Idempotency-Key"
+	IDEMPOTENCY_REPLAYED_HEADER = "Idempotent-Replayed"
+
+	IDEMPOTENCY_MAX_KEY_LENGTH = 255
+	// Maximum size of request body which can be fingerprinted
+	IDEMPOTENCY_MAX_BODY_SIZE = 1 << 20
+)
+
+// Stores responses of requests with idempotency key
+type IdempotencyStore interface {
+	Acquire(ctx context.Context, key string, fingerprint string) (*idempotency.Record, error)
+	Complete(ctx context.Context, key string, record *idempotency.Record) error
+	Release(ctx context.Context, key string) error
+}
+
+// Enables Idempotency middleware
+func WithIdempotencyStore(store IdempotencyStore) Option {
+	return func(m *Middleware) {
+		m.idempotency = store
+	}
+}
+
+// Makes request with Idempotency-Key header processed only once per client.
+// Key is scoped by authenticated client when endpoint requires auth, otherwise only by method and route:
+// IP address is not used, because retries of mobile clients or clients behind NAT come from different addresses.
+// Response is stored and replayed for retries with the same key, while first request is in-flight retries get 409.
+// Reusing key with different request gets 422. Responses with 5xx status and rejections from auth and rate limit
+// middlewares (401, 403, 429) are not stored, so request can be retried.
+func (m *Middleware) Idempotency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IDEMPOTENCY_KEY_HEADER)
+		if key == "" || m.idempotency == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > IDEMPOTENCY_MAX_KEY_LENGTH {
+			idempotencyError(w, custom_errors.ERR_CODE_NotValid, http.StatusBadRequest, IDEMPOTENCY_KEY_HEADER+" is too long")
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, IDEMPOTENCY_MAX_BODY_SIZE+1))
+		if err != nil {
+			idempotencyError(w, custom_errors.ERR_CODE_NotValid, http.StatusBadRequest, "unable to read body")
+			return
+		}
+		if len(body) > IDEMPOTENCY_MAX_BODY_SIZE {
+			idempotencyError(w, custom_errors.ERR_CODE_NotValid, http.StatusRequestEntityTooLarge, "body is too large")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		path := routePath(r)
+		scope, ok := authIdentity(r)
+		if !ok {
+			scope = "anonymous"
+		}
+		storeKey := r.Method + ":" + path + ":" + scope + ":" + key
+		hash := sha256.New()
+		hash.Write([]byte(r.Method + " " + path + "\n"))
+		hash.Write(body)
+		fingerprint := hex.EncodeToString(hash.Sum(nil))
+
+		record, err := m.idempotency.Acquire(r.Context(), storeKey, fingerprint)
+		if err != nil {
+			m.logger.WithRequestInfo(r).Error("idempotency acquire", "error", err)
+			idempotencyError(w, custom_errors.ERR_CODE_Redis, http.StatusInternalServerError, "unable to check idempotency key")
+			return
+		}
+
+		if record != nil {
+			switch {
+			case record.Fingerprint != fingerprint:
+				m.logger.WithRequestInfo(r).Notice("idempotency key reused", "key", key)
+				idempotencyError(w, custom_errors.ERR_CODE_Idempotency, http.StatusUnprocessableEntity, "key is already used for another request")
+			case !record.Completed():
+				idempotencyError(w, custom_errors.ERR_CODE_Idempotency, http.StatusConflict, "request with this key is in progress")
+			default:
+				m.logger.WithRequestInfo(r).Info("idempotent replay", "key", key)
+				for name, values := range record.Header {
+					w.Header()[name] = values
+				}
+				w.Header().Set(IDEMPOTENCY_REPLAYED_HEADER, "true")
+				w.WriteHeader(record.Status)
+				w.Write(record.Body)
+			}
+			return
+		}
+
+		release := true
+		defer func() {
+			// release lock on panic or failed response, so client can retry
+			if release {
+				if err := m.idempotency.Release(context.WithoutCancel(r.Context()), storeKey); err != nil {
+					m.logger.WithRequestInfo(r).Error("idempotency release", "error", err)
+				}
+			}
+		}()
+
+		headerBefore := w.Header().Clone()
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		if !storableStatus(rec.statusCode) {
+			return
+		}
+		// request is processed, so lock is kept until it expires even if response can not be stored
+		release = false
+
+		// store only headers set by handler
+		header := http.Header{}
+		for name, values := range w.Header() {
+			if !slices.Equal(headerBefore[name], values) {
+				header[name] = values
+			}
+		}
+		err = m.idempotency.Complete(context.WithoutCancel(r.Context()), storeKey, &idempotency.Record{
+			Fingerprint: fingerprint,
+			Status:      rec.statusCode,
+			Header:      header,
+			Body:        rec.body.Bytes(),
+		})
+		if err != nil {
+			m.logger.WithRequestInfo(r).Error("idempotency complete", "error", err)
+		}
+	})
+}
+
+// Reports whether response with status is produced by handler and can be replayed
+func storableStatus(status int) bool {
+	switch status {
+	case 0, http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+		return false
+	}
+	return status < http.StatusInternalServerError
+}
+
+// Writes response and keeps copy of it
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(code int) {
+	if rr.statusCode == 0 {
+		rr.statusCode = code
+	}
+	rr.ResponseWriter.WriteHeader(code)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.statusCode == 0 {
+		rr.statusCode = http.StatusOK
+	}
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
+
+func idempotencyError(w http.ResponseWriter, code int, status int, message string) {
+	err := tiny_errors.New(code, tiny_errors.Message(message), tiny_errors.HTTPStatus(status))
+	response.ErrorResponse(w, err, status)
+}
@@ -0,0 +1,137 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Moranilt/http_template/auth"
+	"github.com/Moranilt/http_template/idempotency"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeIdempotencyStore struct {
+	records map[string]*idempotency.Record
+}
+
+func (f *fakeIdempotencyStore) Acquire(ctx context.Context, key string, fingerprint string) (*idempotency.Record, error) {
+	if record, ok := f.records[key]; ok {
+		return record, nil
+	}
+	f.records[key] = &idempotency.Record{Fingerprint: fingerprint}
+	return nil, nil
+}
+
+func (f *fakeIdempotencyStore) Complete(ctx context.Context, key string, record *idempotency.Record) error {
+	f.records[key] = record
+	return nil
+}
+
+func (f *fakeIdempotencyStore) Release(ctx context.Context, key string) error {
+	delete(f.records, key)
+	return nil
+}
+
+func TestIdempotency(t *testing.T) {
+	store := &fakeIdempotencyStore{records: map[string]*idempotency.Record{}}
+	m := newTestMiddleware()
+	m.idempotency = store
+
+	calls := 0
+	status := http.StatusCreated
+	handler := m.Idempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Location", "/user/1")
+		w.WriteHeader(status)
+		w.Write(body)
+	}))
+	newRequest := func(key string, body string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/user", strings.NewReader(body))
+		r.Header.Set(IDEMPOTENCY_KEY_HEADER, key)
+		return r
+	}
+	request := func(key string, body string) *httptest.ResponseRecorder {
+		return serve(handler, newRequest(key, body))
+	}
+
+	first := request("key-1", `{"firstname":"John"}`)
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.Equal(t, `{"firstname":"John"}`, first.Body.String())
+
+	t.Run("Replay", func(t *testing.T) {
+		w := request("key-1", `{"firstname":"John"}`)
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, first.Body.String(), w.Body.String())
+		assert.Equal(t, "/user/1", w.Header().Get("Location"))
+		assert.Equal(t, "true", w.Header().Get(IDEMPOTENCY_REPLAYED_HEADER))
+	})
+
+	t.Run("Different body", func(t *testing.T) {
+		w := request("key-1", `{"firstname":"Jane"}`)
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	})
+
+	t.Run("In progress", func(t *testing.T) {
+		completed := store.records[http.MethodPost+":/user:anonymous:key-1"]
+		store.records[http.MethodPost+":/user:anonymous:key-2"] = &idempotency.Record{Fingerprint: completed.Fingerprint}
+		w := request("key-2", `{"firstname":"John"}`)
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("Server error is not stored", func(t *testing.T) {
+		status = http.StatusInternalServerError
+		request("key-3", `{}`)
+		status = http.StatusCreated
+		w := request("key-3", `{}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Empty(t, w.Header().Get(IDEMPOTENCY_REPLAYED_HEADER))
+	})
+
+	for _, rejected := range []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests} {
+		t.Run(fmt.Sprintf("Status %d is not stored", rejected), func(t *testing.T) {
+			status = rejected
+			w := request("key-4", `{}`)
+			assert.Equal(t, rejected, w.Code)
+			status = http.StatusCreated
+			w = request("key-4", `{}`)
+			assert.Equal(t, http.StatusCreated, w.Code)
+			assert.Empty(t, w.Header().Get(IDEMPOTENCY_REPLAYED_HEADER))
+			delete(store.records, http.MethodPost+":/user:anonymous:key-4")
+		})
+	}
+
+	t.Run("Retry from another IP", func(t *testing.T) {
+		before := calls
+		r := newRequest("key-1", `{"firstname":"John"}`)
+		r.RemoteAddr = "198.51.100.7:1234"
+		w := serve(handler, r)
+		assert.Equal(t, before, calls)
+		assert.Equal(t, "true", w.Header().Get(IDEMPOTENCY_REPLAYED_HEADER))
+	})
+
+	t.Run("Authenticated clients do not share keys", func(t *testing.T) {
+		before := calls
+		for _, id := range []string{"1", "2"} {
+			r := newRequest("key-1", `{"firstname":"John"}`)
+			r = r.WithContext(auth.NewContext(r.Context(), &auth.Client{ID: id}))
+			w := serve(handler, r)
+			assert.Empty(t, w.Header().Get(IDEMPOTENCY_REPLAYED_HEADER))
+		}
+		assert.Equal(t, before+2, calls)
+		assert.Contains(t, store.records, http.MethodPost+":/user:client:1:key-1")
+	})
+
+	t.Run("Without key", func(t *testing.T) {
+		before := calls
+		request("", `{}`)
+		request("", `{}`)
+		assert.Equal(t, before+2, calls)
+	})
+}
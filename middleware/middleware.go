@@ -2,13 +2,14 @@ package middleware
 
 import (
 	"context"
-	"fmt"
 	"net/http"
+	"net/netip"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/Moranilt/http-utils/logger"
-	"github.com/Moranilt/http-utils/response"
+	"github.com/Moranilt/http_template/ratelimit"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
@@ -24,34 +25,68 @@ const (
 	TOKEN_HEADER = "X-App-Token"
 )
 
+// Metrics are shared by all instances of Middleware, so application can be built several times in tests
+var (
+	requestStatusCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "your_app_http_response_total",
+		Help: "Total number of requests by endpoint and status",
+	},
+		[]string{"method", "endpoint", "status"},
+	)
+	responseTime = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "your_app_http_response_time_seconds",
+		Help: "Response time in seconds",
+	},
+		[]string{"method", "endpoint", "status"},
+	)
+)
+
 type Middleware struct {
 	logger               logger.Logger
 	requestStatusCounter *prometheus.CounterVec
 	responseTime         *prometheus.HistogramVec
 	otelProp             propagation.TextMapPropagator
+	tokens               TokenStore
+	jwt                  JWTVerifier
+	limiter              RateLimiter
+	ipLimit              ratelimit.Limit
+	trustedProxies       []netip.Prefix
+	idempotency          IdempotencyStore
+	cors                 atomic.Pointer[CORSPolicy]
+	overrides            atomic.Pointer[map[string]EndpointOverride]
 }
 
 type EndpointMiddlewareFunc func(handleFunc http.Handler) http.Handler
 
-func New(l logger.Logger) *Middleware {
-	return &Middleware{
-		logger: l,
-		requestStatusCounter: promauto.NewCounterVec(prometheus.CounterOpts{
-			Name: "your_app_http_response_total",
-			Help: "Total number of requests by endpoint and status",
-		},
-			[]string{"method", "endpoint", "status"},
-		),
-		responseTime: promauto.NewHistogramVec(prometheus.HistogramOpts{
-			Name: "your_app_http_response_time_seconds",
-			Help: "Response time in seconds",
-		},
-			[]string{"method", "endpoint", "status"},
-		),
-		otelProp: otel.GetTextMapPropagator(),
+type Option func(*Middleware)
+
+// Enables app token verification in AppTokenRequired
+func WithTokenStore(store TokenStore) Option {
+	return func(m *Middleware) {
+		m.tokens = store
+	}
+}
+
+// Enables JWT verification in JWTRequired
+func WithJWTVerifier(verifier JWTVerifier) Option {
+	return func(m *Middleware) {
+		m.jwt = verifier
 	}
 }
 
+func New(l logger.Logger, opts ...Option) *Middleware {
+	m := &Middleware{
+		logger:               l,
+		requestStatusCounter: requestStatusCounter,
+		responseTime:         responseTime,
+		otelProp:             otel.GetTextMapPropagator(),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
 func (m *Middleware) Default(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		requestId := uuid.NewString()
@@ -118,14 +153,32 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
-func (m *Middleware) AppTokenRequired(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Header.Get(TOKEN_HEADER) == "" {
-			response.ErrorResponse(w, fmt.Errorf("%s required", TOKEN_HEADER), http.StatusBadRequest)
-			return
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if rw.statusCode == 0 {
+		rw.statusCode = http.StatusOK
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *responseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Used by http.ResponseController to reach original writer
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Returns path template of matched route or request path
+func routePath(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if path, err := route.GetPathTemplate(); err == nil {
+			return path
 		}
-		next.ServeHTTP(w, r)
-	})
+	}
+	return r.URL.Path
 }
 
 func GetRequestID(ctx context.Context) string {
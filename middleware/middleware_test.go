@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/Moranilt/http-utils/logger"
+)
+
+func newTestMiddleware() *Middleware {
+	return &Middleware{logger: logger.New(io.Discard, logger.TYPE_JSON)}
+}
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+// Serves request by handler and returns recorded response
+func serve(handler http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Moranilt/http_template/ratelimit"
+)
+
+// Settings of endpoint which replace ones from code, used to change them without restart
+type EndpointOverride struct {
+	// Zero Requests disables rate limit, nil keeps limit of endpoint
+	RateLimit *ratelimit.Limit
+	// Zero value keeps timeout of endpoint
+	Timeout time.Duration
+}
+
+// Replaces all overrides at once. Key is method and route pattern, for example "POST /user", case is ignored.
+func (m *Middleware) SetOverrides(overrides map[string]EndpointOverride) {
+	normalized := make(map[string]EndpointOverride, len(overrides))
+	for key, override := range overrides {
+		normalized[strings.ToLower(key)] = override
+	}
+	m.overrides.Store(&normalized)
+}
+
+func (m *Middleware) override(r *http.Request) (EndpointOverride, bool) {
+	overrides := m.overrides.Load()
+	if overrides == nil {
+		return EndpointOverride{}, false
+	}
+	override, ok := (*overrides)[strings.ToLower(r.Method+" "+routePath(r))]
+	return override, ok
+}
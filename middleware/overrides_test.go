@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Moranilt/http_template/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverrides(t *testing.T) {
+	limiter := &fakeRateLimiter{result: &ratelimit.Result{Allowed: true}}
+	m := newTestMiddleware()
+	m.limiter = limiter
+	override := ratelimit.Limit{Requests: 1, Period: time.Second}
+	m.SetOverrides(map[string]EndpointOverride{
+		"POST /user":   {RateLimit: &override, Timeout: 10 * time.Millisecond},
+		"GET /users":   {RateLimit: &ratelimit.Limit{}},
+		"GET /profile": {Timeout: 10 * time.Millisecond},
+	})
+
+	var deadline time.Time
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, _ = r.Context().Deadline()
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := m.Timeout(0)(m.RateLimit(ratelimit.Limit{Requests: 10, Period: time.Minute})(next))
+
+	t.Run("Replaced", func(t *testing.T) {
+		serve(handler, httptest.NewRequest(http.MethodPost, "/user", nil))
+		assert.Equal(t, []ratelimit.Limit{override}, limiter.limits)
+		assert.WithinDuration(t, time.Now().Add(10*time.Millisecond), deadline, 10*time.Millisecond)
+	})
+
+	t.Run("Disabled rate limit", func(t *testing.T) {
+		limiter.limits = nil
+		serve(handler, httptest.NewRequest(http.MethodGet, "/users", nil))
+		assert.Empty(t, limiter.limits)
+		assert.True(t, deadline.IsZero())
+	})
+
+	t.Run("Not overridden", func(t *testing.T) {
+		limiter.limits = nil
+		serve(handler, httptest.NewRequest(http.MethodGet, "/files", nil))
+		assert.Equal(t, []ratelimit.Limit{{Requests: 10, Period: time.Minute}}, limiter.limits)
+		assert.True(t, deadline.IsZero())
+	})
+
+	t.Run("Timeout only", func(t *testing.T) {
+		limiter.limits = nil
+		serve(handler, httptest.NewRequest(http.MethodGet, "/profile", nil))
+		assert.Len(t, limiter.limits, 1)
+		assert.False(t, deadline.IsZero())
+	})
+}
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+const (
+	HEADER_XForwardedFor = "X-Forwarded-For"
+	HEADER_XRealIP       = "X-Real-IP"
+)
+
+// Enables resolving client IP from X-Forwarded-For and X-Real-IP headers of requests sent by proxies
+func WithTrustedProxies(proxies []netip.Prefix) Option {
+	return func(m *Middleware) {
+		m.trustedProxies = proxies
+	}
+}
+
+// Returns IP address of client. Forwarded headers are used only when request comes from trusted proxy:
+// X-Forwarded-For is read from right to left and first address which is not trusted proxy is returned,
+// X-Real-IP is used when X-Forwarded-For is empty.
+func (m *Middleware) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !m.trustedProxy(ip) {
+		return ip
+	}
+
+	var hops []string
+	for _, value := range r.Header.Values(HEADER_XForwardedFor) {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	if len(hops) == 0 {
+		if realIP := strings.TrimSpace(r.Header.Get(HEADER_XRealIP)); validIP(realIP) {
+			return realIP
+		}
+		return ip
+	}
+	for _, hop := range slices.Backward(hops) {
+		hop = strings.TrimSpace(hop)
+		if !validIP(hop) {
+			break
+		}
+		ip = hop
+		if !m.trustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+func (m *Middleware) trustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	return slices.ContainsFunc(m.trustedProxies, func(proxy netip.Prefix) bool {
+		return proxy.Contains(addr)
+	})
+}
+
+func validIP(ip string) bool {
+	_, err := netip.ParseAddr(ip)
+	return err == nil
+}
@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Moranilt/http-utils/response"
+	"github.com/Moranilt/http-utils/tiny_errors"
+	"github.com/Moranilt/http_template/auth"
+	"github.com/Moranilt/http_template/custom_errors"
+	"github.com/Moranilt/http_template/ratelimit"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	HEADER_RateLimitLimit     = "X-RateLimit-Limit"
+	HEADER_RateLimitRemaining = "X-RateLimit-Remaining"
+	HEADER_RateLimitReset     = "X-RateLimit-Reset"
+	HEADER_RetryAfter         = "Retry-After"
+)
+
+var rateLimitRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "your_app_rate_limit_rejections_total",
+	Help: "Total number of requests rejected by rate limiter",
+}, []string{"method", "endpoint"})
+
+// Checks if request with key fits into limit
+type RateLimiter interface {
+	Allow(ctx context.Context, key string, limit ratelimit.Limit) (*ratelimit.Result, error)
+}
+
+// Enables RateLimit middleware
+func WithRateLimiter(limiter RateLimiter) Option {
+	return func(m *Middleware) {
+		m.limiter = limiter
+	}
+}
+
+// Limits requests of every IP address to all endpoints together
+func WithIPRateLimit(limit ratelimit.Limit) Option {
+	return func(m *Middleware) {
+		m.ipLimit = limit
+	}
+}
+
+// Limits requests to endpoint per client. Client is identified by JWT subject,
+// app token client or IP address, so it should be placed after AppTokenRequired or JWTRequired.
+//
+// Limit can be replaced at runtime with SetOverrides, zero Requests disables it.
+// Requests are allowed when limiter is not configured or unavailable.
+func (m *Middleware) RateLimit(limit ratelimit.Limit) EndpointMiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit := limit
+			if override, ok := m.override(r); ok && override.RateLimit != nil {
+				limit = *override.RateLimit
+			}
+			if m.allow(w, r, r.Method+":"+routePath(r)+":"+m.clientKey(r), limit) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// Limits requests per IP address with limit from WithIPRateLimit. It is placed before authorization,
+// so floods of unauthenticated requests are rejected before they reach token store or database.
+func (m *Middleware) IPRateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.allow(w, r, "ip_limit:"+m.clientIP(r), m.ipLimit) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// Sets rate limit headers and responds with 429 when key exceeds limit
+func (m *Middleware) allow(w http.ResponseWriter, r *http.Request, key string, limit ratelimit.Limit) bool {
+	if m.limiter == nil || limit.Requests <= 0 || limit.Period <= 0 {
+		return true
+	}
+
+	result, err := m.limiter.Allow(r.Context(), key, limit)
+	if err != nil {
+		m.logger.WithRequestInfo(r).Error("rate limit", "error", err)
+		return true
+	}
+
+	w.Header().Set(HEADER_RateLimitLimit, strconv.Itoa(result.Limit))
+	w.Header().Set(HEADER_RateLimitRemaining, strconv.Itoa(result.Remaining))
+	w.Header().Set(HEADER_RateLimitReset, strconv.Itoa(ceilSeconds(result.Reset)))
+	if result.Allowed {
+		return true
+	}
+
+	rateLimitRejections.WithLabelValues(r.Method, routePath(r)).Inc()
+	m.logger.WithRequestInfo(r).Notice("rate limit exceeded", "key", key, "retry_after", result.RetryAfter.String())
+
+	retryAfter := ceilSeconds(result.RetryAfter)
+	w.Header().Set(HEADER_RetryAfter, strconv.Itoa(retryAfter))
+	rejection := tiny_errors.New(
+		custom_errors.ERR_CODE_RateLimit,
+		tiny_errors.HTTPStatus(http.StatusTooManyRequests),
+		tiny_errors.Detail("retry_after", strconv.Itoa(retryAfter)),
+	)
+	response.ErrorResponse(w, rejection, http.StatusTooManyRequests)
+	return false
+}
+
+// Returns identifier of client: JWT subject, app token client or IP address
+func (m *Middleware) clientKey(r *http.Request) string {
+	if identity, ok := authIdentity(r); ok {
+		return identity
+	}
+	return "ip:" + m.clientIP(r)
+}
+
+// Returns identifier of authenticated client: JWT subject or app token client
+func authIdentity(r *http.Request) (string, bool) {
+	if claims, ok := auth.ClaimsFromContext(r.Context()); ok && claims.Subject != "" {
+		return "sub:" + claims.Subject, true
+	}
+	if client, ok := auth.FromContext(r.Context()); ok {
+		return "client:" + client.ID, true
+	}
+	return "", false
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
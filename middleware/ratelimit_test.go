@@ -0,0 +1,153 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/Moranilt/http_template/auth"
+	"github.com/Moranilt/http_template/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeRateLimiter struct {
+	result *ratelimit.Result
+	err    error
+	keys   []string
+	limits []ratelimit.Limit
+}
+
+func (f *fakeRateLimiter) Allow(ctx context.Context, key string, limit ratelimit.Limit) (*ratelimit.Result, error) {
+	f.keys = append(f.keys, key)
+	f.limits = append(f.limits, limit)
+	return f.result, f.err
+}
+
+func TestRateLimit(t *testing.T) {
+	limit := ratelimit.Limit{Requests: 10, Period: time.Minute}
+
+	t.Run("Allowed", func(t *testing.T) {
+		limiter := &fakeRateLimiter{result: &ratelimit.Result{Allowed: true, Limit: 10, Remaining: 9, Reset: 5500 * time.Millisecond}}
+		m := newTestMiddleware()
+		m.limiter = limiter
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "10.0.0.1:5000"
+
+		w := serve(m.RateLimit(limit)(okHandler), r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "10", w.Header().Get(HEADER_RateLimitLimit))
+		assert.Equal(t, "9", w.Header().Get(HEADER_RateLimitRemaining))
+		assert.Equal(t, "6", w.Header().Get(HEADER_RateLimitReset))
+		assert.Equal(t, []string{"GET:/:ip:10.0.0.1"}, limiter.keys)
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		m := newTestMiddleware()
+		m.limiter = &fakeRateLimiter{result: &ratelimit.Result{Allowed: false, Limit: 10, RetryAfter: 1500 * time.Millisecond, Reset: time.Minute}}
+
+		w := serve(m.RateLimit(limit)(okHandler), httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "2", w.Header().Get(HEADER_RetryAfter))
+		assert.Equal(t, "0", w.Header().Get(HEADER_RateLimitRemaining))
+	})
+
+	t.Run("Keyed by client", func(t *testing.T) {
+		limiter := &fakeRateLimiter{result: &ratelimit.Result{Allowed: true}}
+		m := newTestMiddleware()
+		m.limiter = limiter
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r = r.WithContext(auth.NewContext(r.Context(), &auth.Client{ID: "1"}))
+		serve(m.RateLimit(limit)(okHandler), r)
+
+		r = r.WithContext(auth.NewClaimsContext(r.Context(), &auth.Claims{Subject: "user-1"}))
+		serve(m.RateLimit(limit)(okHandler), r)
+
+		assert.Equal(t, []string{"GET:/:client:1", "GET:/:sub:user-1"}, limiter.keys)
+	})
+
+	t.Run("Limiter error", func(t *testing.T) {
+		m := newTestMiddleware()
+		m.limiter = &fakeRateLimiter{err: errors.New("redis is down")}
+
+		w := serve(m.RateLimit(limit)(okHandler), httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
+func TestIPRateLimit(t *testing.T) {
+	newLimited := func(limiter *fakeRateLimiter) *Middleware {
+		m := newTestMiddleware()
+		m.limiter = limiter
+		WithIPRateLimit(ratelimit.Limit{Requests: 100, Period: time.Second})(m)
+		return m
+	}
+
+	t.Run("Keyed by IP", func(t *testing.T) {
+		limiter := &fakeRateLimiter{result: &ratelimit.Result{Allowed: true}}
+		m := newLimited(limiter)
+
+		r := httptest.NewRequest(http.MethodGet, "/admin/clients", nil)
+		r.RemoteAddr = "10.0.0.1:5000"
+		r = r.WithContext(auth.NewContext(r.Context(), &auth.Client{ID: "1"}))
+		serve(m.IPRateLimit(okHandler), r)
+		assert.Equal(t, []string{"ip_limit:10.0.0.1"}, limiter.keys)
+	})
+
+	t.Run("Rejected before next", func(t *testing.T) {
+		m := newLimited(&fakeRateLimiter{result: &ratelimit.Result{Allowed: false, RetryAfter: time.Second}})
+
+		called := false
+		w := serve(m.IPRateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		})), httptest.NewRequest(http.MethodGet, "/files", nil))
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "1", w.Header().Get(HEADER_RetryAfter))
+		assert.False(t, called)
+	})
+
+	t.Run("Clients behind trusted proxy", func(t *testing.T) {
+		limiter := &fakeRateLimiter{result: &ratelimit.Result{Allowed: true}}
+		m := newLimited(limiter)
+		WithTrustedProxies([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})(m)
+
+		for _, forwarded := range []string{"203.0.113.1", "198.51.100.1, 203.0.113.2, 10.0.0.2"} {
+			r := httptest.NewRequest(http.MethodGet, "/files", nil)
+			r.RemoteAddr = "10.0.0.1:5000"
+			r.Header.Set(HEADER_XForwardedFor, forwarded)
+			serve(m.IPRateLimit(okHandler), r)
+		}
+		r := httptest.NewRequest(http.MethodGet, "/files", nil)
+		r.RemoteAddr = "10.0.0.1:5000"
+		r.Header.Set(HEADER_XRealIP, "192.0.2.10")
+		serve(m.IPRateLimit(okHandler), r)
+
+		assert.Equal(t, []string{"ip_limit:203.0.113.1", "ip_limit:203.0.113.2", "ip_limit:192.0.2.10"}, limiter.keys)
+	})
+
+	t.Run("Forwarded headers of untrusted client", func(t *testing.T) {
+		limiter := &fakeRateLimiter{result: &ratelimit.Result{Allowed: true}}
+		m := newLimited(limiter)
+		WithTrustedProxies([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})(m)
+
+		r := httptest.NewRequest(http.MethodGet, "/files", nil)
+		r.RemoteAddr = "192.0.2.1:5000"
+		r.Header.Set(HEADER_XForwardedFor, "203.0.113.1")
+		serve(m.IPRateLimit(okHandler), r)
+		assert.Equal(t, []string{"ip_limit:192.0.2.1"}, limiter.keys)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		limiter := &fakeRateLimiter{}
+		m := newTestMiddleware()
+		m.limiter = limiter
+
+		w := serve(m.IPRateLimit(okHandler), httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, limiter.keys)
+	})
+}
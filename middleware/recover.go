@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/Moranilt/http-utils/response"
+	"github.com/Moranilt/http-utils/tiny_errors"
+	"github.com/Moranilt/http_template/custom_errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var panicsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "your_app_http_panics_total",
+	Help: "Total number of panics recovered in handlers",
+}, []string{"method", "endpoint"})
+
+// Recovers from panic in handler, logs it with stack trace and responds with 500.
+// Should be the last middleware in router chain to have request id and span in context.
+func (m *Middleware) Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{ResponseWriter: w}
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			stack := debug.Stack()
+			// panic of handler running in another goroutine, for example in Timeout
+			if p, ok := recovered.(*handlerPanic); ok {
+				recovered, stack = p.value, p.stack
+			}
+			// client has gone, connection should be aborted
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			err, ok := recovered.(error)
+			if !ok {
+				err = fmt.Errorf("%v", recovered)
+			}
+
+			path := routePath(r)
+			panicsCounter.WithLabelValues(r.Method, path).Inc()
+
+			span := trace.SpanFromContext(r.Context())
+			span.RecordError(err, trace.WithStackTrace(true))
+			span.SetStatus(codes.Error, "panic")
+
+			m.logger.WithRequestInfo(r).Error("panic recovered", "error", err.Error(), "stack", string(stack))
+
+			// response is already partially sent, nothing to do
+			if rw.statusCode != 0 {
+				return
+			}
+			response.ErrorResponse(
+				rw,
+				tiny_errors.New(custom_errors.ERR_CODE_Internal, tiny_errors.HTTPStatus(http.StatusInternalServerError)),
+				http.StatusInternalServerError,
+			)
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// Panic of handler which is raised again in another goroutine, keeps stack of goroutine where it happened
+type handlerPanic struct {
+	value any
+	stack []byte
+}
+
+func (p *handlerPanic) String() string {
+	return fmt.Sprintf("%v", p.value)
+}
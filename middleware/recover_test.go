@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Moranilt/http-utils/logger"
+	"github.com/Moranilt/http_template/custom_errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func panicHandler(w http.ResponseWriter, r *http.Request) {
+	panic("unexpected")
+}
+
+func TestRecover(t *testing.T) {
+	m := newTestMiddleware()
+
+	t.Run("Panic", func(t *testing.T) {
+		var patronymic *string
+		handler := m.Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_ = *patronymic
+		}))
+
+		w := serve(handler, httptest.NewRequest(http.MethodPost, "/user", nil))
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Contains(t, w.Body.String(), fmt.Sprintf(`"code":%d`, custom_errors.ERR_CODE_Internal))
+	})
+
+	t.Run("Panic after response is written", func(t *testing.T) {
+		handler := m.Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+			panic("unexpected")
+		}))
+
+		w := serve(handler, httptest.NewRequest(http.MethodPost, "/user", nil))
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Empty(t, w.Body.String())
+	})
+
+	t.Run("Panic in handler with timeout", func(t *testing.T) {
+		var logs bytes.Buffer
+		m := &Middleware{logger: logger.New(&logs, logger.TYPE_JSON)}
+		handler := m.Recover(m.Timeout(time.Second)(http.HandlerFunc(panicHandler)))
+
+		w := serve(handler, httptest.NewRequest(http.MethodPost, "/user", nil))
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Contains(t, logs.String(), `"error":"unexpected"`)
+		assert.Contains(t, logs.String(), "middleware.panicHandler")
+	})
+
+	t.Run("Abort handler", func(t *testing.T) {
+		handler := m.Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		}))
+		assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+			serve(handler, httptest.NewRequest(http.MethodGet, "/", nil))
+		})
+	})
+}
@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/Moranilt/http-utils/response"
+	"github.com/Moranilt/http-utils/tiny_errors"
+	"github.com/Moranilt/http_template/custom_errors"
+)
+
+// Sets deadline to request context. Client gets 504 if handler has not started response before deadline,
+// later writes of handler are discarded. Response which is already started is not interrupted.
+// Timeout can be replaced at runtime with SetOverrides, zero timeout without override disables it.
+func (m *Middleware) Timeout(timeout time.Duration) EndpointMiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timeout := timeout
+			if override, ok := m.override(r); ok && override.Timeout > 0 {
+				timeout = override.Timeout
+			}
+			if timeout <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			r = r.WithContext(ctx)
+
+			tw := &timeoutWriter{ResponseWriter: w, header: w.Header().Clone()}
+			done := make(chan struct{})
+			panicChan := make(chan any, 1)
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						if tw.isTimedOut() {
+							m.logger.WithRequestInfo(r).Error("panic after timeout", "error", p, "stack", string(debug.Stack()))
+							return
+						}
+						// stack is captured here, because it is lost when panic is raised again
+						if _, ok := p.(*handlerPanic); !ok && p != http.ErrAbortHandler {
+							p = &handlerPanic{value: p, stack: debug.Stack()}
+						}
+						panicChan <- p
+					}
+				}()
+				next.ServeHTTP(tw, r)
+				close(done)
+			}()
+
+			select {
+			case p := <-panicChan:
+				panic(p)
+			case <-done:
+				return
+			case <-ctx.Done():
+			}
+
+			if !tw.timeout() {
+				// response is already started, wait for handler to finish it
+				select {
+				case p := <-panicChan:
+					panic(p)
+				case <-done:
+				}
+				return
+			}
+
+			m.logger.WithRequestInfo(r).Notice("request timeout", "timeout", timeout.String())
+			err := tiny_errors.New(custom_errors.ERR_CODE_Timeout, tiny_errors.HTTPStatus(http.StatusGatewayTimeout))
+			response.ErrorResponse(w, err, http.StatusGatewayTimeout)
+		})
+	}
+}
+
+// Handler gets own copy of headers, so it can not modify timeout response
+type timeoutWriter struct {
+	http.ResponseWriter
+	header      http.Header
+	mu          sync.Mutex
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+// Copies headers of handler to response, must be called with locked mu
+func (tw *timeoutWriter) startResponse() {
+	if tw.wroteHeader {
+		return
+	}
+	tw.wroteHeader = true
+	dst := tw.ResponseWriter.Header()
+	for name := range dst {
+		if _, ok := tw.header[name]; !ok {
+			delete(dst, name)
+		}
+	}
+	for name, values := range tw.header {
+		dst[name] = values
+	}
+}
+
+// Marks response as timed out if it is not started yet
+func (tw *timeoutWriter) timeout() bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.wroteHeader {
+		return false
+	}
+	tw.timedOut = true
+	return true
+}
+
+func (tw *timeoutWriter) isTimedOut() bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	return tw.timedOut
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.wroteHeader {
+		return
+	}
+	tw.startResponse()
+	tw.ResponseWriter.WriteHeader(code)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	tw.startResponse()
+	return tw.ResponseWriter.Write(b)
+}
+
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	if flusher, ok := tw.ResponseWriter.(http.Flusher); ok {
+		tw.startResponse()
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Moranilt/http_template/custom_errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeout(t *testing.T) {
+	m := newTestMiddleware()
+
+	t.Run("In time", func(t *testing.T) {
+		var deadline bool
+		handler := m.Timeout(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, deadline = r.Context().Deadline()
+			w.Header().Set("Location", "/user/1")
+			w.WriteHeader(http.StatusCreated)
+		}))
+
+		w := serve(handler, httptest.NewRequest(http.MethodPost, "/user", nil))
+		assert.True(t, deadline)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, "/user/1", w.Header().Get("Location"))
+	})
+
+	t.Run("Exceeded", func(t *testing.T) {
+		release := make(chan struct{})
+		handler := m.Timeout(10 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+			<-release
+			w.Header().Set("Location", "/user/1")
+			_, err := w.Write([]byte("late"))
+			assert.ErrorIs(t, err, http.ErrHandlerTimeout)
+			close(release)
+		}))
+
+		w := serve(handler, httptest.NewRequest(http.MethodPost, "/user", nil))
+		assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+		assert.Contains(t, w.Body.String(), fmt.Sprintf(`"code":%d`, custom_errors.ERR_CODE_Timeout))
+
+		release <- struct{}{}
+		<-release
+		assert.Empty(t, w.Header().Get("Location"))
+		assert.NotContains(t, w.Body.String(), "late")
+	})
+
+	t.Run("Response already started", func(t *testing.T) {
+		handler := m.Timeout(10 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			<-r.Context().Done()
+			w.Write([]byte("done"))
+		}))
+
+		w := serve(handler, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Panic", func(t *testing.T) {
+		handler := m.Timeout(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("unexpected")
+		}))
+		defer func() {
+			p, ok := recover().(*handlerPanic)
+			if assert.True(t, ok) {
+				assert.Equal(t, "unexpected", p.value)
+				assert.Contains(t, string(p.stack), "TestTimeout")
+			}
+		}()
+		serve(handler, httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+
+	t.Run("Abort handler", func(t *testing.T) {
+		handler := m.Timeout(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		}))
+		assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+			serve(handler, httptest.NewRequest(http.MethodGet, "/", nil))
+		})
+	})
+}
@@ -0,0 +1,11 @@
+CREATE TABLE files (
+  id UUID PRIMARY KEY,
+  name VARCHAR(255) NOT NULL,
+  storage_key VARCHAR(255) NOT NULL,
+  content_type VARCHAR(255) NOT NULL,
+  size BIGINT NOT NULL,
+  sha256 CHAR(64) NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX files_sha256_idx ON files (sha256);
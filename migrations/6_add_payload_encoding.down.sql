@@ -0,0 +1,2 @@
+ALTER TABLE dead_letters DROP COLUMN IF EXISTS payload_encoding;
+ALTER TABLE outbox DROP COLUMN IF EXISTS payload_encoding;
@@ -0,0 +1,2 @@
+ALTER TABLE outbox ADD COLUMN payload_encoding VARCHAR(16) NOT NULL DEFAULT 'json';
+ALTER TABLE dead_letters ADD COLUMN payload_encoding VARCHAR(16) NOT NULL DEFAULT 'json';
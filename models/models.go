@@ -1,19 +1,111 @@
 package models
 
 import (
+	"encoding/json"
+	"io"
 	"mime/multipart"
+	"time"
 )
 
 type TestRequest struct {
-	Firstname  string  `json:"fistname"`
+	Firstname  string  `json:"firstname"`
 	Lastname   string  `json:"lastname"`
 	Patronymic *string `json:"patronymic"`
 }
 
+// Accepts legacy "fistname" field as alias of "firstname", "firstname" wins when both are present
+func (r *TestRequest) UnmarshalJSON(b []byte) error {
+	type request TestRequest
+	var body struct {
+		request
+		Fistname *string `json:"fistname"`
+	}
+	if err := json.Unmarshal(b, &body); err != nil {
+		return err
+	}
+	*r = TestRequest(body.request)
+	if r.Firstname == "" && body.Fistname != nil {
+		r.Firstname = *body.Fistname
+	}
+	return nil
+}
+
 type TestResponse struct {
 	ID string `json:"id"`
 }
 
+type User struct {
+	ID         string    `json:"id" db:"id"`
+	Firstname  string    `json:"firstname" db:"firstname"`
+	Lastname   string    `json:"lastname" db:"lastname"`
+	Patronymic *string   `json:"patronymic" db:"patronymic"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+type GetUserRequest struct {
+	ID string `mapstructure:"id"`
+}
+
+// Replaces every field of user, patronymic is cleared when it is absent or null
+type ReplaceUserRequest struct {
+	ID         string  `json:"-" mapstructure:"id"`
+	Firstname  string  `json:"firstname"`
+	Lastname   string  `json:"lastname"`
+	Patronymic *string `json:"patronymic"`
+}
+
+// Updates only fields present in body, explicit null clears nullable field
+type UpdateUserRequest struct {
+	ID         string           `json:"-" mapstructure:"id"`
+	Firstname  Optional[string] `json:"firstname"`
+	Lastname   Optional[string] `json:"lastname"`
+	Patronymic Optional[string] `json:"patronymic"`
+}
+
+// Field of partial update which tells absent field from explicit null.
+// Set is true when field is present in body, Value is nil when it is null.
+type Optional[T any] struct {
+	Set   bool
+	Value *T
+}
+
+func (o *Optional[T]) UnmarshalJSON(b []byte) error {
+	o.Set = true
+	o.Value = nil
+	if string(b) == "null" {
+		return nil
+	}
+	var v T
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	o.Value = &v
+	return nil
+}
+
+func (o Optional[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(o.Value)
+}
+
+type DeleteUserRequest struct {
+	ID string `mapstructure:"id"`
+}
+
+type DeleteUserResponse struct {
+	ID string `json:"id"`
+}
+
+type ListUsersRequest struct {
+	Limit  int `mapstructure:"limit"`
+	Offset int `mapstructure:"offset"`
+}
+
+type ListUsersResponse struct {
+	Users  []*User `json:"users"`
+	Limit  int     `json:"limit"`
+	Offset int     `json:"offset"`
+}
+
 type FileRequest struct {
 	Name        string                  `mapstructure:"name"`
 	Files       []*multipart.FileHeader `mapstructure:"file"`
@@ -24,6 +116,33 @@ type FileResponse struct {
 	Name        string                  `mapstructure:"name" json:"name"`
 	Files       []*multipart.FileHeader `mapstructure:"file[]" json:"files"`
 	OneMoreFile *multipart.FileHeader   `mapstructure:"one_more_file" json:"one_more_file"`
+	Uploaded    []*File                 `json:"uploaded"`
+}
+
+// Multipart body is read part by part, so files are never buffered as a whole
+type StreamFilesRequest struct {
+	Reader *multipart.Reader `json:"-"`
+}
+
+// Metadata of file stored in blob storage
+type File struct {
+	ID          string    `json:"id" db:"id"`
+	Name        string    `json:"name" db:"name"`
+	StorageKey  string    `json:"-" db:"storage_key"`
+	ContentType string    `json:"content_type" db:"content_type"`
+	Size        int64     `json:"size" db:"size"`
+	SHA256      string    `json:"sha256" db:"sha256"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+type GetFileRequest struct {
+	ID string `mapstructure:"id"`
+}
+
+// File with its content. Body must be closed by caller.
+type FileContent struct {
+	*File
+	Body io.ReadCloser
 }
 
 type GetRandomNumberRequest struct {
@@ -34,3 +153,44 @@ type GetRandomNumberRequest struct {
 type GetRandomNumberResponse struct {
 	Number int `json:"number"`
 }
+
+// Envelope for every event published to message broker
+type Event struct {
+	ID          string          `json:"id"`
+	Type        string          `json:"type"`
+	Version     int             `json:"version"`
+	OccurredAt  time.Time       `json:"occurred_at"`
+	Source      string          `json:"source"`
+	RequestID   string          `json:"request_id,omitempty"`
+	Traceparent string          `json:"traceparent,omitempty"`
+	Tracestate  string          `json:"tracestate,omitempty"`
+	Attempt     int             `json:"attempt"`
+	Payload     json.RawMessage `json:"payload"`
+}
+
+type DeadLetter struct {
+	ID        string          `json:"id" db:"id"`
+	EventType string          `json:"event_type" db:"event_type"`
+	Payload   json.RawMessage `json:"payload" db:"payload"`
+	// outbox.ENCODING_Base64 when payload is base64 string of body which is not JSON
+	PayloadEncoding string     `json:"payload_encoding" db:"payload_encoding"`
+	Error           string     `json:"error" db:"error"`
+	Attempts        int        `json:"attempts" db:"attempts"`
+	ReplayedAt      *time.Time `json:"replayed_at" db:"replayed_at"`
+	CreatedAt       time.Time  `json:"created_at" db:"created_at"`
+}
+
+type ListDeadLettersRequest struct {
+	Limit  int `mapstructure:"limit"`
+	Offset int `mapstructure:"offset"`
+}
+
+type ListDeadLettersResponse struct {
+	DeadLetters []*DeadLetter `json:"dead_letters"`
+	Limit       int           `json:"limit"`
+	Offset      int           `json:"offset"`
+}
+
+type ReplayDeadLetterRequest struct {
+	ID string `mapstructure:"id"`
+}
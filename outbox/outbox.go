@@ -0,0 +1,387 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Moranilt/http-utils/clients/database"
+	"github.com/Moranilt/http-utils/logger"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	QUERY_Insert = "INSERT INTO outbox (event_type, payload, payload_encoding, available_at) VALUES ($1, $2, $3, $4)"
+	// Claimed messages are not available for other relays until claim expires, so they are published
+	// without holding row locks. Messages which are not marked when claim expires are published again.
+	QUERY_Claim = "WITH pending AS (SELECT id FROM outbox WHERE sent_at IS NULL AND available_at <= now() ORDER BY available_at LIMIT $1 FOR UPDATE SKIP LOCKED) " +
+		"UPDATE outbox SET available_at = $2 FROM pending WHERE outbox.id = pending.id " +
+		"RETURNING outbox.id, outbox.event_type, outbox.payload, outbox.payload_encoding, outbox.attempts, outbox.created_at"
+	QUERY_MarkSent     = "UPDATE outbox SET sent_at = now(), attempts = attempts + 1, last_error = NULL WHERE id = $1"
+	QUERY_MarkFailed   = "UPDATE outbox SET attempts = attempts + 1, last_error = $2, available_at = $3 WHERE id = $1"
+	QUERY_CountPending = "SELECT count(*) FROM outbox WHERE sent_at IS NULL"
+	// Makes claimed messages which were not published available for next batch
+	QUERY_Release = "UPDATE outbox SET available_at = now() WHERE id = ANY($1) AND sent_at IS NULL"
+)
+
+const (
+	DEFAULT_INTERVAL    = 1 * time.Second
+	DEFAULT_BATCH_SIZE  = 100
+	DEFAULT_MIN_BACKOFF = 1 * time.Second
+	DEFAULT_MAX_BACKOFF = 5 * time.Minute
+	// Publisher is abandoned when message is not published in this time
+	DEFAULT_PUBLISH_TIMEOUT = 5 * time.Second
+	DEFAULT_CLAIM_TIMEOUT   = 1 * time.Minute
+)
+
+const (
+	// Payload is stored in JSONB column, so whitespace and key order are not kept
+	ENCODING_JSON = "json"
+	// Payload which is not valid JSON is stored as base64 JSON string, because payload column is JSONB
+	ENCODING_Base64 = "base64"
+)
+
+const TracerName string = "outbox"
+
+var (
+	publishedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "your_app_outbox_published_total",
+		Help: "Total number of outbox messages published by event type",
+	},
+		[]string{"event_type"},
+	)
+	failedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "your_app_outbox_failed_total",
+		Help: "Total number of failed outbox publish attempts by event type",
+	},
+		[]string{"event_type"},
+	)
+	pendingGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "your_app_outbox_pending",
+		Help: "Number of outbox messages waiting to be published",
+	})
+)
+
+// Publisher sends payload to message broker. rabbitmq.RabbitMQClient implements it.
+type Publisher interface {
+	Push(ctx context.Context, data []byte) error
+}
+
+type Message struct {
+	ID        string    `db:"id"`
+	EventType string    `db:"event_type"`
+	Payload   []byte    `db:"payload"`
+	Encoding  string    `db:"payload_encoding"`
+	Attempts  int       `db:"attempts"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// Returns payload which can be stored in JSONB column and its encoding.
+// Valid JSON is stored as is and comes back only semantically equal(Postgres normalizes whitespace,
+// key order and duplicate keys), any other payload is base64 encoded and comes back byte for byte.
+func EncodePayload(body []byte) ([]byte, string, error) {
+	if json.Valid(body) {
+		return body, ENCODING_JSON, nil
+	}
+	payload, err := json.Marshal(body)
+	return payload, ENCODING_Base64, err
+}
+
+// Restores payload stored with EncodePayload. Base64 payload is restored with exact bytes,
+// JSON payload is semantically equal to original one.
+func DecodePayload(payload []byte, encoding string) ([]byte, error) {
+	switch encoding {
+	case ENCODING_JSON:
+		return payload, nil
+	case ENCODING_Base64:
+		var body []byte
+		err := json.Unmarshal(payload, &body)
+		return body, err
+	default:
+		return nil, fmt.Errorf("unknown payload encoding %q", encoding)
+	}
+}
+
+// Write event into outbox. Pass transaction to store event atomically with your changes.
+func Enqueue(ctx context.Context, db sqlx.ExtContext, eventType string, payload []byte) error {
+	return EnqueueAt(ctx, db, eventType, payload, time.Now())
+}
+
+// Write event into outbox which will not be published before provided time.
+// Payload which is not valid JSON is published with exactly the same bytes,
+// JSON payload is published as normalized by JSONB column.
+func EnqueueAt(ctx context.Context, db sqlx.ExtContext, eventType string, payload []byte, at time.Time) error {
+	payload, encoding, err := EncodePayload(payload)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, QUERY_Insert, eventType, payload, encoding, at)
+	return err
+}
+
+type RelayConfig struct {
+	Interval       time.Duration
+	BatchSize      int
+	MinBackoff     time.Duration
+	MaxBackoff     time.Duration
+	PublishTimeout time.Duration
+	// How long claimed messages are not available for other relays, must be greater than PublishTimeout
+	ClaimTimeout time.Duration
+}
+
+// Relay publishes pending outbox messages and marks them as sent.
+// Failed messages are retried with exponential backoff.
+// Messages are claimed and marked in separate short transactions, publishing does not hold database connection.
+type Relay struct {
+	db        *database.Client
+	publisher Publisher
+	log       logger.Logger
+	cfg       RelayConfig
+
+	mu       sync.Mutex
+	inflight *inflightPush
+}
+
+// Push which exceeded PublishTimeout and is still running in background
+type inflightPush struct {
+	msg  *Message
+	done chan error
+}
+
+func NewRelay(db *database.Client, publisher Publisher, log logger.Logger, cfg RelayConfig) *Relay {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DEFAULT_INTERVAL
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DEFAULT_BATCH_SIZE
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = DEFAULT_MIN_BACKOFF
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = DEFAULT_MAX_BACKOFF
+	}
+	if cfg.PublishTimeout <= 0 {
+		cfg.PublishTimeout = DEFAULT_PUBLISH_TIMEOUT
+	}
+	if cfg.ClaimTimeout <= cfg.PublishTimeout {
+		cfg.ClaimTimeout = max(DEFAULT_CLAIM_TIMEOUT, 2*cfg.PublishTimeout)
+	}
+	return &Relay{
+		db:        db,
+		publisher: publisher,
+		log:       log.With("component", TracerName),
+		cfg:       cfg,
+	}
+}
+
+// Run relay until context is done
+func (r *Relay) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if _, err := r.Process(ctx); err != nil {
+				r.log.Error("process outbox", "error", err)
+			}
+		}
+	}
+}
+
+// Process publishes one batch of pending messages. Returns amount of published messages.
+// Batch is stopped when publishing exceeds timeout or ctx is done, not published messages are released
+// for next batch. Nothing is claimed while Push abandoned by previous batch is still running.
+func (r *Relay) Process(ctx context.Context) (int, error) {
+	ctx, span := otel.Tracer(TracerName).Start(ctx, "Process", trace.WithAttributes(
+		attribute.Int("BatchSize", r.cfg.BatchSize),
+	))
+	defer span.End()
+
+	busy, err := r.checkInflight(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "checkInflight")
+		return 0, err
+	}
+	if busy {
+		span.SetAttributes(attribute.Bool("Busy", true))
+		return 0, nil
+	}
+
+	claimedUntil := time.Now().Add(r.cfg.ClaimTimeout)
+	var messages []*Message
+	err = r.db.SelectContext(ctx, &messages, QUERY_Claim, r.cfg.BatchSize, claimedUntil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Claim")
+		return 0, err
+	}
+	if len(messages) == 0 {
+		r.updatePending(ctx)
+		return 0, nil
+	}
+	slices.SortStableFunc(messages, func(a, b *Message) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	errs := make([]error, 0, len(messages))
+	for _, msg := range messages {
+		// message must be marked before claim expires, otherwise another relay publishes it again
+		if ctx.Err() != nil || time.Until(claimedUntil) < r.cfg.PublishTimeout {
+			break
+		}
+		err := r.publish(ctx, msg)
+		errs = append(errs, err)
+		if errors.Is(err, context.DeadlineExceeded) {
+			break
+		}
+	}
+
+	// outcome of publishing is stored even if relay is stopped
+	markCtx := context.WithoutCancel(ctx)
+	tx, err := r.db.BeginTxx(markCtx, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "BeginTxx")
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var published int
+	for i, err := range errs {
+		msg := messages[i]
+		if err != nil {
+			failedCounter.WithLabelValues(msg.EventType).Inc()
+			nextAttempt := time.Now().Add(r.backoff(msg.Attempts))
+			r.log.Error("publish", "id", msg.ID, "event_type", msg.EventType, "attempts", msg.Attempts+1, "next_attempt", nextAttempt, "error", err)
+			if _, err := tx.ExecContext(markCtx, QUERY_MarkFailed, msg.ID, err.Error(), nextAttempt); err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, "MarkFailed")
+				return 0, err
+			}
+			continue
+		}
+
+		if _, err := tx.ExecContext(markCtx, QUERY_MarkSent, msg.ID); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "MarkSent")
+			return 0, err
+		}
+		published++
+	}
+
+	if len(errs) < len(messages) {
+		ids := make(pq.StringArray, 0, len(messages)-len(errs))
+		for _, msg := range messages[len(errs):] {
+			ids = append(ids, msg.ID)
+		}
+		if _, err := tx.ExecContext(markCtx, QUERY_Release, ids); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Release")
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Commit")
+		return 0, err
+	}
+	for i, err := range errs {
+		if err == nil {
+			publishedCounter.WithLabelValues(messages[i].EventType).Inc()
+		}
+	}
+	r.updatePending(markCtx)
+
+	span.SetAttributes(attribute.Int("Published", published))
+	return published, nil
+}
+
+// Push of RabbitMQ client retries until client is closed and ignores ctx,
+// so it is abandoned when timeout is exceeded and tracked as in-flight until it returns.
+func (r *Relay) publish(ctx context.Context, msg *Message) error {
+	body, err := DecodePayload(msg.Payload, msg.Encoding)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.PublishTimeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- r.publisher.Push(ctx, body)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		r.mu.Lock()
+		r.inflight = &inflightPush{msg: msg, done: done}
+		r.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Reports whether abandoned Push is still running, so at most one goroutine is left per relay
+// while broker is unavailable. Message of Push which succeeded after all is marked as sent,
+// otherwise it is already scheduled for retry.
+func (r *Relay) checkInflight(ctx context.Context) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.inflight == nil {
+		return false, nil
+	}
+
+	var err error
+	select {
+	case err = <-r.inflight.done:
+	default:
+		return true, nil
+	}
+	msg := r.inflight.msg
+	r.inflight = nil
+	if err != nil {
+		return false, nil
+	}
+
+	if _, err := r.db.ExecContext(context.WithoutCancel(ctx), QUERY_MarkSent, msg.ID); err != nil {
+		return false, err
+	}
+	publishedCounter.WithLabelValues(msg.EventType).Inc()
+	r.log.Info("abandoned publish confirmed", "id", msg.ID, "event_type", msg.EventType)
+	return false, nil
+}
+
+func (r *Relay) updatePending(ctx context.Context) {
+	var pending int
+	if err := r.db.GetContext(ctx, &pending, QUERY_CountPending); err == nil {
+		pendingGauge.Set(float64(pending))
+	}
+}
+
+func (r *Relay) backoff(attempts int) time.Duration {
+	delay := r.cfg.MinBackoff
+	for i := 0; i < attempts; i++ {
+		delay *= 2
+		if delay >= r.cfg.MaxBackoff {
+			return r.cfg.MaxBackoff
+		}
+	}
+	return delay
+}
@@ -0,0 +1,198 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"regexp"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Moranilt/http-utils/clients/database"
+	database_mock "github.com/Moranilt/http-utils/clients/database/mock"
+	rabbitmq_mock "github.com/Moranilt/http-utils/clients/rabbitmq/mock"
+	"github.com/Moranilt/http-utils/logger"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func mockRelay(t *testing.T) (*Relay, sqlmock.Sqlmock, rabbitmq_mock.RabbitMQMocker) {
+	mockDb, sqlMock := database_mock.NewSQlMock(t)
+	mockRabbitMQ := rabbitmq_mock.NewRabbitMQ(t)
+	relay := NewRelay(&database.Client{DB: mockDb}, mockRabbitMQ, logger.New(io.Discard, logger.TYPE_JSON), RelayConfig{
+		BatchSize:  10,
+		MinBackoff: time.Second,
+		MaxBackoff: 10 * time.Second,
+	})
+	return relay, sqlMock, mockRabbitMQ
+}
+
+// Publisher which does not return until released, like RabbitMQ client while broker is unavailable
+type blockingPublisher struct {
+	calls   atomic.Int32
+	release chan struct{}
+}
+
+func (p *blockingPublisher) Push(ctx context.Context, data []byte) error {
+	p.calls.Add(1)
+	<-p.release
+	return nil
+}
+
+func TestProcess(t *testing.T) {
+	pendingColumns := []string{"id", "event_type", "payload", "payload_encoding", "attempts", "created_at"}
+
+	t.Run("Success", func(t *testing.T) {
+		relay, sqlMock, rabbitMock := mockRelay(t)
+		payload := []byte(`{"id":"1"}`)
+
+		sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_Claim)).
+			WithArgs(10, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(pendingColumns).AddRow("1", "user.created", payload, ENCODING_JSON, 0, time.Now()))
+		rabbitMock.ExpectPush(payload, nil)
+		sqlMock.ExpectBegin()
+		sqlMock.ExpectExec(regexp.QuoteMeta(QUERY_MarkSent)).
+			WithArgs("1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		sqlMock.ExpectCommit()
+		sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_CountPending)).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+		published, err := relay.Process(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, published)
+		assert.NoError(t, sqlMock.ExpectationsWereMet())
+		assert.NoError(t, rabbitMock.AllExpectationsDone())
+	})
+
+	t.Run("Not JSON payload", func(t *testing.T) {
+		relay, sqlMock, rabbitMock := mockRelay(t)
+		body := []byte("not json")
+		payload, encoding, err := EncodePayload(body)
+		assert.NoError(t, err)
+		assert.Equal(t, ENCODING_Base64, encoding)
+
+		sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_Claim)).
+			WithArgs(10, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(pendingColumns).AddRow("1", "user.created", payload, encoding, 0, time.Now()))
+		rabbitMock.ExpectPush(body, nil)
+		sqlMock.ExpectBegin()
+		sqlMock.ExpectExec(regexp.QuoteMeta(QUERY_MarkSent)).
+			WithArgs("1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		sqlMock.ExpectCommit()
+		sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_CountPending)).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+		published, err := relay.Process(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, published)
+		assert.NoError(t, rabbitMock.AllExpectationsDone())
+	})
+
+	t.Run("Publish error schedules retry", func(t *testing.T) {
+		relay, sqlMock, rabbitMock := mockRelay(t)
+		payload := []byte(`{"id":"1"}`)
+
+		sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_Claim)).
+			WithArgs(10, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(pendingColumns).AddRow("1", "user.created", payload, ENCODING_JSON, 2, time.Now()))
+		rabbitMock.ExpectPush(payload, errors.New("rabbitmq error"))
+		sqlMock.ExpectBegin()
+		sqlMock.ExpectExec(regexp.QuoteMeta(QUERY_MarkFailed)).
+			WithArgs("1", "rabbitmq error", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		sqlMock.ExpectCommit()
+		sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_CountPending)).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+		published, err := relay.Process(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 0, published)
+		assert.NoError(t, sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("Publish timeout stops batch", func(t *testing.T) {
+		mockDb, sqlMock := database_mock.NewSQlMock(t)
+		publisher := &blockingPublisher{release: make(chan struct{})}
+		defer close(publisher.release)
+		relay := NewRelay(&database.Client{DB: mockDb}, publisher, logger.New(io.Discard, logger.TYPE_JSON), RelayConfig{
+			BatchSize:      10,
+			PublishTimeout: 10 * time.Millisecond,
+		})
+
+		sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_Claim)).
+			WithArgs(10, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(pendingColumns).
+				AddRow("1", "user.created", []byte(`{"id":"1"}`), ENCODING_JSON, 0, time.Now()).
+				AddRow("2", "user.created", []byte(`{"id":"2"}`), ENCODING_JSON, 0, time.Now().Add(time.Second)))
+		sqlMock.ExpectBegin()
+		sqlMock.ExpectExec(regexp.QuoteMeta(QUERY_MarkFailed)).
+			WithArgs("1", context.DeadlineExceeded.Error(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		sqlMock.ExpectExec(regexp.QuoteMeta(QUERY_Release)).
+			WithArgs(pq.StringArray{"2"}).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		sqlMock.ExpectCommit()
+		sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_CountPending)).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+		published, err := relay.Process(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 0, published)
+		assert.Equal(t, int32(1), publisher.calls.Load())
+		assert.NoError(t, sqlMock.ExpectationsWereMet())
+
+		// nothing is claimed while abandoned push is running
+		published, err = relay.Process(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 0, published)
+		assert.Equal(t, int32(1), publisher.calls.Load())
+		assert.NoError(t, sqlMock.ExpectationsWereMet())
+
+		// message of abandoned push which succeeded is marked as sent
+		publisher.release <- struct{}{}
+		assert.Eventually(t, func() bool {
+			relay.mu.Lock()
+			defer relay.mu.Unlock()
+			return len(relay.inflight.done) == 1
+		}, time.Second, time.Millisecond)
+		sqlMock.ExpectExec(regexp.QuoteMeta(QUERY_MarkSent)).
+			WithArgs("1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_Claim)).
+			WithArgs(10, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(pendingColumns))
+		sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_CountPending)).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+		published, err = relay.Process(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 0, published)
+		assert.NoError(t, sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("Select error", func(t *testing.T) {
+		relay, sqlMock, _ := mockRelay(t)
+		expectedError := errors.New("select error")
+
+		sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_Claim)).
+			WithArgs(10, sqlmock.AnyArg()).
+			WillReturnError(expectedError)
+
+		_, err := relay.Process(context.Background())
+		assert.ErrorIs(t, err, expectedError)
+		assert.NoError(t, sqlMock.ExpectationsWereMet())
+	})
+}
+
+func TestBackoff(t *testing.T) {
+	relay, _, _ := mockRelay(t)
+
+	assert.Equal(t, time.Second, relay.backoff(0))
+	assert.Equal(t, 2*time.Second, relay.backoff(1))
+	assert.Equal(t, 8*time.Second, relay.backoff(3))
+	assert.Equal(t, 10*time.Second, relay.backoff(10))
+}
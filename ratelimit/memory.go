@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// How often expired buckets are removed from memory
+const MEMORY_SWEEP_INTERVAL = time.Minute
+
+type bucket struct {
+	tokens    float64
+	ts        time.Time
+	expiresAt time.Time
+}
+
+// Rate limiter of single instance, used when Redis is disabled.
+// Every instance has own buckets, so limit is multiplied by number of instances.
+type Memory struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	// replaceable in tests
+	now func() time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Same token bucket as Limiter uses in Redis
+func (m *Memory) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	if limit.Requests <= 0 || limit.Period.Milliseconds() <= 0 {
+		return nil, fmt.Errorf("invalid limit %d per %s", limit.Requests, limit.Period)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+
+	rate := limit.rate()
+	burst := float64(limit.burst())
+	b, ok := m.buckets[key]
+	if !ok || !now.Before(b.expiresAt) {
+		b = &bucket{tokens: burst, ts: now}
+		m.buckets[key] = b
+	}
+	elapsed := math.Max(0, float64(now.Sub(b.ts).Milliseconds()))
+	b.tokens = math.Min(burst, b.tokens+elapsed*rate)
+	b.ts = now
+	b.expiresAt = now.Add(time.Duration(math.Ceil(burst/rate)+1000) * time.Millisecond)
+
+	result := &Result{Limit: limit.burst()}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration(math.Ceil((1-b.tokens)/rate)) * time.Millisecond
+	}
+	result.Remaining = int(math.Floor(b.tokens))
+	result.Reset = time.Duration(math.Ceil((burst-b.tokens)/rate)) * time.Millisecond
+	return result, nil
+}
+
+// Must be called with locked mu
+func (m *Memory) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < MEMORY_SWEEP_INTERVAL {
+		return
+	}
+	m.lastSweep = now
+	for key, b := range m.buckets {
+		if !now.Before(b.expiresAt) {
+			delete(m.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemory(t *testing.T) {
+	limit := Limit{Requests: 10, Period: time.Minute, Burst: 2}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+	allow := func(key string) *Result {
+		t.Helper()
+		result, err := m.Allow(context.Background(), key, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	assert.Equal(t, &Result{Allowed: true, Limit: 2, Remaining: 1, Reset: 6 * time.Second}, allow("key"))
+	assert.Equal(t, &Result{Allowed: true, Limit: 2, Remaining: 0, Reset: 12 * time.Second}, allow("key"))
+	assert.Equal(t, &Result{Allowed: false, Limit: 2, Remaining: 0, RetryAfter: 6 * time.Second, Reset: 12 * time.Second}, allow("key"))
+	assert.True(t, allow("other").Allowed, "keys must have own buckets")
+
+	now = now.Add(6 * time.Second)
+	assert.Equal(t, &Result{Allowed: true, Limit: 2, Remaining: 0, Reset: 12 * time.Second}, allow("key"))
+
+	t.Run("Sweep", func(t *testing.T) {
+		now = now.Add(MEMORY_SWEEP_INTERVAL)
+		allow("new")
+		assert.NotContains(t, m.buckets, "key")
+		assert.Contains(t, m.buckets, "new")
+	})
+
+	t.Run("Invalid limit", func(t *testing.T) {
+		_, err := m.Allow(context.Background(), "key", Limit{Requests: 10})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,121 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/Moranilt/http-utils/clients/redis"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+const TracerName string = "ratelimit"
+
+const KEY_PREFIX = "rate_limit:"
+
+// Token bucket. Bucket is refilled with Requests tokens per Period and holds up to Burst tokens.
+// Time is taken from Redis, so all instances share the same clock.
+//
+// Returns {allowed, remaining, retry_after_ms, reset_ms}
+var tokenBucket = goredis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+  tokens = burst
+  ts = now
+end
+
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+
+local allowed = 0
+local retry_after = 0
+if tokens >= cost then
+  tokens = tokens - cost
+  allowed = 1
+else
+  retry_after = math.ceil((cost - tokens) / rate)
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate) + 1000)
+
+return {allowed, math.floor(tokens), retry_after, math.ceil((burst - tokens) / rate)}
+`)
+
+type Limit struct {
+	Requests int
+	Period   time.Duration
+	// Maximum number of requests at once, equals to Requests when empty
+	Burst int
+}
+
+func (l Limit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Requests
+}
+
+// Tokens per millisecond
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / float64(l.Period.Milliseconds())
+}
+
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Time to wait until request will be allowed
+	RetryAfter time.Duration
+	// Time until bucket is full again
+	Reset time.Duration
+}
+
+// Distributed rate limiter based on Redis
+type Limiter struct {
+	redis *redis.Client
+}
+
+func New(redis *redis.Client) *Limiter {
+	return &Limiter{redis: redis}
+}
+
+func (l *Limiter) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	if limit.Requests <= 0 || limit.Period.Milliseconds() <= 0 {
+		return nil, fmt.Errorf("invalid limit %d per %s", limit.Requests, limit.Period)
+	}
+
+	values, err := tokenBucket.Run(ctx, l.redis, []string{KEY_PREFIX + key},
+		formatFloat(limit.rate()), limit.burst(), 1,
+	).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) != 4 {
+		return nil, fmt.Errorf("unexpected rate limit result %v", values)
+	}
+
+	return &Result{
+		Allowed:    values[0] == 1,
+		Limit:      limit.burst(),
+		Remaining:  int(values[1]),
+		RetryAfter: time.Duration(values[2]) * time.Millisecond,
+		Reset:      time.Duration(values[3]) * time.Millisecond,
+	}, nil
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return "0"
+	}
+	return fmt.Sprintf("%.12f", v)
+}
@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	redis_mock "github.com/Moranilt/http-utils/clients/redis/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllow(t *testing.T) {
+	limit := Limit{Requests: 10, Period: time.Minute}
+	args := []any{formatFloat(limit.rate()), 10, 1}
+
+	t.Run("Allowed", func(t *testing.T) {
+		mockRedis, redisMock := redis_mock.New()
+		redisMock.ExpectEvalSha(tokenBucket.Hash(), []string{KEY_PREFIX + "key"}, args...).
+			SetVal([]any{int64(1), int64(9), int64(0), int64(6000)})
+
+		result, err := New(mockRedis).Allow(context.Background(), "key", limit)
+		assert.NoError(t, err)
+		assert.Equal(t, &Result{Allowed: true, Limit: 10, Remaining: 9, Reset: 6 * time.Second}, result)
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		mockRedis, redisMock := redis_mock.New()
+		redisMock.ExpectEvalSha(tokenBucket.Hash(), []string{KEY_PREFIX + "key"}, args...).
+			SetVal([]any{int64(0), int64(0), int64(4500), int64(60000)})
+
+		result, err := New(mockRedis).Allow(context.Background(), "key", limit)
+		assert.NoError(t, err)
+		assert.Equal(t, &Result{Allowed: false, Limit: 10, Remaining: 0, RetryAfter: 4500 * time.Millisecond, Reset: time.Minute}, result)
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("Redis error", func(t *testing.T) {
+		mockRedis, redisMock := redis_mock.New()
+		redisMock.ExpectEvalSha(tokenBucket.Hash(), []string{KEY_PREFIX + "key"}, args...).
+			SetErr(errors.New("connection refused"))
+
+		_, err := New(mockRedis).Allow(context.Background(), "key", limit)
+		assert.Error(t, err)
+	})
+
+	t.Run("Invalid limit", func(t *testing.T) {
+		mockRedis, _ := redis_mock.New()
+		_, err := New(mockRedis).Allow(context.Background(), "key", Limit{Requests: 10})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/Moranilt/http_template/cache"
+	"github.com/Moranilt/http_template/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	CACHE_ENTITY_User = "user"
+)
+
+var (
+	cacheHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "your_app_cache_hits_total",
+		Help: "Total number of cache hits by entity",
+	},
+		[]string{"entity"},
+	)
+	cacheMisses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "your_app_cache_misses_total",
+		Help: "Total number of cache misses by entity",
+	},
+		[]string{"entity"},
+	)
+)
+
+func userCacheKey(id string) string {
+	return CACHE_ENTITY_User + ":" + id
+}
+
+// Returns user from cache. Any cache error is logged and treated as a miss,
+// so the caller always falls back to the database.
+func (repo *Repository) getCachedUser(ctx context.Context, id string) (*models.User, bool) {
+	b, err := repo.cache.Get(ctx, userCacheKey(id))
+	if err != nil {
+		if !errors.Is(err, cache.ErrNotFound) {
+			repo.log.WithRequestId(ctx).Error("cache get", "key", userCacheKey(id), "error", err)
+		}
+		cacheMisses.WithLabelValues(CACHE_ENTITY_User).Inc()
+		return nil, false
+	}
+
+	var user models.User
+	if err := json.Unmarshal(b, &user); err != nil {
+		repo.log.WithRequestId(ctx).Error("cache unmarshal", "key", userCacheKey(id), "error", err)
+		cacheMisses.WithLabelValues(CACHE_ENTITY_User).Inc()
+		return nil, false
+	}
+
+	cacheHits.WithLabelValues(CACHE_ENTITY_User).Inc()
+	return &user, true
+}
+
+func (repo *Repository) cacheUser(ctx context.Context, user *models.User) error {
+	b, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	return repo.cache.Set(ctx, userCacheKey(user.ID), b, REDIS_TTL)
+}
+
+// Called after commit, so it is not canceled with request to not leave stale user in cache
+func (repo *Repository) invalidateUser(ctx context.Context, id string) {
+	if err := repo.cache.Del(context.WithoutCancel(ctx), userCacheKey(id)); err != nil {
+		repo.log.WithRequestId(ctx).Error("cache invalidate", "key", userCacheKey(id), "error", err)
+	}
+}
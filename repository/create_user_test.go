@@ -5,39 +5,50 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"net/http"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/Moranilt/http-utils/clients/database"
 	database_mock "github.com/Moranilt/http-utils/clients/database/mock"
-	rabbitmq_mock "github.com/Moranilt/http-utils/clients/rabbitmq/mock"
 	redis_mock "github.com/Moranilt/http-utils/clients/redis/mock"
 	"github.com/Moranilt/http-utils/logger"
+	"github.com/Moranilt/http_template/cache"
+	"github.com/Moranilt/http_template/custom_errors"
 	"github.com/Moranilt/http_template/models"
+	"github.com/Moranilt/http_template/outbox"
+	"github.com/Moranilt/http_template/storage"
 	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
 )
 
 type mockedRepository struct {
-	repo         *Repository
-	sqlMock      sqlmock.Sqlmock
-	rabbitmqMock rabbitmq_mock.RabbitMQMocker
-	redisMock    redismock.ClientMock
+	repo      *Repository
+	sqlMock   sqlmock.Sqlmock
+	redisMock redismock.ClientMock
+	storage   storage.Storage
 }
 
 func mockRepository(t *testing.T) *mockedRepository {
 	mockDb, sqlMock := database_mock.NewSQlMock(t)
-	mockRabbitMQ := rabbitmq_mock.NewRabbitMQ(t)
 	mockRedis, redisMock := redis_mock.New()
 	mockLogger := logger.New(io.Discard, logger.TYPE_JSON)
+	mockStorage, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	repo := New(&database.Client{mockDb}, mockRabbitMQ, mockRedis, mockLogger)
+	repo := New(&database.Client{DB: mockDb}, cache.NewRedis(mockRedis), mockStorage, mockLogger)
+	repo.now = func() time.Time { return eventTime }
+	repo.newID = func() string { return eventID }
 
 	return &mockedRepository{
-		repo:         repo,
-		sqlMock:      sqlMock,
-		rabbitmqMock: mockRabbitMQ,
-		redisMock:    redisMock,
+		repo:      repo,
+		sqlMock:   sqlMock,
+		redisMock: redisMock,
+		storage:   mockStorage,
 	}
 }
 
@@ -45,6 +56,40 @@ func makePointer(v string) *string {
 	return &v
 }
 
+var createdAt = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const eventID = "7d3c3a4e-0c5b-4a51-9a3e-3f1d1f6a2b10"
+
+var eventTime = time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+
+func expectedEvent(eventType string, payload any) []byte {
+	b, _ := json.Marshal(payload)
+	event, _ := json.Marshal(&models.Event{
+		ID:         eventID,
+		Type:       eventType,
+		Version:    EVENT_Version,
+		OccurredAt: eventTime,
+		Source:     EVENT_Source,
+		Payload:    b,
+	})
+	return event
+}
+
+func createdUser(id string, req models.TestRequest) *models.User {
+	return &models.User{
+		ID:         id,
+		Firstname:  req.Firstname,
+		Lastname:   req.Lastname,
+		Patronymic: req.Patronymic,
+		CreatedAt:  createdAt,
+	}
+}
+
+func cachedUser(id string, req models.TestRequest) []byte {
+	b, _ := json.Marshal(createdUser(id, req))
+	return b
+}
+
 func TestCreateUser(t *testing.T) {
 	// Mock repository dependencies
 	mockedRepo := mockRepository(t)
@@ -56,16 +101,20 @@ func TestCreateUser(t *testing.T) {
 			Patronymic: makePointer("Michael"),
 		}
 		expectedID := "1"
-		exectedBody, _ := json.Marshal(expectedUser)
+		exectedBody := expectedEvent(EVENT_UserCreated, createdUser(expectedID, expectedUser))
 		// Set up mock expectations
+		mockedRepo.sqlMock.ExpectBegin()
 		mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_InsertUser)).
 			WithArgs(expectedUser.Firstname, expectedUser.Lastname, expectedUser.Patronymic).
-			WillReturnRows(sqlmock.NewRows([]string{"id"}).
-				AddRow(expectedID))
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).
+				AddRow(expectedID, createdAt))
+		mockedRepo.sqlMock.ExpectExec(regexp.QuoteMeta(outbox.QUERY_Insert)).
+			WithArgs(EVENT_UserCreated, exectedBody, outbox.ENCODING_JSON, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mockedRepo.sqlMock.ExpectCommit()
 
-		mockedRepo.redisMock.ExpectSet(expectedID, exectedBody, REDIS_TTL).SetVal(string(exectedBody))
+		mockedRepo.redisMock.ExpectSet(userCacheKey(expectedID), cachedUser(expectedID, expectedUser), REDIS_TTL).SetVal("OK")
 
-		mockedRepo.rabbitmqMock.ExpectPush(exectedBody, nil)
 		// Call Test()
 		response, err := mockedRepo.repo.CreateUser(context.Background(), &expectedUser)
 		if err != nil {
@@ -78,6 +127,33 @@ func TestCreateUser(t *testing.T) {
 		}
 	})
 
+	t.Run("Without patronymic", func(t *testing.T) {
+		expectedUser := models.TestRequest{
+			Firstname: "John",
+			Lastname:  "Doe",
+		}
+		expectedID := "2"
+		mockedRepo.sqlMock.ExpectBegin()
+		mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_InsertUser)).
+			WithArgs(expectedUser.Firstname, expectedUser.Lastname, nil).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).
+				AddRow(expectedID, createdAt))
+		mockedRepo.sqlMock.ExpectExec(regexp.QuoteMeta(outbox.QUERY_Insert)).
+			WithArgs(EVENT_UserCreated, expectedEvent(EVENT_UserCreated, createdUser(expectedID, expectedUser)), outbox.ENCODING_JSON, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mockedRepo.sqlMock.ExpectCommit()
+
+		mockedRepo.redisMock.ExpectSet(userCacheKey(expectedID), cachedUser(expectedID, expectedUser), REDIS_TTL).SetVal("OK")
+
+		response, err := mockedRepo.repo.CreateUser(context.Background(), &expectedUser)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.ID != expectedID {
+			t.Errorf("Expected ID %s, got %s", expectedID, response.ID)
+		}
+	})
+
 	t.Run("Error query", func(t *testing.T) {
 		// Set error expectations
 		expectedUser := models.TestRequest{
@@ -88,10 +164,12 @@ func TestCreateUser(t *testing.T) {
 		expectedID := "1"
 
 		expectedError := errors.New("query error")
+		mockedRepo.sqlMock.ExpectBegin()
 		mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_InsertUser)).
 			WithArgs(expectedUser.Firstname, expectedUser.Lastname, expectedUser.Patronymic).
-			WillReturnRows(sqlmock.NewRows([]string{"id"}).
-				AddRow(expectedID)).WillReturnError(expectedError)
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).
+				AddRow(expectedID, createdAt)).WillReturnError(expectedError)
+		mockedRepo.sqlMock.ExpectRollback()
 
 		response, err := mockedRepo.repo.CreateUser(context.Background(), &expectedUser)
 		if err.Error() != expectedError.Error() {
@@ -103,7 +181,7 @@ func TestCreateUser(t *testing.T) {
 		}
 	})
 
-	t.Run("Error redis set", func(t *testing.T) {
+	t.Run("Error outbox insert", func(t *testing.T) {
 		// Set error expectations
 		expectedUser := models.TestRequest{
 			Firstname:  "John",
@@ -111,15 +189,18 @@ func TestCreateUser(t *testing.T) {
 			Patronymic: makePointer("Michael"),
 		}
 		expectedID := "1"
-		exectedBody, _ := json.Marshal(expectedUser)
-		expectedError := errors.New("redis error")
+		exectedBody := expectedEvent(EVENT_UserCreated, createdUser(expectedID, expectedUser))
+		expectedError := errors.New("outbox error")
 		// Set up mock expectations
+		mockedRepo.sqlMock.ExpectBegin()
 		mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_InsertUser)).
 			WithArgs(expectedUser.Firstname, expectedUser.Lastname, expectedUser.Patronymic).
-			WillReturnRows(sqlmock.NewRows([]string{"id"}).
-				AddRow(expectedID))
-
-		mockedRepo.redisMock.ExpectSet(expectedID, exectedBody, REDIS_TTL).SetErr(expectedError)
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).
+				AddRow(expectedID, createdAt))
+		mockedRepo.sqlMock.ExpectExec(regexp.QuoteMeta(outbox.QUERY_Insert)).
+			WithArgs(EVENT_UserCreated, exectedBody, outbox.ENCODING_JSON, sqlmock.AnyArg()).
+			WillReturnError(expectedError)
+		mockedRepo.sqlMock.ExpectRollback()
 
 		response, err := mockedRepo.repo.CreateUser(context.Background(), &expectedUser)
 		if err.Error() != expectedError.Error() {
@@ -131,33 +212,63 @@ func TestCreateUser(t *testing.T) {
 		}
 	})
 
-	t.Run("Error rabbitmq push", func(t *testing.T) {
-		// Set error expectations
+	t.Run("Error redis set", func(t *testing.T) {
+		// Cache errors must not fail request after commit
 		expectedUser := models.TestRequest{
 			Firstname:  "John",
 			Lastname:   "Doe",
 			Patronymic: makePointer("Michael"),
 		}
 		expectedID := "1"
-		exectedBody, _ := json.Marshal(expectedUser)
-		expectedError := errors.New("rabbitmq error")
+		exectedBody := expectedEvent(EVENT_UserCreated, createdUser(expectedID, expectedUser))
+		expectedError := errors.New("redis error")
 		// Set up mock expectations
+		mockedRepo.sqlMock.ExpectBegin()
 		mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_InsertUser)).
 			WithArgs(expectedUser.Firstname, expectedUser.Lastname, expectedUser.Patronymic).
-			WillReturnRows(sqlmock.NewRows([]string{"id"}).
-				AddRow(expectedID))
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).
+				AddRow(expectedID, createdAt))
+		mockedRepo.sqlMock.ExpectExec(regexp.QuoteMeta(outbox.QUERY_Insert)).
+			WithArgs(EVENT_UserCreated, exectedBody, outbox.ENCODING_JSON, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mockedRepo.sqlMock.ExpectCommit()
 
-		mockedRepo.redisMock.ExpectSet(expectedID, exectedBody, REDIS_TTL).SetVal(string(exectedBody))
-		mockedRepo.rabbitmqMock.ExpectPush(exectedBody, expectedError)
+		mockedRepo.redisMock.ExpectSet(userCacheKey(expectedID), cachedUser(expectedID, expectedUser), REDIS_TTL).SetErr(expectedError)
 
 		response, err := mockedRepo.repo.CreateUser(context.Background(), &expectedUser)
-		if err.Error() != expectedError.Error() {
-			t.Errorf("Expected error %q but got %q", expectedError, err)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
 		}
 
-		if response != nil {
-			t.Errorf("Expected nil response on error, got %v", response)
+		if response == nil || response.ID != expectedID {
+			t.Errorf("Expected ID %s, got %v", expectedID, response)
+		}
+	})
+
+	t.Run("Null body", func(t *testing.T) {
+		response, err := mockedRepo.repo.CreateUser(context.Background(), nil)
+		assert.Nil(t, response)
+		if assert.NotNil(t, err) {
+			assert.Equal(t, custom_errors.ERR_CODE_BodyRequired, err.GetCode())
+			assert.Equal(t, http.StatusBadRequest, err.GetHTTPStatus())
 		}
 	})
 
+	if err := mockedRepo.sqlMock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCreateUserRequestFirstnameAlias(t *testing.T) {
+	for body, expected := range map[string]string{
+		`{"firstname":"John","lastname":"Doe"}`:                   "John",
+		`{"fistname":"John","lastname":"Doe"}`:                    "John",
+		`{"firstname":"John","fistname":"Jack","lastname":"Doe"}`: "John",
+	} {
+		var req models.TestRequest
+		if assert.NoError(t, json.Unmarshal([]byte(body), &req), body) {
+			assert.Equal(t, expected, req.Firstname, body)
+			assert.Equal(t, "Doe", req.Lastname, body)
+		}
+	}
 }
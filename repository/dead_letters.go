@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Moranilt/http-utils/tiny_errors"
+	"github.com/Moranilt/http_template/consumer"
+	"github.com/Moranilt/http_template/custom_errors"
+	"github.com/Moranilt/http_template/models"
+	"github.com/Moranilt/http_template/outbox"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	QUERY_InsertDeadLetter    = "INSERT INTO dead_letters (event_type, payload, payload_encoding, error, attempts) VALUES ($1, $2, $3, $4, $5)"
+	QUERY_ListDeadLetters     = "SELECT id, event_type, payload, payload_encoding, error, attempts, replayed_at, created_at FROM dead_letters ORDER BY created_at DESC, id LIMIT $1 OFFSET $2"
+	QUERY_GetDeadLetterLocked = "SELECT id, event_type, payload, payload_encoding, error, attempts, replayed_at, created_at FROM dead_letters WHERE id = $1 FOR UPDATE"
+	QUERY_MarkReplayed        = "UPDATE dead_letters SET replayed_at = now() WHERE id = $1 RETURNING replayed_at"
+)
+
+// Implements consumer.Retrier. Retry is stored in outbox and published by outbox.Relay after provided time.
+func (repo *Repository) ScheduleRetry(ctx context.Context, msgType string, body []byte, at time.Time) error {
+	return outbox.EnqueueAt(ctx, repo.db, msgType, body, at)
+}
+
+// Implements consumer.Retrier. Body which is not valid JSON is stored with outbox.EncodePayload,
+// so it is replayed with exactly the same bytes.
+func (repo *Repository) StoreDeadLetter(ctx context.Context, msgType string, body []byte, attempts int, reason string) error {
+	payload, encoding, err := outbox.EncodePayload(body)
+	if err != nil {
+		return err
+	}
+	_, err = repo.db.ExecContext(ctx, QUERY_InsertDeadLetter, msgType, payload, encoding, reason, attempts)
+	return err
+}
+
+func (repo *Repository) ListDeadLetters(ctx context.Context, req *models.ListDeadLettersRequest) (*models.ListDeadLettersResponse, tiny_errors.ErrorHandler) {
+	// request is nil when query string is empty
+	if req == nil {
+		req = &models.ListDeadLettersRequest{}
+	}
+	repo.log.WithRequestId(ctx).InfoContext(ctx, TracerName, "data", req)
+	newCtx, span := otel.Tracer(TracerName).Start(ctx, "ListDeadLetters", trace.WithAttributes(
+		attribute.Int("Limit", req.Limit),
+		attribute.Int("Offset", req.Offset),
+	))
+	defer span.End()
+
+	limit, err := validatePagination(req.Limit, req.Offset)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "validatePagination")
+		return nil, err
+	}
+
+	deadLetters := make([]*models.DeadLetter, 0, limit)
+	if err := repo.db.SelectContext(newCtx, &deadLetters, QUERY_ListDeadLetters, limit, req.Offset); err != nil {
+		dbErr := databaseError(err)
+		span.RecordError(dbErr)
+		span.SetStatus(codes.Error, "SelectContext")
+		return nil, dbErr
+	}
+
+	return &models.ListDeadLettersResponse{
+		DeadLetters: deadLetters,
+		Limit:       limit,
+		Offset:      req.Offset,
+	}, nil
+}
+
+// Put dead letter back into the queue with reset attempts counter
+func (repo *Repository) ReplayDeadLetter(ctx context.Context, req *models.ReplayDeadLetterRequest) (*models.DeadLetter, tiny_errors.ErrorHandler) {
+	repo.log.WithRequestId(ctx).InfoContext(ctx, TracerName, "data", req)
+	newCtx, span := otel.Tracer(TracerName).Start(ctx, "ReplayDeadLetter", trace.WithAttributes(
+		attribute.String("ID", req.ID),
+	))
+	defer span.End()
+
+	if err := validateUUID("id", req.ID); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "validateUUID")
+		return nil, err
+	}
+
+	tx, err := repo.db.BeginTxx(newCtx, nil)
+	if err != nil {
+		return nil, databaseError(err)
+	}
+	defer tx.Rollback()
+
+	var deadLetter models.DeadLetter
+	err = tx.GetContext(newCtx, &deadLetter, QUERY_GetDeadLetterLocked, req.ID)
+	if err != nil {
+		dbErr := databaseError(err, tiny_errors.Detail("id", req.ID))
+		span.RecordError(dbErr)
+		span.SetStatus(codes.Error, "GetContext")
+		return nil, dbErr
+	}
+
+	if deadLetter.ReplayedAt != nil {
+		err := tiny_errors.New(
+			custom_errors.ERR_CODE_Exists,
+			tiny_errors.Message("dead letter already replayed"),
+			tiny_errors.Detail("replayed_at", deadLetter.ReplayedAt.Format(time.RFC3339)),
+			tiny_errors.HTTPStatus(http.StatusConflict),
+		)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "replayed")
+		return nil, err
+	}
+
+	body, err := outbox.DecodePayload(deadLetter.Payload, deadLetter.PayloadEncoding)
+	if err != nil {
+		return nil, tiny_errors.New(custom_errors.ERR_CODE_Marshal, tiny_errors.Message(err.Error()))
+	}
+	// attempt can be reset only in envelope, body which is not JSON is replayed as is
+	if deadLetter.PayloadEncoding == outbox.ENCODING_JSON {
+		body, err = consumer.SetAttempt(body, deadLetter.EventType, 0)
+		if err != nil {
+			return nil, tiny_errors.New(custom_errors.ERR_CODE_Marshal, tiny_errors.Message(err.Error()))
+		}
+	}
+
+	err = outbox.Enqueue(newCtx, tx, deadLetter.EventType, body)
+	if err != nil {
+		return nil, databaseError(err, tiny_errors.Detail("event", deadLetter.EventType))
+	}
+
+	err = tx.GetContext(newCtx, &deadLetter.ReplayedAt, QUERY_MarkReplayed, req.ID)
+	if err != nil {
+		return nil, databaseError(err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, databaseError(err)
+	}
+
+	return &deadLetter, nil
+}
@@ -0,0 +1,180 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Moranilt/http_template/custom_errors"
+	"github.com/Moranilt/http_template/models"
+	"github.com/Moranilt/http_template/outbox"
+	"github.com/stretchr/testify/assert"
+)
+
+var deadLetterColumns = []string{"id", "event_type", "payload", "payload_encoding", "error", "attempts", "replayed_at", "created_at"}
+
+// Matches argument and keeps its value
+type captureArg struct {
+	value []byte
+}
+
+func (c *captureArg) Match(v driver.Value) bool {
+	c.value, _ = v.([]byte)
+	return true
+}
+
+func TestStoreDeadLetter(t *testing.T) {
+	mockedRepo := mockRepository(t)
+
+	t.Run("JSON body", func(t *testing.T) {
+		body := []byte(`{"type":"user.created","payload":{}}`)
+		mockedRepo.sqlMock.ExpectExec(regexp.QuoteMeta(QUERY_InsertDeadLetter)).
+			WithArgs(EVENT_UserCreated, body, outbox.ENCODING_JSON, "handler error", 6).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err := mockedRepo.repo.StoreDeadLetter(context.Background(), EVENT_UserCreated, body, 6, "handler error")
+		assert.NoError(t, err)
+	})
+
+	t.Run("Not JSON body", func(t *testing.T) {
+		mockedRepo.sqlMock.ExpectExec(regexp.QuoteMeta(QUERY_InsertDeadLetter)).
+			WithArgs(EVENT_UserCreated, []byte(`"bm90IGpzb24="`), outbox.ENCODING_Base64, "decode payload", 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err := mockedRepo.repo.StoreDeadLetter(context.Background(), EVENT_UserCreated, []byte("not json"), 1, "decode payload")
+		assert.NoError(t, err)
+	})
+
+	assert.NoError(t, mockedRepo.sqlMock.ExpectationsWereMet())
+}
+
+func TestListDeadLetters(t *testing.T) {
+	mockedRepo := mockRepository(t)
+
+	mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_ListDeadLetters)).
+		WithArgs(DEFAULT_LIMIT, 0).
+		WillReturnRows(sqlmock.NewRows(deadLetterColumns).
+			AddRow("1", EVENT_UserCreated, []byte(`{"type":"user.created","attempt":5,"payload":{}}`), outbox.ENCODING_JSON, "handler error", 6, nil, createdAt))
+
+	response, err := mockedRepo.repo.ListDeadLetters(context.Background(), &models.ListDeadLettersRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if assert.Len(t, response.DeadLetters, 1) {
+		assert.Equal(t, EVENT_UserCreated, response.DeadLetters[0].EventType)
+		assert.Nil(t, response.DeadLetters[0].ReplayedAt)
+	}
+	assert.NoError(t, mockedRepo.sqlMock.ExpectationsWereMet())
+}
+
+func TestListDeadLettersNoQuery(t *testing.T) {
+	mockedRepo := mockRepository(t)
+
+	mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_ListDeadLetters)).
+		WithArgs(DEFAULT_LIMIT, 0).
+		WillReturnRows(sqlmock.NewRows(deadLetterColumns))
+
+	response, err := mockedRepo.repo.ListDeadLetters(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Empty(t, response.DeadLetters)
+	assert.Equal(t, DEFAULT_LIMIT, response.Limit)
+	assert.NoError(t, mockedRepo.sqlMock.ExpectationsWereMet())
+}
+
+func TestReplayDeadLetter(t *testing.T) {
+	mockedRepo := mockRepository(t)
+	expectedID := "3f0c1f0e-5bd6-4b8e-9f0c-3c1e0d5f1a11"
+
+	t.Run("Success", func(t *testing.T) {
+		mockedRepo.sqlMock.ExpectBegin()
+		mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_GetDeadLetterLocked)).
+			WithArgs(expectedID).
+			WillReturnRows(sqlmock.NewRows(deadLetterColumns).
+				AddRow(expectedID, EVENT_UserCreated, []byte(`{"type":"user.created","attempt":5,"payload":{}}`), outbox.ENCODING_JSON, "handler error", 6, nil, createdAt))
+		mockedRepo.sqlMock.ExpectExec(regexp.QuoteMeta(outbox.QUERY_Insert)).
+			WithArgs(EVENT_UserCreated, []byte(`{"attempt":0,"payload":{},"type":"user.created"}`), outbox.ENCODING_JSON, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_MarkReplayed)).
+			WithArgs(expectedID).
+			WillReturnRows(sqlmock.NewRows([]string{"replayed_at"}).AddRow(createdAt))
+		mockedRepo.sqlMock.ExpectCommit()
+
+		response, err := mockedRepo.repo.ReplayDeadLetter(context.Background(), &models.ReplayDeadLetterRequest{ID: expectedID})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, expectedID, response.ID)
+		if assert.NotNil(t, response.ReplayedAt) {
+			assert.Equal(t, createdAt, *response.ReplayedAt)
+		}
+	})
+
+	t.Run("Not JSON body", func(t *testing.T) {
+		body := []byte("not json\x00\xff")
+		stored := &captureArg{}
+		mockedRepo.sqlMock.ExpectExec(regexp.QuoteMeta(QUERY_InsertDeadLetter)).
+			WithArgs(EVENT_UserCreated, stored, outbox.ENCODING_Base64, "decode payload", 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		if err := mockedRepo.repo.StoreDeadLetter(context.Background(), EVENT_UserCreated, body, 1, "decode payload"); err != nil {
+			t.Fatal(err)
+		}
+
+		enqueued := &captureArg{}
+		mockedRepo.sqlMock.ExpectBegin()
+		mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_GetDeadLetterLocked)).
+			WithArgs(expectedID).
+			WillReturnRows(sqlmock.NewRows(deadLetterColumns).
+				AddRow(expectedID, EVENT_UserCreated, stored.value, outbox.ENCODING_Base64, "decode payload", 1, nil, createdAt))
+		mockedRepo.sqlMock.ExpectExec(regexp.QuoteMeta(outbox.QUERY_Insert)).
+			WithArgs(EVENT_UserCreated, enqueued, outbox.ENCODING_Base64, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_MarkReplayed)).
+			WithArgs(expectedID).
+			WillReturnRows(sqlmock.NewRows([]string{"replayed_at"}).AddRow(createdAt))
+		mockedRepo.sqlMock.ExpectCommit()
+
+		_, err := mockedRepo.repo.ReplayDeadLetter(context.Background(), &models.ReplayDeadLetterRequest{ID: expectedID})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		replayed, decodeErr := outbox.DecodePayload(enqueued.value, outbox.ENCODING_Base64)
+		assert.NoError(t, decodeErr)
+		assert.Equal(t, body, replayed)
+	})
+
+	t.Run("Already replayed", func(t *testing.T) {
+		mockedRepo.sqlMock.ExpectBegin()
+		mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_GetDeadLetterLocked)).
+			WithArgs(expectedID).
+			WillReturnRows(sqlmock.NewRows(deadLetterColumns).
+				AddRow(expectedID, EVENT_UserCreated, []byte(`{}`), outbox.ENCODING_JSON, "handler error", 6, createdAt, createdAt))
+		mockedRepo.sqlMock.ExpectRollback()
+
+		response, err := mockedRepo.repo.ReplayDeadLetter(context.Background(), &models.ReplayDeadLetterRequest{ID: expectedID})
+		assert.Nil(t, response)
+		assert.Equal(t, custom_errors.ERR_CODE_Exists, err.GetCode())
+	})
+
+	t.Run("Not found", func(t *testing.T) {
+		mockedRepo.sqlMock.ExpectBegin()
+		mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_GetDeadLetterLocked)).
+			WithArgs(expectedID).
+			WillReturnError(sql.ErrNoRows)
+		mockedRepo.sqlMock.ExpectRollback()
+
+		response, err := mockedRepo.repo.ReplayDeadLetter(context.Background(), &models.ReplayDeadLetterRequest{ID: expectedID})
+		assert.Nil(t, response)
+		assert.Equal(t, custom_errors.ERR_CODE_NotFound, err.GetCode())
+	})
+
+	assert.NoError(t, mockedRepo.sqlMock.ExpectationsWereMet())
+}
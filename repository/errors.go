@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Moranilt/http-utils/tiny_errors"
+	"github.com/Moranilt/http_template/custom_errors"
+	"github.com/Moranilt/http_template/storage"
+	"github.com/Moranilt/http_template/utils"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	PG_CODE_UniqueViolation = "23505"
+)
+
+const (
+	DEFAULT_LIMIT = 20
+	MAX_LIMIT     = 100
+)
+
+// Converts database error to tiny_errors.ErrorHandler.
+//
+// sql.ErrNoRows becomes ERR_CODE_NotFound, unique violation becomes ERR_CODE_Exists,
+// exceeded deadline of request becomes ERR_CODE_Timeout, everything else is ERR_CODE_Database.
+func databaseError(err error, options ...tiny_errors.ErrorOption) tiny_errors.ErrorHandler {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return timeoutError(options...)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return tiny_errors.New(
+			custom_errors.ERR_CODE_NotFound,
+			append(options, tiny_errors.HTTPStatus(http.StatusNotFound))...,
+		)
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == PG_CODE_UniqueViolation {
+		return tiny_errors.New(
+			custom_errors.ERR_CODE_Exists,
+			append(options, tiny_errors.HTTPStatus(http.StatusConflict), tiny_errors.Detail("constraint", pqErr.Constraint))...,
+		)
+	}
+
+	return tiny_errors.New(
+		custom_errors.ERR_CODE_Database,
+		append(options, tiny_errors.Message(err.Error()))...,
+	)
+}
+
+// Converts storage error to tiny_errors.ErrorHandler. storage.ErrNotFound becomes ERR_CODE_NotFound,
+// exceeded deadline of request becomes ERR_CODE_Timeout.
+func storageError(err error, options ...tiny_errors.ErrorOption) tiny_errors.ErrorHandler {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return timeoutError(options...)
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		return tiny_errors.New(
+			custom_errors.ERR_CODE_NotFound,
+			append(options, tiny_errors.HTTPStatus(http.StatusNotFound))...,
+		)
+	}
+
+	return tiny_errors.New(
+		custom_errors.ERR_CODE_Storage,
+		append(options, tiny_errors.Message(err.Error()))...,
+	)
+}
+
+func timeoutError(options ...tiny_errors.ErrorOption) tiny_errors.ErrorHandler {
+	return tiny_errors.New(
+		custom_errors.ERR_CODE_Timeout,
+		append(options, tiny_errors.HTTPStatus(http.StatusGatewayTimeout))...,
+	)
+}
+
+func validateUUID(name, value string) tiny_errors.ErrorHandler {
+	errFields := utils.ValidateRequiredFields(
+		utils.NewRequiredField(name, value),
+	)
+	if errFields != nil {
+		return tiny_errors.New(custom_errors.ERR_CODE_REQUIRED_FIELD, errFields...)
+	}
+
+	if _, err := uuid.Parse(value); err != nil {
+		return tiny_errors.New(custom_errors.ERR_CODE_NotValid, tiny_errors.Detail(name, "must be a valid UUID"))
+	}
+
+	return nil
+}
+
+// Validates pagination params and returns limit with applied default value
+func validatePagination(limit, offset int) (int, tiny_errors.ErrorHandler) {
+	var details []tiny_errors.ErrorOption
+	if limit < 0 || limit > MAX_LIMIT {
+		details = append(details, tiny_errors.Detail("limit", fmt.Sprintf("must be between 0 and %d", MAX_LIMIT)))
+	}
+	if offset < 0 {
+		details = append(details, tiny_errors.Detail("offset", "must not be negative"))
+	}
+	if details != nil {
+		return 0, tiny_errors.New(custom_errors.ERR_CODE_NotValid, details...)
+	}
+
+	if limit == 0 {
+		return DEFAULT_LIMIT, nil
+	}
+	return limit, nil
+}
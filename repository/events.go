@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/Moranilt/http-utils/logger"
+	"github.com/Moranilt/http_template/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+const (
+	EVENT_Source  = "http_template"
+	EVENT_Version = 1
+)
+
+const (
+	EVENT_UserCreated   = "user.created"
+	EVENT_FilesUploaded = "files.uploaded"
+)
+
+// Wraps payload into models.Event with trace context of provided ctx
+func (repo *Repository) newEvent(ctx context.Context, eventType string, payload any) ([]byte, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+
+	requestId, _ := ctx.Value(logger.CtxRequestId).(string)
+
+	return json.Marshal(&models.Event{
+		ID:          repo.newID(),
+		Type:        eventType,
+		Version:     EVENT_Version,
+		OccurredAt:  repo.now().UTC(),
+		Source:      EVENT_Source,
+		RequestID:   requestId,
+		Traceparent: carrier.Get("traceparent"),
+		Tracestate:  carrier.Get("tracestate"),
+		Payload:     b,
+	})
+}
@@ -0,0 +1,418 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/Moranilt/http-utils/tiny_errors"
+	"github.com/Moranilt/http_template/custom_errors"
+	"github.com/Moranilt/http_template/models"
+	"github.com/Moranilt/http_template/outbox"
+	"github.com/Moranilt/http_template/utils"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	QUERY_InsertFile = "INSERT INTO files (id, name, storage_key, content_type, size, sha256) VALUES ($1, $2, $3, $4, $5, $6) RETURNING created_at"
+	QUERY_GetFile    = "SELECT id, name, storage_key, content_type, size, sha256, created_at FROM files WHERE id = $1"
+)
+
+// Number of bytes used to detect MIME type of file
+const SNIFF_LEN = 512
+
+const (
+	UPLOAD_MAX_FILE_SIZE  = 100 << 20
+	UPLOAD_MAX_TOTAL_SIZE = 500 << 20
+	UPLOAD_MAX_FIELD_SIZE = 1 << 10
+)
+
+// MIME types allowed for upload. Type ending with "/" allows the whole group.
+var UPLOAD_ALLOWED_TYPES = []string{
+	"image/",
+	"text/plain",
+	"application/pdf",
+	"application/zip",
+}
+
+// Limits applied to streaming upload. AllowedTypes is applied to both upload endpoints.
+type UploadLimits struct {
+	MaxFileSize  int64
+	MaxTotalSize int64
+	// Empty list allows every type
+	AllowedTypes []string
+}
+
+func (repo *Repository) Files(ctx context.Context, req *models.FileRequest) (*models.FileResponse, tiny_errors.ErrorHandler) {
+	repo.log.WithRequestId(ctx).InfoContext(ctx, TracerName, "data", req)
+	if req == nil {
+		return nil, tiny_errors.New(custom_errors.ERR_CODE_BodyRequired)
+	}
+	var fileNames []string
+	for _, f := range req.Files {
+		fileNames = append(fileNames, f.Filename)
+	}
+	var oneMoreFile string
+	if req.OneMoreFile != nil {
+		oneMoreFile = req.OneMoreFile.Filename
+	}
+	newCtx, span := otel.Tracer(TracerName).Start(ctx, "Files", trace.WithAttributes(
+		attribute.String("Name", req.Name),
+		attribute.StringSlice("FIles", fileNames),
+		attribute.String("OneMoreFile", oneMoreFile),
+	))
+	defer span.End()
+
+	headers := append([]*multipart.FileHeader{}, req.Files...)
+	fields := make([]string, 0, len(headers)+1)
+	for i := range req.Files {
+		fields = append(fields, fmt.Sprintf("file[%d]", i))
+	}
+	if req.OneMoreFile != nil {
+		headers = append(headers, req.OneMoreFile)
+		fields = append(fields, "one_more_file")
+	}
+
+	uploaded := make([]*models.File, 0, len(headers))
+	var details []tiny_errors.ErrorOption
+	for i, fh := range headers {
+		file, err := repo.storeMultipartFile(newCtx, fh, repo.uploadLimits.AllowedTypes)
+
+		var pErr *partError
+		switch {
+		case errors.As(err, &pErr):
+			details = append(details, tiny_errors.Detail(fields[i], pErr.reason))
+			continue
+		case err != nil:
+			repo.deleteFiles(ctx, uploaded)
+			storageErr := storageError(err, tiny_errors.Detail("file", fh.Filename))
+			span.RecordError(storageErr)
+			span.SetStatus(codes.Error, "storeFile")
+			return nil, storageErr
+		}
+		uploaded = append(uploaded, file)
+	}
+
+	if len(details) > 0 {
+		repo.deleteFiles(ctx, uploaded)
+		err := tiny_errors.New(custom_errors.ERR_CODE_NotValid, details...)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "validate files")
+		return nil, err
+	}
+
+	response := &models.FileResponse{
+		Name:        req.Name,
+		Files:       req.Files,
+		OneMoreFile: req.OneMoreFile,
+		Uploaded:    uploaded,
+	}
+
+	if err := repo.saveFiles(newCtx, response); err != nil {
+		repo.deleteFiles(ctx, uploaded)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "saveFiles")
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// Reads multipart body part by part and streams every file into storage.
+// Field "name" is used as response name, all other parts without file name are skipped.
+// Invalid parts are reported as ERR_CODE_NotValid details, nothing is saved in this case.
+func (repo *Repository) StreamFiles(ctx context.Context, req *models.StreamFilesRequest) (*models.FileResponse, tiny_errors.ErrorHandler) {
+	repo.log.WithRequestId(ctx).InfoContext(ctx, TracerName, "data", req)
+	if req == nil || req.Reader == nil {
+		return nil, tiny_errors.New(custom_errors.ERR_CODE_BodyRequired)
+	}
+	newCtx, span := otel.Tracer(TracerName).Start(ctx, "StreamFiles")
+	defer span.End()
+
+	limits := repo.uploadLimits
+	response := &models.FileResponse{}
+	var (
+		total   int64
+		index   int
+		details []tiny_errors.ErrorOption
+	)
+parts:
+	for {
+		part, err := req.Reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			repo.deleteFiles(ctx, response.Uploaded)
+			notValidErr := tiny_errors.New(custom_errors.ERR_CODE_NotValid, tiny_errors.Message(err.Error()))
+			span.RecordError(notValidErr)
+			span.SetStatus(codes.Error, "NextPart")
+			return nil, notValidErr
+		}
+
+		if part.FileName() == "" {
+			if part.FormName() == "name" {
+				b, _ := io.ReadAll(io.LimitReader(part, UPLOAD_MAX_FIELD_SIZE))
+				response.Name = string(b)
+			}
+			part.Close()
+			continue
+		}
+
+		// parts are reported by index, so parts with the same file name do not overwrite each other
+		key := fmt.Sprintf("file[%d]", index)
+		index++
+
+		limit := min(limits.MaxFileSize, limits.MaxTotalSize-total)
+		file, err := repo.storeFile(newCtx, part.FileName(), &limitedReader{r: part, n: limit}, -1, limits.AllowedTypes)
+		part.Close()
+
+		var pErr *partError
+		switch {
+		case errors.As(err, &pErr):
+			details = append(details, tiny_errors.Detail(key, pErr.reason))
+			continue
+		case errors.Is(err, errLimitExceeded):
+			if limit == limits.MaxFileSize {
+				details = append(details, tiny_errors.Detail(key, fmt.Sprintf("must not exceed %d bytes", limits.MaxFileSize)))
+				continue
+			}
+			details = append(details, tiny_errors.Detail("total", fmt.Sprintf("must not exceed %d bytes", limits.MaxTotalSize)))
+			break parts
+		case err != nil:
+			repo.deleteFiles(ctx, response.Uploaded)
+			storageErr := storageError(err, tiny_errors.Detail(key, part.FileName()))
+			span.RecordError(storageErr)
+			span.SetStatus(codes.Error, "storeFile")
+			return nil, storageErr
+		}
+
+		total += file.Size
+		response.Uploaded = append(response.Uploaded, file)
+	}
+
+	if len(details) > 0 {
+		repo.deleteFiles(ctx, response.Uploaded)
+		err := tiny_errors.New(custom_errors.ERR_CODE_NotValid, details...)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "validate parts")
+		return nil, err
+	}
+
+	errFields := utils.ValidateRequiredFields(
+		utils.NewRequiredField("file", response.Uploaded),
+	)
+	if errFields != nil {
+		err := tiny_errors.New(custom_errors.ERR_CODE_REQUIRED_FIELD, errFields...)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "ValidateRequiredFields")
+		return nil, err
+	}
+
+	if err := repo.saveFiles(newCtx, response); err != nil {
+		repo.deleteFiles(ctx, response.Uploaded)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "saveFiles")
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// Returns file metadata with content from storage
+func (repo *Repository) GetFile(ctx context.Context, req *models.GetFileRequest) (*models.FileContent, tiny_errors.ErrorHandler) {
+	repo.log.WithRequestId(ctx).InfoContext(ctx, TracerName, "data", req)
+	newCtx, span := otel.Tracer(TracerName).Start(ctx, "GetFile", trace.WithAttributes(
+		attribute.String("ID", req.ID),
+	))
+	defer span.End()
+
+	if err := validateUUID("id", req.ID); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "validateUUID")
+		return nil, err
+	}
+
+	var file models.File
+	err := repo.db.GetContext(newCtx, &file, QUERY_GetFile, req.ID)
+	if err != nil {
+		dbErr := databaseError(err, tiny_errors.Detail("id", req.ID))
+		span.RecordError(dbErr)
+		span.SetStatus(codes.Error, "GetContext")
+		return nil, dbErr
+	}
+
+	body, err := repo.storage.Get(newCtx, file.StorageKey)
+	if err != nil {
+		storageErr := storageError(err, tiny_errors.Detail("id", req.ID))
+		span.RecordError(storageErr)
+		span.SetStatus(codes.Error, "storage.Get")
+		return nil, storageErr
+	}
+
+	return &models.FileContent{
+		File: &file,
+		Body: body,
+	}, nil
+}
+
+func (repo *Repository) storeMultipartFile(ctx context.Context, fh *multipart.FileHeader, allowedTypes []string) (*models.File, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return repo.storeFile(ctx, fh.Filename, f, fh.Size, allowedTypes)
+}
+
+// Streams file into storage calculating its hash and size.
+// MIME type is detected from content only, Content-Type provided by client is ignored.
+// Returns *partError if detected type is not in allowedTypes.
+func (repo *Repository) storeFile(ctx context.Context, name string, r io.Reader, size int64, allowedTypes []string) (*models.File, error) {
+	head := make([]byte, SNIFF_LEN)
+	n, err := io.ReadFull(r, head)
+	// type is checked before size, so small file of not allowed type is reported the same way as large one
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, errLimitExceeded) {
+		return nil, err
+	}
+	head = head[:n]
+
+	detected := http.DetectContentType(head)
+	if !isAllowedType(detected, allowedTypes) {
+		return nil, &partError{reason: fmt.Sprintf("content type %q is not allowed", detected)}
+	}
+	if errors.Is(err, errLimitExceeded) {
+		return nil, err
+	}
+
+	hash := sha256.New()
+	counter := &countingWriter{}
+	body := io.TeeReader(io.MultiReader(bytes.NewReader(head), r), io.MultiWriter(hash, counter))
+
+	id := repo.newID()
+	if err := repo.storage.Put(ctx, id, body, size, detected); err != nil {
+		return nil, err
+	}
+
+	return &models.File{
+		ID:          id,
+		Name:        name,
+		StorageKey:  id,
+		ContentType: detected,
+		Size:        counter.n,
+		SHA256:      hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+// Stores metadata of uploaded files and event about it in one transaction
+func (repo *Repository) saveFiles(ctx context.Context, response *models.FileResponse) tiny_errors.ErrorHandler {
+	tx, err := repo.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return databaseError(err)
+	}
+	defer tx.Rollback()
+
+	for _, f := range response.Uploaded {
+		err := tx.QueryRowxContext(ctx, QUERY_InsertFile, f.ID, f.Name, f.StorageKey, f.ContentType, f.Size, f.SHA256).
+			Scan(&f.CreatedAt)
+		if err != nil {
+			return databaseError(err, tiny_errors.Detail("file", f.Name))
+		}
+	}
+
+	b, err := repo.newEvent(ctx, EVENT_FilesUploaded, response)
+	if err != nil {
+		return tiny_errors.New(custom_errors.ERR_CODE_Marshal, tiny_errors.Message(err.Error()))
+	}
+
+	err = outbox.Enqueue(ctx, tx, EVENT_FilesUploaded, b)
+	if err != nil {
+		return databaseError(err, tiny_errors.Detail("event", EVENT_FilesUploaded))
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return databaseError(err)
+	}
+	return nil
+}
+
+// Removes already stored files when request failed. Errors are only logged.
+func (repo *Repository) deleteFiles(ctx context.Context, files []*models.File) {
+	for _, f := range files {
+		if err := repo.storage.Delete(context.WithoutCancel(ctx), f.StorageKey); err != nil {
+			repo.log.WithRequestId(ctx).Error("storage delete", "key", f.StorageKey, "error", err)
+		}
+	}
+}
+
+var errLimitExceeded = errors.New("limit exceeded")
+
+// Fails with errLimitExceeded as soon as more than n bytes are read,
+// so storage aborts writing of file which exceeds limit instead of storing it.
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, errLimitExceeded
+	}
+	// read one more byte than allowed to find out that limit is exceeded
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, errLimitExceeded
+	}
+	return n, err
+}
+
+// Error of single uploaded part. It is returned to client as ERR_CODE_NotValid detail.
+type partError struct {
+	reason string
+}
+
+func (e *partError) Error() string {
+	return e.reason
+}
+
+func isAllowedType(contentType string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range allowed {
+		if mediaType == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
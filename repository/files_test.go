@@ -1,72 +1,441 @@
 package repository
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"io"
 	"mime/multipart"
 	"net/textproto"
+	"regexp"
+	"strings"
 	"testing"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/Moranilt/http_template/custom_errors"
 	"github.com/Moranilt/http_template/models"
+	"github.com/Moranilt/http_template/outbox"
+	"github.com/Moranilt/http_template/storage"
 	"github.com/stretchr/testify/assert"
 )
 
-func TestFiles(t *testing.T) {
-	// Mock repository dependencies
-	mockedRepo := mockRepository(t)
-	mockedFiles := []*multipart.FileHeader{
-		{
-			Filename: "file.txt",
-			Header: textproto.MIMEHeader{
-				"Content-Type": {"text/plain"},
-			},
-			Size: 123,
-		},
+var fileColumns = []string{"id", "name", "storage_key", "content_type", "size", "sha256", "created_at"}
+
+// Parses multipart form with provided files and returns its headers by field name
+func multipartFiles(t *testing.T, files map[string]string) map[string][]*multipart.FileHeader {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for name, content := range files {
+		field, filename, _ := strings.Cut(name, "/")
+		fw, err := w.CreateFormFile(field, filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(content))
 	}
-	mockedFile := &multipart.FileHeader{
-		Filename: "test.txt",
-		Header: textproto.MIMEHeader{
-			"Content-Type": {"text/plain"},
-		},
-		Size: 123,
+	w.Close()
+
+	form, err := multipart.NewReader(&body, w.Boundary()).ReadForm(32 << 20)
+	if err != nil {
+		t.Fatal(err)
 	}
+	t.Cleanup(func() { form.RemoveAll() })
+	return form.File
+}
+
+func sequenceIDs(ids ...string) func() string {
+	i := 0
+	return func() string {
+		if i >= len(ids) {
+			return eventID
+		}
+		i++
+		return ids[i-1]
+	}
+}
+
+func sha256Hex(content string) string {
+	hash := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(hash[:])
+}
+
+func TestFiles(t *testing.T) {
+	fileID := "5b1c2e9a-6a43-4b8e-8d0f-2f0c4f7e1a01"
+	oneMoreFileID := "5b1c2e9a-6a43-4b8e-8d0f-2f0c4f7e1a02"
+	headers := multipartFiles(t, map[string]string{
+		"file/file.txt":          "hello",
+		"one_more_file/test.png": "\x89PNG\r\n\x1a\n",
+	})
 	mockedRequest := &models.FileRequest{
 		Name:        "Test",
-		Files:       mockedFiles,
-		OneMoreFile: mockedFile,
+		Files:       headers["file"],
+		OneMoreFile: headers["one_more_file"][0],
 	}
+
 	t.Run("Success", func(t *testing.T) {
-		b, _ := json.Marshal(mockedRequest)
-		mockedRepo.rabbitmqMock.ExpectPush(b, nil)
+		mockedRepo := mockRepository(t)
+		mockedRepo.repo.newID = sequenceIDs(fileID, oneMoreFileID)
 
-		response, err := mockedRepo.repo.Files(context.Background(), &models.FileRequest{
+		expectedResponse := &models.FileResponse{
 			Name:        "Test",
-			Files:       mockedFiles,
-			OneMoreFile: mockedFile,
-		})
+			Files:       mockedRequest.Files,
+			OneMoreFile: mockedRequest.OneMoreFile,
+			Uploaded: []*models.File{
+				{
+					ID:          fileID,
+					Name:        "file.txt",
+					StorageKey:  fileID,
+					ContentType: "text/plain; charset=utf-8",
+					Size:        5,
+					SHA256:      sha256Hex("hello"),
+					CreatedAt:   createdAt,
+				},
+				{
+					ID:          oneMoreFileID,
+					Name:        "test.png",
+					StorageKey:  oneMoreFileID,
+					ContentType: "image/png",
+					Size:        8,
+					SHA256:      sha256Hex("\x89PNG\r\n\x1a\n"),
+					CreatedAt:   createdAt,
+				},
+			},
+		}
+
+		mockedRepo.sqlMock.ExpectBegin()
+		for _, f := range expectedResponse.Uploaded {
+			mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_InsertFile)).
+				WithArgs(f.ID, f.Name, f.StorageKey, f.ContentType, f.Size, f.SHA256).
+				WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(createdAt))
+		}
+		mockedRepo.sqlMock.ExpectExec(regexp.QuoteMeta(outbox.QUERY_Insert)).
+			WithArgs(EVENT_FilesUploaded, expectedEvent(EVENT_FilesUploaded, expectedResponse), outbox.ENCODING_JSON, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mockedRepo.sqlMock.ExpectCommit()
+
+		response, err := mockedRepo.repo.Files(context.Background(), mockedRequest)
 		if err != nil {
-			t.Errorf("Unexpected error: %v", err)
+			t.Fatal(err)
 		}
-		assert.Equal(t, "Test", response.Name)
-		assert.Equal(t, mockedFiles, response.Files)
-		assert.Equal(t, mockedFile, response.OneMoreFile)
+		assert.Equal(t, expectedResponse, response)
+
+		r, storageErr := mockedRepo.storage.Get(context.Background(), fileID)
+		if assert.NoError(t, storageErr) {
+			defer r.Close()
+			b, _ := io.ReadAll(r)
+			assert.Equal(t, "hello", string(b))
+		}
+		assert.NoError(t, mockedRepo.sqlMock.ExpectationsWereMet())
 	})
 
 	t.Run("empty request", func(t *testing.T) {
+		mockedRepo := mockRepository(t)
 		response, err := mockedRepo.repo.Files(context.Background(), nil)
 		assert.Equal(t, custom_errors.ERR_CODE_BodyRequired, err.GetCode())
 		assert.Nil(t, response)
 	})
 
-	t.Run("rabbitmq error", func(t *testing.T) {
-		expectedError := errors.New("rabbitmq error")
-		b, _ := json.Marshal(mockedRequest)
-		mockedRepo.rabbitmqMock.ExpectPush(b, expectedError)
+	t.Run("Not allowed type", func(t *testing.T) {
+		mockedRepo := mockRepository(t)
+		mockedRepo.repo.newID = sequenceIDs(fileID, oneMoreFileID)
+		headers := multipartFiles(t, map[string]string{
+			"file/file.txt":           "hello",
+			"one_more_file/page.html": "<html><body></body></html>",
+		})
+
+		response, err := mockedRepo.repo.Files(context.Background(), &models.FileRequest{
+			Name:        "Test",
+			Files:       headers["file"],
+			OneMoreFile: headers["one_more_file"][0],
+		})
+		assert.Nil(t, response)
+		if assert.NotNil(t, err) {
+			assert.Equal(t, custom_errors.ERR_CODE_NotValid, err.GetCode())
+			assert.Equal(t, map[string]any{
+				"one_more_file": `content type "text/html; charset=utf-8" is not allowed`,
+			}, err.GetDetails())
+		}
+
+		for _, id := range []string{fileID, oneMoreFileID} {
+			_, storageErr := mockedRepo.storage.Get(context.Background(), id)
+			assert.ErrorIs(t, storageErr, storage.ErrNotFound)
+		}
+	})
+
+	t.Run("database error removes stored files", func(t *testing.T) {
+		mockedRepo := mockRepository(t)
+		mockedRepo.repo.newID = sequenceIDs(fileID, oneMoreFileID)
+		expectedError := errors.New("database error")
+
+		mockedRepo.sqlMock.ExpectBegin()
+		mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_InsertFile)).
+			WillReturnError(expectedError)
+		mockedRepo.sqlMock.ExpectRollback()
 
 		response, err := mockedRepo.repo.Files(context.Background(), mockedRequest)
-		assert.Equal(t, expectedError.Error(), err.Error())
 		assert.Nil(t, response)
+		assert.Equal(t, custom_errors.ERR_CODE_Database, err.GetCode())
+
+		for _, id := range []string{fileID, oneMoreFileID} {
+			_, storageErr := mockedRepo.storage.Get(context.Background(), id)
+			assert.ErrorIs(t, storageErr, storage.ErrNotFound)
+		}
+		assert.NoError(t, mockedRepo.sqlMock.ExpectationsWereMet())
+	})
+}
+
+func TestGetFile(t *testing.T) {
+	mockedRepo := mockRepository(t)
+	expectedID := "5b1c2e9a-6a43-4b8e-8d0f-2f0c4f7e1a01"
+
+	t.Run("Success", func(t *testing.T) {
+		err := mockedRepo.storage.Put(context.Background(), expectedID, strings.NewReader("hello"), 5, "text/plain")
+		if err != nil {
+			t.Fatal(err)
+		}
+		mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_GetFile)).
+			WithArgs(expectedID).
+			WillReturnRows(sqlmock.NewRows(fileColumns).
+				AddRow(expectedID, "file.txt", expectedID, "text/plain", 5, sha256Hex("hello"), createdAt))
+
+		file, fileErr := mockedRepo.repo.GetFile(context.Background(), &models.GetFileRequest{ID: expectedID})
+		if fileErr != nil {
+			t.Fatal(fileErr)
+		}
+		defer file.Body.Close()
+
+		b, _ := io.ReadAll(file.Body)
+		assert.Equal(t, "hello", string(b))
+		assert.Equal(t, "file.txt", file.Name)
+		assert.Equal(t, int64(5), file.Size)
+	})
+
+	t.Run("Not found", func(t *testing.T) {
+		mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_GetFile)).
+			WithArgs(expectedID).
+			WillReturnError(sql.ErrNoRows)
+
+		file, err := mockedRepo.repo.GetFile(context.Background(), &models.GetFileRequest{ID: expectedID})
+		assert.Nil(t, file)
+		assert.Equal(t, custom_errors.ERR_CODE_NotFound, err.GetCode())
+	})
+
+	t.Run("Content not found", func(t *testing.T) {
+		missingID := "5b1c2e9a-6a43-4b8e-8d0f-2f0c4f7e1a09"
+		mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_GetFile)).
+			WithArgs(missingID).
+			WillReturnRows(sqlmock.NewRows(fileColumns).
+				AddRow(missingID, "file.txt", missingID, "text/plain", 5, sha256Hex("hello"), createdAt))
+
+		file, err := mockedRepo.repo.GetFile(context.Background(), &models.GetFileRequest{ID: missingID})
+		assert.Nil(t, file)
+		assert.Equal(t, custom_errors.ERR_CODE_NotFound, err.GetCode())
+	})
+
+	t.Run("Not valid id", func(t *testing.T) {
+		file, err := mockedRepo.repo.GetFile(context.Background(), &models.GetFileRequest{ID: "1"})
+		assert.Nil(t, file)
+		assert.Equal(t, custom_errors.ERR_CODE_NotValid, err.GetCode())
+	})
+
+	assert.NoError(t, mockedRepo.sqlMock.ExpectationsWereMet())
+}
+
+// Records keys of objects which were stored successfully
+type recordingStorage struct {
+	storage.Storage
+	puts []string
+}
+
+func (s *recordingStorage) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	if err := s.Storage.Put(ctx, key, body, size, contentType); err != nil {
+		return err
+	}
+	s.puts = append(s.puts, key)
+	return nil
+}
+
+type testPart struct {
+	field       string
+	filename    string
+	contentType string
+	content     string
+}
+
+func multipartReader(t *testing.T, parts ...testPart) *multipart.Reader {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, p := range parts {
+		var (
+			pw  io.Writer
+			err error
+		)
+		switch {
+		case p.filename == "":
+			pw, err = w.CreateFormField(p.field)
+		case p.contentType != "":
+			h := make(textproto.MIMEHeader)
+			h.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, p.field, p.filename))
+			h.Set("Content-Type", p.contentType)
+			pw, err = w.CreatePart(h)
+		default:
+			pw, err = w.CreateFormFile(p.field, p.filename)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		pw.Write([]byte(p.content))
+	}
+	w.Close()
+	return multipart.NewReader(&body, w.Boundary())
+}
+
+func TestStreamFiles(t *testing.T) {
+	fileID := "5b1c2e9a-6a43-4b8e-8d0f-2f0c4f7e1a01"
+	secondFileID := "5b1c2e9a-6a43-4b8e-8d0f-2f0c4f7e1a02"
+
+	t.Run("Success", func(t *testing.T) {
+		mockedRepo := mockRepository(t)
+		mockedRepo.repo.newID = sequenceIDs(fileID)
+
+		expectedFile := &models.File{
+			ID:          fileID,
+			Name:        "file.txt",
+			StorageKey:  fileID,
+			ContentType: "text/plain; charset=utf-8",
+			Size:        5,
+			SHA256:      sha256Hex("hello"),
+			CreatedAt:   createdAt,
+		}
+		expectedResponse := &models.FileResponse{
+			Name:     "Test",
+			Uploaded: []*models.File{expectedFile},
+		}
+
+		mockedRepo.sqlMock.ExpectBegin()
+		mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_InsertFile)).
+			WithArgs(fileID, "file.txt", fileID, "text/plain; charset=utf-8", int64(5), sha256Hex("hello")).
+			WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(createdAt))
+		mockedRepo.sqlMock.ExpectExec(regexp.QuoteMeta(outbox.QUERY_Insert)).
+			WithArgs(EVENT_FilesUploaded, expectedEvent(EVENT_FilesUploaded, expectedResponse), outbox.ENCODING_JSON, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mockedRepo.sqlMock.ExpectCommit()
+
+		response, err := mockedRepo.repo.StreamFiles(context.Background(), &models.StreamFilesRequest{
+			Reader: multipartReader(t,
+				testPart{field: "name", content: "Test"},
+				testPart{field: "file", filename: "file.txt", content: "hello"},
+			),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, expectedResponse, response)
+		assert.NoError(t, mockedRepo.sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("Not valid parts", func(t *testing.T) {
+		mockedRepo := mockRepository(t)
+		mockedRepo.repo.newID = sequenceIDs(fileID, secondFileID)
+		mockedRepo.repo.uploadLimits.MaxFileSize = 10
+
+		response, err := mockedRepo.repo.StreamFiles(context.Background(), &models.StreamFilesRequest{
+			Reader: multipartReader(t,
+				testPart{field: "file", filename: "file.txt", content: "hello"},
+				testPart{field: "file", filename: "page.html", content: "<html><body></body></html>"},
+				testPart{field: "file", filename: "large.txt", content: "hello world!"},
+			),
+		})
+		assert.Nil(t, response)
+		if assert.NotNil(t, err) {
+			assert.Equal(t, custom_errors.ERR_CODE_NotValid, err.GetCode())
+			assert.Equal(t, map[string]any{
+				"file[1]": `content type "text/html; charset=utf-8" is not allowed`,
+				"file[2]": "must not exceed 10 bytes",
+			}, err.GetDetails())
+		}
+
+		for _, id := range []string{fileID, secondFileID} {
+			_, storageErr := mockedRepo.storage.Get(context.Background(), id)
+			assert.ErrorIs(t, storageErr, storage.ErrNotFound)
+		}
+	})
+
+	t.Run("Client content type is not trusted", func(t *testing.T) {
+		mockedRepo := mockRepository(t)
+		mockedRepo.repo.newID = sequenceIDs(fileID)
+
+		response, err := mockedRepo.repo.StreamFiles(context.Background(), &models.StreamFilesRequest{
+			Reader: multipartReader(t,
+				testPart{field: "file", filename: "image.png", contentType: "image/png", content: "\x00\x01\x02\x03binary"},
+			),
+		})
+		assert.Nil(t, response)
+		if assert.NotNil(t, err) {
+			assert.Equal(t, custom_errors.ERR_CODE_NotValid, err.GetCode())
+			assert.Equal(t, map[string]any{
+				"file[0]": `content type "application/octet-stream" is not allowed`,
+			}, err.GetDetails())
+		}
+
+		_, storageErr := mockedRepo.storage.Get(context.Background(), fileID)
+		assert.ErrorIs(t, storageErr, storage.ErrNotFound)
+	})
+
+	t.Run("Oversized part is not stored", func(t *testing.T) {
+		mockedRepo := mockRepository(t)
+		recording := &recordingStorage{Storage: mockedRepo.storage}
+		mockedRepo.repo.storage = recording
+		mockedRepo.repo.newID = sequenceIDs(fileID, secondFileID)
+		mockedRepo.repo.uploadLimits.MaxFileSize = 10
+
+		response, err := mockedRepo.repo.StreamFiles(context.Background(), &models.StreamFilesRequest{
+			Reader: multipartReader(t,
+				testPart{field: "file", filename: "file.txt", content: "hello world!"},
+				testPart{field: "file", filename: "file.txt", content: strings.Repeat("a", 1024)},
+			),
+		})
+		assert.Nil(t, response)
+		if assert.NotNil(t, err) {
+			assert.Equal(t, custom_errors.ERR_CODE_NotValid, err.GetCode())
+			assert.Equal(t, map[string]any{
+				"file[0]": "must not exceed 10 bytes",
+				"file[1]": "must not exceed 10 bytes",
+			}, err.GetDetails())
+		}
+		assert.Empty(t, recording.puts)
+	})
+
+	t.Run("Total size exceeded", func(t *testing.T) {
+		mockedRepo := mockRepository(t)
+		mockedRepo.repo.newID = sequenceIDs(fileID, secondFileID)
+		mockedRepo.repo.uploadLimits.MaxTotalSize = 8
+
+		response, err := mockedRepo.repo.StreamFiles(context.Background(), &models.StreamFilesRequest{
+			Reader: multipartReader(t,
+				testPart{field: "file", filename: "file.txt", content: "hello"},
+				testPart{field: "file", filename: "second.txt", content: "hello"},
+				testPart{field: "file", filename: "third.txt", content: "hello"},
+			),
+		})
+		assert.Nil(t, response)
+		if assert.NotNil(t, err) {
+			assert.Equal(t, custom_errors.ERR_CODE_NotValid, err.GetCode())
+			assert.Equal(t, map[string]any{"total": "must not exceed 8 bytes"}, err.GetDetails())
+		}
+	})
+
+	t.Run("No files", func(t *testing.T) {
+		mockedRepo := mockRepository(t)
+
+		response, err := mockedRepo.repo.StreamFiles(context.Background(), &models.StreamFilesRequest{
+			Reader: multipartReader(t, testPart{field: "name", content: "Test"}),
+		})
+		assert.Nil(t, response)
+		assert.Equal(t, custom_errors.ERR_CODE_REQUIRED_FIELD, err.GetCode())
 	})
 }
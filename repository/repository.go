@@ -2,17 +2,18 @@ package repository
 
 import (
 	"context"
-	"encoding/json"
 	"time"
 
 	"github.com/Moranilt/http-utils/clients/database"
-	"github.com/Moranilt/http-utils/clients/rabbitmq"
-	"github.com/Moranilt/http-utils/clients/redis"
 	"github.com/Moranilt/http-utils/logger"
 	"github.com/Moranilt/http-utils/tiny_errors"
+	"github.com/Moranilt/http_template/cache"
 	"github.com/Moranilt/http_template/custom_errors"
 	"github.com/Moranilt/http_template/models"
+	"github.com/Moranilt/http_template/outbox"
+	"github.com/Moranilt/http_template/storage"
 	"github.com/Moranilt/http_template/utils"
+	"github.com/google/uuid"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
@@ -21,7 +22,7 @@ import (
 )
 
 const (
-	QUERY_InsertUser = "INSERT INTO test (firstname, lastname, patronymic) VALUES ($1, $2, $3) RETURNING id"
+	QUERY_InsertUser = "INSERT INTO test (firstname, lastname, patronymic) VALUES ($1, $2, $3) RETURNING id, created_at"
 )
 
 const (
@@ -31,18 +32,32 @@ const (
 const TracerName string = "repository"
 
 type Repository struct {
-	db       *database.Client
-	rabbitmq rabbitmq.RabbitMQClient
-	redis    *redis.Client
-	log      logger.Logger
+	db      *database.Client
+	cache   cache.Cache
+	storage storage.Storage
+	log     logger.Logger
+
+	uploadLimits UploadLimits
+
+	// replaceable in tests to make events predictable
+	now   func() time.Time
+	newID func() string
 }
 
-func New(db *database.Client, rabbitmq rabbitmq.RabbitMQClient, redis *redis.Client, logger logger.Logger) *Repository {
+// Events are published through outbox, so repository does not depend on RabbitMQ
+func New(db *database.Client, cache cache.Cache, storage storage.Storage, logger logger.Logger) *Repository {
 	return &Repository{
-		db:       db,
-		rabbitmq: rabbitmq,
-		redis:    redis,
-		log:      logger,
+		db:      db,
+		cache:   cache,
+		storage: storage,
+		log:     logger,
+		uploadLimits: UploadLimits{
+			MaxFileSize:  UPLOAD_MAX_FILE_SIZE,
+			MaxTotalSize: UPLOAD_MAX_TOTAL_SIZE,
+			AllowedTypes: UPLOAD_ALLOWED_TYPES,
+		},
+		now:   time.Now,
+		newID: uuid.NewString,
 	}
 }
 
@@ -70,77 +85,64 @@ func (repo *Repository) GetRandomNumber(ctx context.Context, req *models.GetRand
 
 func (repo *Repository) CreateUser(ctx context.Context, req *models.TestRequest) (*models.TestResponse, tiny_errors.ErrorHandler) {
 	repo.log.WithRequestId(ctx).InfoContext(ctx, TracerName, "data", req)
+	// request is nil when body is JSON null
+	if req == nil {
+		return nil, tiny_errors.New(custom_errors.ERR_CODE_BodyRequired)
+	}
+	var patronymic string
+	if req.Patronymic != nil {
+		patronymic = *req.Patronymic
+	}
 	newCtx, span := otel.Tracer(TracerName).Start(ctx, "Test", trace.WithAttributes(
 		attribute.String("Firstname", req.Firstname),
 		attribute.String("Lastname", req.Lastname),
-		attribute.String("Patronymic", *req.Patronymic),
+		attribute.String("Patronymic", patronymic),
 	))
 	defer span.End()
 
-	row := repo.db.QueryRowxContext(newCtx, QUERY_InsertUser, req.Firstname, req.Lastname, *req.Patronymic)
-	if row.Err() != nil {
-		return nil, tiny_errors.New(custom_errors.ERR_CODE_Database, tiny_errors.Message(row.Err().Error()))
-	}
-
-	var lastInsertId string
-	err := row.Scan(&lastInsertId)
+	tx, err := repo.db.BeginTxx(newCtx, nil)
 	if err != nil {
-		return nil, tiny_errors.New(custom_errors.ERR_CODE_Database, tiny_errors.Message(row.Err().Error()))
+		return nil, databaseError(err)
 	}
+	defer tx.Rollback()
 
-	b, err := json.Marshal(req)
-	if err != nil {
-		return nil, tiny_errors.New(custom_errors.ERR_CODE_Marshal, tiny_errors.Message(err.Error()))
+	row := tx.QueryRowxContext(newCtx, QUERY_InsertUser, req.Firstname, req.Lastname, req.Patronymic)
+	if row.Err() != nil {
+		return nil, databaseError(row.Err())
 	}
 
-	err = repo.redis.Set(newCtx, lastInsertId, b, REDIS_TTL).Err()
+	user := &models.User{
+		Firstname:  req.Firstname,
+		Lastname:   req.Lastname,
+		Patronymic: req.Patronymic,
+	}
+	err = row.Scan(&user.ID, &user.CreatedAt)
 	if err != nil {
-		return nil, tiny_errors.New(custom_errors.ERR_CODE_Redis, tiny_errors.Message(err.Error()))
+		return nil, databaseError(err)
 	}
 
-	err = repo.rabbitmq.Push(newCtx, b)
+	b, err := repo.newEvent(newCtx, EVENT_UserCreated, user)
 	if err != nil {
-		return nil, tiny_errors.New(custom_errors.ERR_CODE_RabbitMQ, tiny_errors.Message(err.Error()))
+		return nil, tiny_errors.New(custom_errors.ERR_CODE_Marshal, tiny_errors.Message(err.Error()))
 	}
 
-	return &models.TestResponse{
-		ID: lastInsertId,
-	}, nil
-}
-
-func (repo *Repository) Files(ctx context.Context, req *models.FileRequest) (*models.FileResponse, tiny_errors.ErrorHandler) {
-	repo.log.WithRequestId(ctx).InfoContext(ctx, TracerName, "data", req)
-	if req == nil {
-		return nil, tiny_errors.New(custom_errors.ERR_CODE_BodyRequired)
-	}
-	var fileNames []string
-	for _, f := range req.Files {
-		fileNames = append(fileNames, f.Filename)
+	err = outbox.Enqueue(newCtx, tx, EVENT_UserCreated, b)
+	if err != nil {
+		return nil, databaseError(err, tiny_errors.Detail("event", EVENT_UserCreated))
 	}
-	newCtx, span := otel.Tracer(TracerName).Start(ctx, "Files", trace.WithAttributes(
-		attribute.String("Name", req.Name),
-		attribute.StringSlice("FIles", fileNames),
-		attribute.String("OneMoreFile", req.OneMoreFile.Filename),
-	))
-	defer span.End()
 
-	b, err := json.Marshal(req)
+	err = tx.Commit()
 	if err != nil {
-		return nil, tiny_errors.New(custom_errors.ERR_CODE_Marshal, tiny_errors.Message(err.Error()))
+		return nil, databaseError(err)
 	}
 
-	err = repo.rabbitmq.Push(newCtx, b)
+	// user is already stored, so cache errors should not fail the request
+	err = repo.cacheUser(newCtx, user)
 	if err != nil {
-		return nil, tiny_errors.New(
-			custom_errors.ERR_CODE_RabbitMQ,
-			tiny_errors.Message(err.Error()),
-			tiny_errors.Detail("event", "Push"),
-		)
+		repo.log.WithRequestId(ctx).Error("cache set", "key", userCacheKey(user.ID), "error", err)
 	}
 
-	return &models.FileResponse{
-		Name:        req.Name,
-		Files:       req.Files,
-		OneMoreFile: req.OneMoreFile,
+	return &models.TestResponse{
+		ID: user.ID,
 	}, nil
 }
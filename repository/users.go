@@ -0,0 +1,219 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/Moranilt/http-utils/tiny_errors"
+	"github.com/Moranilt/http_template/custom_errors"
+	"github.com/Moranilt/http_template/models"
+	"github.com/Moranilt/http_template/utils"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	QUERY_GetUser     = "SELECT id, firstname, lastname, patronymic, created_at FROM test WHERE id = $1"
+	QUERY_ListUsers   = "SELECT id, firstname, lastname, patronymic, created_at FROM test ORDER BY created_at DESC, id LIMIT $1 OFFSET $2"
+	QUERY_ReplaceUser = "UPDATE test SET firstname = $2, lastname = $3, patronymic = $4 WHERE id = $1 RETURNING id, firstname, lastname, patronymic, created_at"
+	// Column is changed only when its flag is true, so absent field is told apart from null
+	QUERY_UpdateUser = "UPDATE test SET firstname = CASE WHEN $2 THEN $3 ELSE firstname END, lastname = CASE WHEN $4 THEN $5 ELSE lastname END, " +
+		"patronymic = CASE WHEN $6 THEN $7 ELSE patronymic END WHERE id = $1 RETURNING id, firstname, lastname, patronymic, created_at"
+	QUERY_DeleteUser = "DELETE FROM test WHERE id = $1 RETURNING id"
+)
+
+func (repo *Repository) GetUser(ctx context.Context, req *models.GetUserRequest) (*models.User, tiny_errors.ErrorHandler) {
+	repo.log.WithRequestId(ctx).InfoContext(ctx, TracerName, "data", req)
+	newCtx, span := otel.Tracer(TracerName).Start(ctx, "GetUser", trace.WithAttributes(
+		attribute.String("ID", req.ID),
+	))
+	defer span.End()
+
+	if err := validateUUID("id", req.ID); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "validateUUID")
+		return nil, err
+	}
+
+	if user, ok := repo.getCachedUser(newCtx, req.ID); ok {
+		span.SetAttributes(attribute.Bool("cache.hit", true))
+		return user, nil
+	}
+
+	var user models.User
+	err := repo.db.GetContext(newCtx, &user, QUERY_GetUser, req.ID)
+	if err != nil {
+		dbErr := databaseError(err, tiny_errors.Detail("id", req.ID))
+		span.RecordError(dbErr)
+		span.SetStatus(codes.Error, "GetContext")
+		return nil, dbErr
+	}
+
+	if err := repo.cacheUser(newCtx, &user); err != nil {
+		repo.log.WithRequestId(ctx).Error("cache set", "key", userCacheKey(user.ID), "error", err)
+	}
+
+	return &user, nil
+}
+
+func (repo *Repository) ListUsers(ctx context.Context, req *models.ListUsersRequest) (*models.ListUsersResponse, tiny_errors.ErrorHandler) {
+	// request is nil when query string is empty
+	if req == nil {
+		req = &models.ListUsersRequest{}
+	}
+	repo.log.WithRequestId(ctx).InfoContext(ctx, TracerName, "data", req)
+	newCtx, span := otel.Tracer(TracerName).Start(ctx, "ListUsers", trace.WithAttributes(
+		attribute.Int("Limit", req.Limit),
+		attribute.Int("Offset", req.Offset),
+	))
+	defer span.End()
+
+	limit, err := validatePagination(req.Limit, req.Offset)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "validatePagination")
+		return nil, err
+	}
+
+	users := make([]*models.User, 0, limit)
+	if err := repo.db.SelectContext(newCtx, &users, QUERY_ListUsers, limit, req.Offset); err != nil {
+		dbErr := databaseError(err)
+		span.RecordError(dbErr)
+		span.SetStatus(codes.Error, "SelectContext")
+		return nil, dbErr
+	}
+
+	return &models.ListUsersResponse{
+		Users:  users,
+		Limit:  limit,
+		Offset: req.Offset,
+	}, nil
+}
+
+func (repo *Repository) ReplaceUser(ctx context.Context, req *models.ReplaceUserRequest) (*models.User, tiny_errors.ErrorHandler) {
+	repo.log.WithRequestId(ctx).InfoContext(ctx, TracerName, "data", req)
+	// request is nil when body is JSON null
+	if req == nil {
+		return nil, tiny_errors.New(custom_errors.ERR_CODE_BodyRequired)
+	}
+	newCtx, span := otel.Tracer(TracerName).Start(ctx, "ReplaceUser", trace.WithAttributes(
+		attribute.String("ID", req.ID),
+	))
+	defer span.End()
+
+	if err := validateUUID("id", req.ID); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "validateUUID")
+		return nil, err
+	}
+
+	errFields := utils.ValidateRequiredFields(
+		utils.NewRequiredField("firstname", req.Firstname),
+		utils.NewRequiredField("lastname", req.Lastname),
+	)
+	if errFields != nil {
+		err := tiny_errors.New(custom_errors.ERR_CODE_REQUIRED_FIELD, errFields...)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "ValidateRequiredFields")
+		return nil, err
+	}
+
+	var user models.User
+	err := repo.db.GetContext(newCtx, &user, QUERY_ReplaceUser, req.ID, req.Firstname, req.Lastname, req.Patronymic)
+	if err != nil {
+		dbErr := databaseError(err, tiny_errors.Detail("id", req.ID))
+		span.RecordError(dbErr)
+		span.SetStatus(codes.Error, "GetContext")
+		return nil, dbErr
+	}
+
+	repo.invalidateUser(newCtx, user.ID)
+
+	return &user, nil
+}
+
+func (repo *Repository) UpdateUser(ctx context.Context, req *models.UpdateUserRequest) (*models.User, tiny_errors.ErrorHandler) {
+	repo.log.WithRequestId(ctx).InfoContext(ctx, TracerName, "data", req)
+	// request is nil when body is JSON null
+	if req == nil {
+		return nil, tiny_errors.New(custom_errors.ERR_CODE_BodyRequired)
+	}
+	newCtx, span := otel.Tracer(TracerName).Start(ctx, "UpdateUser", trace.WithAttributes(
+		attribute.String("ID", req.ID),
+	))
+	defer span.End()
+
+	if err := validateUUID("id", req.ID); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "validateUUID")
+		return nil, err
+	}
+
+	if !req.Firstname.Set && !req.Lastname.Set && !req.Patronymic.Set {
+		err := tiny_errors.New(custom_errors.ERR_CODE_BodyRequired)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "validate")
+		return nil, err
+	}
+
+	var details []tiny_errors.ErrorOption
+	if req.Firstname.Set && req.Firstname.Value == nil {
+		details = append(details, tiny_errors.Detail("firstname", "must not be null"))
+	}
+	if req.Lastname.Set && req.Lastname.Value == nil {
+		details = append(details, tiny_errors.Detail("lastname", "must not be null"))
+	}
+	if details != nil {
+		err := tiny_errors.New(custom_errors.ERR_CODE_NotValid, details...)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "validate")
+		return nil, err
+	}
+
+	var user models.User
+	err := repo.db.GetContext(newCtx, &user, QUERY_UpdateUser, req.ID,
+		req.Firstname.Set, req.Firstname.Value,
+		req.Lastname.Set, req.Lastname.Value,
+		req.Patronymic.Set, req.Patronymic.Value,
+	)
+	if err != nil {
+		dbErr := databaseError(err, tiny_errors.Detail("id", req.ID))
+		span.RecordError(dbErr)
+		span.SetStatus(codes.Error, "GetContext")
+		return nil, dbErr
+	}
+
+	repo.invalidateUser(newCtx, user.ID)
+
+	return &user, nil
+}
+
+func (repo *Repository) DeleteUser(ctx context.Context, req *models.DeleteUserRequest) (*models.DeleteUserResponse, tiny_errors.ErrorHandler) {
+	repo.log.WithRequestId(ctx).InfoContext(ctx, TracerName, "data", req)
+	newCtx, span := otel.Tracer(TracerName).Start(ctx, "DeleteUser", trace.WithAttributes(
+		attribute.String("ID", req.ID),
+	))
+	defer span.End()
+
+	if err := validateUUID("id", req.ID); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "validateUUID")
+		return nil, err
+	}
+
+	var deletedId string
+	err := repo.db.GetContext(newCtx, &deletedId, QUERY_DeleteUser, req.ID)
+	if err != nil {
+		dbErr := databaseError(err, tiny_errors.Detail("id", req.ID))
+		span.RecordError(dbErr)
+		span.SetStatus(codes.Error, "GetContext")
+		return nil, dbErr
+	}
+
+	repo.invalidateUser(newCtx, deletedId)
+
+	return &models.DeleteUserResponse{
+		ID: deletedId,
+	}, nil
+}
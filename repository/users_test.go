@@ -0,0 +1,335 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Moranilt/http_template/custom_errors"
+	"github.com/Moranilt/http_template/models"
+	"github.com/stretchr/testify/assert"
+)
+
+var userColumns = []string{"id", "firstname", "lastname", "patronymic", "created_at"}
+
+func TestGetUser(t *testing.T) {
+	mockedRepo := mockRepository(t)
+	expectedID := "3f0c1f0e-5bd6-4b8e-9f0c-3c1e0d5f1a11"
+
+	expectedUser := &models.User{
+		ID:         expectedID,
+		Firstname:  "John",
+		Lastname:   "Doe",
+		Patronymic: makePointer("Michael"),
+		CreatedAt:  createdAt,
+	}
+	cached, _ := json.Marshal(expectedUser)
+
+	t.Run("Cache miss", func(t *testing.T) {
+		mockedRepo.redisMock.ExpectGet(userCacheKey(expectedID)).RedisNil()
+		mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_GetUser)).
+			WithArgs(expectedID).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(expectedID, "John", "Doe", "Michael", createdAt))
+		mockedRepo.redisMock.ExpectSet(userCacheKey(expectedID), cached, REDIS_TTL).SetVal("OK")
+
+		user, err := mockedRepo.repo.GetUser(context.Background(), &models.GetUserRequest{ID: expectedID})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, expectedUser, user)
+	})
+
+	t.Run("Cache hit", func(t *testing.T) {
+		mockedRepo.redisMock.ExpectGet(userCacheKey(expectedID)).SetVal(string(cached))
+
+		user, err := mockedRepo.repo.GetUser(context.Background(), &models.GetUserRequest{ID: expectedID})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, expectedUser, user)
+	})
+
+	t.Run("Cache error falls back to database", func(t *testing.T) {
+		mockedRepo.redisMock.ExpectGet(userCacheKey(expectedID)).SetErr(errors.New("redis error"))
+		mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_GetUser)).
+			WithArgs(expectedID).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(expectedID, "John", "Doe", "Michael", createdAt))
+		mockedRepo.redisMock.ExpectSet(userCacheKey(expectedID), cached, REDIS_TTL).SetErr(errors.New("redis error"))
+
+		user, err := mockedRepo.repo.GetUser(context.Background(), &models.GetUserRequest{ID: expectedID})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, expectedUser, user)
+	})
+
+	t.Run("Not found", func(t *testing.T) {
+		mockedRepo.redisMock.ExpectGet(userCacheKey(expectedID)).RedisNil()
+		mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_GetUser)).
+			WithArgs(expectedID).
+			WillReturnError(sql.ErrNoRows)
+
+		user, err := mockedRepo.repo.GetUser(context.Background(), &models.GetUserRequest{ID: expectedID})
+		assert.Nil(t, user)
+		assert.Equal(t, custom_errors.ERR_CODE_NotFound, err.GetCode())
+		assert.Equal(t, 404, err.GetHTTPStatus())
+	})
+
+	t.Run("Deadline exceeded", func(t *testing.T) {
+		mockedRepo.redisMock.ExpectGet(userCacheKey(expectedID)).RedisNil()
+		mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_GetUser)).
+			WithArgs(expectedID).
+			WillReturnError(context.DeadlineExceeded)
+
+		user, err := mockedRepo.repo.GetUser(context.Background(), &models.GetUserRequest{ID: expectedID})
+		assert.Nil(t, user)
+		assert.Equal(t, custom_errors.ERR_CODE_Timeout, err.GetCode())
+		assert.Equal(t, 504, err.GetHTTPStatus())
+	})
+
+	t.Run("Not valid id", func(t *testing.T) {
+		user, err := mockedRepo.repo.GetUser(context.Background(), &models.GetUserRequest{ID: "not-uuid"})
+		assert.Nil(t, user)
+		assert.Equal(t, custom_errors.ERR_CODE_NotValid, err.GetCode())
+	})
+
+	t.Run("Empty id", func(t *testing.T) {
+		user, err := mockedRepo.repo.GetUser(context.Background(), &models.GetUserRequest{})
+		assert.Nil(t, user)
+		assert.Equal(t, custom_errors.ERR_CODE_REQUIRED_FIELD, err.GetCode())
+	})
+
+	assert.NoError(t, mockedRepo.sqlMock.ExpectationsWereMet())
+	assert.NoError(t, mockedRepo.redisMock.ExpectationsWereMet())
+}
+
+func TestListUsers(t *testing.T) {
+	mockedRepo := mockRepository(t)
+
+	t.Run("Default limit", func(t *testing.T) {
+		mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_ListUsers)).
+			WithArgs(DEFAULT_LIMIT, 0).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow("1", "John", "Doe", nil, createdAt).
+				AddRow("2", "Jane", "Doe", "Ann", createdAt))
+
+		response, err := mockedRepo.repo.ListUsers(context.Background(), &models.ListUsersRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Len(t, response.Users, 2)
+		assert.Nil(t, response.Users[0].Patronymic)
+		assert.Equal(t, DEFAULT_LIMIT, response.Limit)
+	})
+
+	t.Run("No query", func(t *testing.T) {
+		mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_ListUsers)).
+			WithArgs(DEFAULT_LIMIT, 0).
+			WillReturnRows(sqlmock.NewRows(userColumns))
+
+		response, err := mockedRepo.repo.ListUsers(context.Background(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, DEFAULT_LIMIT, response.Limit)
+		assert.Equal(t, 0, response.Offset)
+	})
+
+	t.Run("Empty result", func(t *testing.T) {
+		mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_ListUsers)).
+			WithArgs(10, 20).
+			WillReturnRows(sqlmock.NewRows(userColumns))
+
+		response, err := mockedRepo.repo.ListUsers(context.Background(), &models.ListUsersRequest{Limit: 10, Offset: 20})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.NotNil(t, response.Users)
+		assert.Empty(t, response.Users)
+	})
+
+	t.Run("Not valid limit", func(t *testing.T) {
+		response, err := mockedRepo.repo.ListUsers(context.Background(), &models.ListUsersRequest{Limit: MAX_LIMIT + 1})
+		assert.Nil(t, response)
+		assert.Equal(t, custom_errors.ERR_CODE_NotValid, err.GetCode())
+		assert.Equal(t, map[string]any{"limit": fmt.Sprintf("must be between 0 and %d", MAX_LIMIT)}, err.GetDetails())
+	})
+
+	t.Run("Not valid offset", func(t *testing.T) {
+		response, err := mockedRepo.repo.ListUsers(context.Background(), &models.ListUsersRequest{Offset: -1})
+		assert.Nil(t, response)
+		assert.Equal(t, custom_errors.ERR_CODE_NotValid, err.GetCode())
+		assert.Equal(t, map[string]any{"offset": "must not be negative"}, err.GetDetails())
+	})
+
+	assert.NoError(t, mockedRepo.sqlMock.ExpectationsWereMet())
+	assert.NoError(t, mockedRepo.redisMock.ExpectationsWereMet())
+}
+
+func TestUpdateUser(t *testing.T) {
+	mockedRepo := mockRepository(t)
+	expectedID := "3f0c1f0e-5bd6-4b8e-9f0c-3c1e0d5f1a11"
+
+	t.Run("Success", func(t *testing.T) {
+		var req models.UpdateUserRequest
+		if err := json.Unmarshal([]byte(`{"firstname":"Jack"}`), &req); err != nil {
+			t.Fatal(err)
+		}
+		req.ID = expectedID
+		mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_UpdateUser)).
+			WithArgs(expectedID, true, req.Firstname.Value, false, nil, false, nil).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(expectedID, "Jack", "Doe", "Michael", createdAt))
+		mockedRepo.redisMock.ExpectDel(userCacheKey(expectedID)).SetVal(1)
+
+		user, err := mockedRepo.repo.UpdateUser(context.Background(), &req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, "Jack", user.Firstname)
+		assert.Equal(t, "Doe", user.Lastname)
+	})
+
+	t.Run("Null clears patronymic", func(t *testing.T) {
+		var req models.UpdateUserRequest
+		if err := json.Unmarshal([]byte(`{"patronymic":null}`), &req); err != nil {
+			t.Fatal(err)
+		}
+		req.ID = expectedID
+		mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_UpdateUser)).
+			WithArgs(expectedID, false, nil, false, nil, true, nil).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(expectedID, "John", "Doe", nil, createdAt))
+		mockedRepo.redisMock.ExpectDel(userCacheKey(expectedID)).SetVal(1)
+
+		user, err := mockedRepo.repo.UpdateUser(context.Background(), &req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Nil(t, user.Patronymic)
+	})
+
+	t.Run("Null required field", func(t *testing.T) {
+		var req models.UpdateUserRequest
+		if err := json.Unmarshal([]byte(`{"firstname":null}`), &req); err != nil {
+			t.Fatal(err)
+		}
+		req.ID = expectedID
+
+		user, err := mockedRepo.repo.UpdateUser(context.Background(), &req)
+		assert.Nil(t, user)
+		assert.Equal(t, custom_errors.ERR_CODE_NotValid, err.GetCode())
+		assert.Equal(t, map[string]any{"firstname": "must not be null"}, err.GetDetails())
+	})
+
+	t.Run("Empty body", func(t *testing.T) {
+		user, err := mockedRepo.repo.UpdateUser(context.Background(), &models.UpdateUserRequest{ID: expectedID})
+		assert.Nil(t, user)
+		assert.Equal(t, custom_errors.ERR_CODE_BodyRequired, err.GetCode())
+	})
+
+	t.Run("Not found", func(t *testing.T) {
+		req := &models.UpdateUserRequest{
+			ID:       expectedID,
+			Lastname: models.Optional[string]{Set: true, Value: makePointer("Smith")},
+		}
+		mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_UpdateUser)).
+			WithArgs(expectedID, false, nil, true, req.Lastname.Value, false, nil).
+			WillReturnError(sql.ErrNoRows)
+
+		user, err := mockedRepo.repo.UpdateUser(context.Background(), req)
+		assert.Nil(t, user)
+		assert.Equal(t, custom_errors.ERR_CODE_NotFound, err.GetCode())
+	})
+
+	assert.NoError(t, mockedRepo.sqlMock.ExpectationsWereMet())
+	assert.NoError(t, mockedRepo.redisMock.ExpectationsWereMet())
+}
+
+func TestReplaceUser(t *testing.T) {
+	mockedRepo := mockRepository(t)
+	expectedID := "3f0c1f0e-5bd6-4b8e-9f0c-3c1e0d5f1a11"
+
+	t.Run("Success", func(t *testing.T) {
+		req := &models.ReplaceUserRequest{
+			ID:        expectedID,
+			Firstname: "Jack",
+			Lastname:  "Smith",
+		}
+		mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_ReplaceUser)).
+			WithArgs(expectedID, "Jack", "Smith", nil).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(expectedID, "Jack", "Smith", nil, createdAt))
+		mockedRepo.redisMock.ExpectDel(userCacheKey(expectedID)).SetVal(1)
+
+		user, err := mockedRepo.repo.ReplaceUser(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, "Smith", user.Lastname)
+		assert.Nil(t, user.Patronymic)
+	})
+
+	t.Run("Required fields", func(t *testing.T) {
+		user, err := mockedRepo.repo.ReplaceUser(context.Background(), &models.ReplaceUserRequest{ID: expectedID, Firstname: "Jack"})
+		assert.Nil(t, user)
+		assert.Equal(t, custom_errors.ERR_CODE_REQUIRED_FIELD, err.GetCode())
+	})
+
+	t.Run("Null body", func(t *testing.T) {
+		user, err := mockedRepo.repo.ReplaceUser(context.Background(), nil)
+		assert.Nil(t, user)
+		assert.Equal(t, custom_errors.ERR_CODE_BodyRequired, err.GetCode())
+	})
+
+	assert.NoError(t, mockedRepo.sqlMock.ExpectationsWereMet())
+	assert.NoError(t, mockedRepo.redisMock.ExpectationsWereMet())
+}
+
+func TestDeleteUser(t *testing.T) {
+	mockedRepo := mockRepository(t)
+	expectedID := "3f0c1f0e-5bd6-4b8e-9f0c-3c1e0d5f1a11"
+
+	t.Run("Success", func(t *testing.T) {
+		mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_DeleteUser)).
+			WithArgs(expectedID).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(expectedID))
+		mockedRepo.redisMock.ExpectDel(userCacheKey(expectedID)).SetVal(1)
+
+		response, err := mockedRepo.repo.DeleteUser(context.Background(), &models.DeleteUserRequest{ID: expectedID})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, expectedID, response.ID)
+	})
+
+	t.Run("Not found", func(t *testing.T) {
+		mockedRepo.sqlMock.ExpectQuery(regexp.QuoteMeta(QUERY_DeleteUser)).
+			WithArgs(expectedID).
+			WillReturnError(sql.ErrNoRows)
+
+		response, err := mockedRepo.repo.DeleteUser(context.Background(), &models.DeleteUserRequest{ID: expectedID})
+		assert.Nil(t, response)
+		assert.Equal(t, custom_errors.ERR_CODE_NotFound, err.GetCode())
+	})
+
+	assert.NoError(t, mockedRepo.sqlMock.ExpectationsWereMet())
+	assert.NoError(t, mockedRepo.redisMock.ExpectationsWereMet())
+}
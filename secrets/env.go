@@ -0,0 +1,17 @@
+package secrets
+
+import (
+	"context"
+	"os"
+)
+
+// Reads secret from env variable with the same name
+type Env struct{}
+
+func (Env) Get(ctx context.Context, name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
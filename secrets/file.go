@@ -0,0 +1,27 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const FILE_SUFFIX = "_FILE"
+
+// Reads secret from file which path is stored in env variable with "_FILE" suffix,
+// for example DB_PASSWORD_FILE=/run/secrets/db_password. Used for Docker and Kubernetes secrets.
+type File struct{}
+
+func (File) Get(ctx context.Context, name string) (string, error) {
+	path := os.Getenv(name + FILE_SUFFIX)
+	if path == "" {
+		return "", ErrNotFound
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read %s%s: %w", name, FILE_SUFFIX, err)
+	}
+	// editors and "echo" add trailing new line
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
@@ -0,0 +1,30 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+)
+
+const TracerName string = "secrets"
+
+var ErrNotFound = errors.New("secret not found")
+
+// Provides secret value by name of env variable, for example DB_PASSWORD.
+// Returns ErrNotFound when provider has no such secret.
+type Provider interface {
+	Get(ctx context.Context, name string) (string, error)
+}
+
+// Asks providers in order and returns first found secret
+type Chain []Provider
+
+func (c Chain) Get(ctx context.Context, name string) (string, error) {
+	for _, provider := range c {
+		value, err := provider.Get(ctx, name)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		return value, err
+	}
+	return "", ErrNotFound
+}
@@ -0,0 +1,155 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnv(t *testing.T) {
+	t.Setenv("TEST_SECRET", "value")
+	t.Setenv("TEST_EMPTY_SECRET", "")
+
+	value, err := Env{}.Get(context.Background(), "TEST_SECRET")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+
+	_, err = Env{}.Get(context.Background(), "TEST_EMPTY_SECRET")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestFile(t *testing.T) {
+	t.Run("Trailing new line", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "db_password")
+		if !assert.NoError(t, os.WriteFile(path, []byte("s3cr3t\n"), 0o600)) {
+			return
+		}
+		t.Setenv("TEST_SECRET_FILE", path)
+
+		value, err := File{}.Get(context.Background(), "TEST_SECRET")
+		assert.NoError(t, err)
+		assert.Equal(t, "s3cr3t", value)
+	})
+
+	t.Run("Not set", func(t *testing.T) {
+		_, err := File{}.Get(context.Background(), "TEST_MISSING_SECRET")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("Missing file", func(t *testing.T) {
+		t.Setenv("TEST_SECRET_FILE", filepath.Join(t.TempDir(), "missing"))
+
+		_, err := File{}.Get(context.Background(), "TEST_SECRET")
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrNotFound)
+	})
+}
+
+type mapProvider map[string]string
+
+func (p mapProvider) Get(ctx context.Context, name string) (string, error) {
+	value, ok := p[name]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+type errProvider struct{ err error }
+
+func (p errProvider) Get(ctx context.Context, name string) (string, error) {
+	return "", p.err
+}
+
+func TestChain(t *testing.T) {
+	chain := Chain{mapProvider{"A": "first"}, mapProvider{"A": "second", "B": "second"}}
+
+	value, err := chain.Get(context.Background(), "A")
+	assert.NoError(t, err)
+	assert.Equal(t, "first", value)
+
+	value, err = chain.Get(context.Background(), "B")
+	assert.NoError(t, err)
+	assert.Equal(t, "second", value)
+
+	_, err = chain.Get(context.Background(), "C")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	failure := errors.New("unavailable")
+	_, err = Chain{errProvider{failure}, mapProvider{"A": "value"}}.Get(context.Background(), "A")
+	assert.ErrorIs(t, err, failure)
+}
+
+func TestVault(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get(VAULT_TOKEN_HEADER) != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/kv/data/http_template":
+			w.Write([]byte(`{"data":{"data":{"DB_PASSWORD":"from-vault","PORT":8080},"metadata":{"version":3}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	t.Run("Found", func(t *testing.T) {
+		requests = 0
+		vault, err := NewVault(VaultConfig{Addr: server.URL, Token: "root", Mount: "kv", Path: "http_template"})
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		value, err := vault.Get(context.Background(), "DB_PASSWORD")
+		assert.NoError(t, err)
+		assert.Equal(t, "from-vault", value)
+
+		value, err = vault.Get(context.Background(), "PORT")
+		assert.NoError(t, err)
+		assert.Equal(t, "8080", value)
+
+		_, err = vault.Get(context.Background(), "REDIS_PASSWORD")
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.Equal(t, 1, requests)
+	})
+
+	t.Run("Missing secret path", func(t *testing.T) {
+		vault, err := NewVault(VaultConfig{Addr: server.URL, Token: "root", Path: "missing"})
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		_, err = vault.Get(context.Background(), "DB_PASSWORD")
+		assert.ErrorContains(t, err, `secret "missing" is not found`)
+		assert.NotErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("Forbidden", func(t *testing.T) {
+		vault, err := NewVault(VaultConfig{Addr: server.URL, Token: "wrong", Mount: "kv", Path: "http_template"})
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		_, err = vault.Get(context.Background(), "DB_PASSWORD")
+		assert.ErrorContains(t, err, "403")
+		assert.NotErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("Not valid config", func(t *testing.T) {
+		_, err := NewVault(VaultConfig{Addr: "localhost", Token: "root", Path: "http_template"})
+		assert.Error(t, err)
+
+		_, err = NewVault(VaultConfig{Addr: server.URL, Path: "http_template"})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,111 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	VAULT_DEFAULT_MOUNT = "secret"
+	VAULT_TOKEN_HEADER  = "X-Vault-Token"
+	VAULT_TIMEOUT       = 10 * time.Second
+)
+
+type VaultConfig struct {
+	// Address of Vault, for example http://localhost:8200
+	Addr  string
+	Token string
+	// Mount path of KV v2 engine, "secret" when empty
+	Mount string
+	// Path of secret inside engine. Keys of secret are names of env variables.
+	Path string
+}
+
+// Reads secrets from single HashiCorp Vault KV v2 secret.
+// Secret is fetched once on first Get and cached.
+type Vault struct {
+	cfg    VaultConfig
+	client *http.Client
+
+	once sync.Once
+	data map[string]any
+	err  error
+}
+
+func NewVault(cfg VaultConfig) (*Vault, error) {
+	u, err := url.Parse(cfg.Addr)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("vault address %q is not valid", cfg.Addr)
+	}
+	if cfg.Token == "" {
+		return nil, fmt.Errorf("vault token is required")
+	}
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("vault secret path is required")
+	}
+	if cfg.Mount == "" {
+		cfg.Mount = VAULT_DEFAULT_MOUNT
+	}
+	return &Vault{
+		cfg:    cfg,
+		client: &http.Client{Timeout: VAULT_TIMEOUT},
+	}, nil
+}
+
+func (v *Vault) Get(ctx context.Context, name string) (string, error) {
+	v.once.Do(func() {
+		v.data, v.err = v.read(ctx)
+	})
+	if v.err != nil {
+		return "", v.err
+	}
+
+	value, ok := v.data[name]
+	if !ok || value == nil {
+		return "", ErrNotFound
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+func (v *Vault) read(ctx context.Context) (map[string]any, error) {
+	u := strings.TrimRight(v.cfg.Addr, "/") + "/v1/" + strings.Trim(v.cfg.Mount, "/") + "/data/" + strings.Trim(v.cfg.Path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(VAULT_TOKEN_HEADER, v.cfg.Token)
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("vault: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		// configured secret must exist, otherwise credentials are silently taken from other providers
+		return nil, fmt.Errorf("vault: secret %q is not found in %q", v.cfg.Path, v.cfg.Mount)
+	default:
+		return nil, fmt.Errorf("vault: read %q: %s", v.cfg.Path, resp.Status)
+	}
+
+	var body struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("vault: decode %q: %w", v.cfg.Path, err)
+	}
+	return body.Data.Data, nil
+}
@@ -0,0 +1,24 @@
+package server
+
+import (
+	"context"
+
+	"github.com/Moranilt/http-utils/logger"
+	"github.com/Moranilt/http_template/consumer"
+	"github.com/Moranilt/http_template/models"
+	"github.com/Moranilt/http_template/repository"
+)
+
+// Register handlers for every message type you need to consume.
+// You can provide any logic in handlers, returned error is handled by policy from consumer.Options
+func registerHandlers(registry *consumer.Registry, log logger.Logger) {
+	consumer.Handle(registry, repository.EVENT_UserCreated, func(ctx context.Context, msg *models.User) error {
+		log.InfoContext(ctx, "user created", "id", msg.ID)
+		return nil
+	}, consumer.Options{OnError: consumer.POLICY_Retry})
+
+	consumer.Handle(registry, repository.EVENT_FilesUploaded, func(ctx context.Context, msg *models.FileResponse) error {
+		log.InfoContext(ctx, "files uploaded", "name", msg.Name, "files", len(msg.Files))
+		return nil
+	}, consumer.Options{OnError: consumer.POLICY_Retry})
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"time"
 
@@ -12,101 +14,405 @@ import (
 	"github.com/Moranilt/http-utils/clients/redis"
 	"github.com/Moranilt/http-utils/logger"
 	"github.com/Moranilt/http-utils/tiny_errors"
+	"github.com/Moranilt/http_template/auth"
+	"github.com/Moranilt/http_template/cache"
 	"github.com/Moranilt/http_template/config"
+	"github.com/Moranilt/http_template/consumer"
 	"github.com/Moranilt/http_template/custom_errors"
 	"github.com/Moranilt/http_template/endpoints"
+	"github.com/Moranilt/http_template/healthcheck"
+	"github.com/Moranilt/http_template/idempotency"
+	"github.com/Moranilt/http_template/lifecycle"
 	"github.com/Moranilt/http_template/middleware"
+	"github.com/Moranilt/http_template/outbox"
+	"github.com/Moranilt/http_template/ratelimit"
 	"github.com/Moranilt/http_template/repository"
 	"github.com/Moranilt/http_template/service"
+	"github.com/Moranilt/http_template/storage"
 	"github.com/Moranilt/http_template/tracer"
 	"github.com/Moranilt/http_template/transport"
 	_ "github.com/golang-migrate/migrate/source/file"
-	"golang.org/x/sync/errgroup"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
 )
 
 const (
-	RABBITMQ_QUEUE_NAME = "test_queue"
-
-	DB_DRIVER_NAME = "postgres"
+	DB_DRIVER_NAME       = "postgres"
+	TRACER_FLUSH_TIMEOUT = 5 * time.Second
 )
 
-func Run(ctx context.Context) {
+// External dependencies of application, fakes can be used in tests.
+// RabbitMQ, Redis and Tracer are nil when they are disabled in config.
+type Clients struct {
+	DB       *database.Client
+	RabbitMQ rabbitmq.RabbitMQClient
+	Redis    *redis.Client
+	Storage  storage.Storage
+	Tracer   *tracesdk.TracerProvider
+}
+
+// Reads config, connects to dependencies and runs application until ctx is done.
+// Config is reloaded when file is changed or value is received from reload channel.
+func Run(ctx context.Context, reload <-chan os.Signal) error {
 	tiny_errors.Init(custom_errors.ERRORS)
 	log := logger.New(os.Stdout, logger.TYPE_JSON)
 	logger.SetDefault(log)
 
-	cfg, err := config.Read()
+	args := os.Args[1:]
+	cfg, err := config.Read(ctx, args)
 	if err != nil {
-		log.Fatalf("config: %v", err)
+		return fmt.Errorf("config: %w", err)
 	}
+	reloader := config.NewReloader(cfg, args, log)
 
-	db, err := database.New(ctx, DB_DRIVER_NAME, cfg.DB)
+	clients, err := Connect(ctx, cfg, log)
 	if err != nil {
-		log.Fatalf("db connection: %v", err)
+		return err
 	}
-	defer db.Close()
 
-	// Tracer
-	tp, err := tracer.NewProvider(cfg.Tracer.URL, cfg.Tracer.Name)
+	app, err := New(reloader, clients, log, reload)
 	if err != nil {
-		log.Fatalf("tracer: %v", err)
+		if closeErr := clients.Close(ctx); closeErr != nil {
+			log.Error("close clients", "error", closeErr)
+		}
+		return err
 	}
+	return app.Run(ctx)
+}
 
-	rabbitmqClient := rabbitmq.Init(ctx, RABBITMQ_QUEUE_NAME, log, cfg.RabbitMQ)
-	rabbitmq.ReadMsgs(ctx, 5, 5*time.Second, ConsumeMessage)
+// Connects to dependencies enabled in config. Clients which are already connected are closed when one of them fails.
+func Connect(ctx context.Context, cfg *config.Config, log logger.Logger) (clients *Clients, err error) {
+	fileStorage, err := storage.New(cfg.Storage.Storage())
+	if err != nil {
+		return nil, fmt.Errorf("storage: %w", err)
+	}
 
-	redisClient, err := redis.New(ctx, cfg.Redis)
+	db, err := database.New(ctx, DB_DRIVER_NAME, cfg.DB.Credentials())
 	if err != nil {
-		log.Fatalf("redis: %v", err)
+		return nil, fmt.Errorf("db connection: %w", err)
 	}
+	clients = &Clients{DB: db, Storage: fileStorage}
+	defer func() {
+		if err != nil {
+			if closeErr := clients.Close(ctx); closeErr != nil {
+				log.Error("close clients", "error", closeErr)
+			}
+		}
+	}()
 
-	repo := repository.New(db, rabbitmqClient, redisClient, log)
-	svc := service.New(log, repo)
-	mw := middleware.New(log)
-	ep := endpoints.MakeEndpoints(svc, mw)
-	health := endpoints.MakeHealth(db, rabbitmqClient, redisClient)
-	ep = append(ep, health)
-	server := transport.New(fmt.Sprintf(":%s", cfg.Port), ep, mw)
+	if cfg.Tracer.Enabled {
+		clients.Tracer, err = tracer.NewProvider(cfg.Tracer.URL, cfg.Tracer.Name)
+		if err != nil {
+			return nil, fmt.Errorf("tracer: %w", err)
+		}
+	}
+
+	if cfg.Redis.Enabled {
+		clients.Redis, err = redis.New(ctx, cfg.Redis.Credentials())
+		if err != nil {
+			return nil, fmt.Errorf("redis: %w", err)
+		}
+	}
+
+	if cfg.RabbitMQ.Enabled {
+		clients.RabbitMQ = rabbitmq.Init(ctx, cfg.RabbitMQ.Queue, log, cfg.RabbitMQ.Credentials())
+	}
+	return clients, nil
+}
+
+// Closes connected clients. Used when application can not be built, running application closes them in its components.
+func (c *Clients) Close(ctx context.Context) error {
+	var errs []error
+	if c.RabbitMQ != nil {
+		errs = append(errs, c.RabbitMQ.Close())
+	}
+	if c.Redis != nil {
+		errs = append(errs, c.Redis.Close())
+	}
+	if c.Tracer != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), TRACER_FLUSH_TIMEOUT)
+		errs = append(errs, c.Tracer.Shutdown(shutdownCtx))
+		cancel()
+	}
+	if c.DB != nil {
+		errs = append(errs, c.DB.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Builds application from clients. Components are stopped in reverse order they are added,
+// so add new component after components it uses.
+// Returns error when timeout of endpoint from code does not fit into server.write_timeout.
+func New(reloader *config.Reloader, clients *Clients, log logger.Logger, reload <-chan os.Signal) (*lifecycle.App, error) {
+	cfg := reloader.Config()
+	reloader.Subscribe(func(cfg *config.Config) {
+		logger.SetLevel(cfg.Log.SlogLevel())
+	})
+
+	app := lifecycle.New(log, cfg.Server.GracePeriod)
+	if clients.Tracer != nil {
+		app.Add(lifecycle.Component{
+			Name: "tracer",
+			Stop: func(ctx context.Context) error {
+				// spans of shutdown are flushed even if grace period is exceeded
+				ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), TRACER_FLUSH_TIMEOUT)
+				defer cancel()
+				return clients.Tracer.Shutdown(ctx)
+			},
+		})
+	}
+	app.Add(lifecycle.Component{
+		Name: "database",
+		Stop: func(ctx context.Context) error {
+			return clients.DB.Close()
+		},
+		Health: []healthcheck.HealthItem{{Name: "database", Checker: clients.DB}},
+	})
 
-	g, gCtx := errgroup.WithContext(ctx)
+	// fallbacks are replaced when Redis is enabled
+	var (
+		appCache cache.Cache            = cache.NewMemory()
+		limiter  middleware.RateLimiter = ratelimit.NewMemory()
+	)
+	if clients.Redis != nil {
+		appCache = cache.NewRedis(clients.Redis)
+		limiter = ratelimit.New(clients.Redis)
+		app.Add(lifecycle.Component{
+			Name: "redis",
+			Stop: func(ctx context.Context) error {
+				return clients.Redis.Close()
+			},
+			Health: []healthcheck.HealthItem{{
+				Name:        "redis",
+				Checker:     clients.Redis,
+				Criticality: healthcheck.CRITICALITY_Degraded,
+			}},
+		})
+	} else {
+		log.Notice("redis is disabled, cache, rate limits and idempotency keys are kept in memory")
+	}
+	if clients.RabbitMQ != nil {
+		app.Add(lifecycle.Component{
+			Name: "rabbitmq",
+			Stop: func(ctx context.Context) error {
+				return clients.RabbitMQ.Close()
+			},
+			Health: []healthcheck.HealthItem{{
+				Name:    "rabbitmq",
+				Checker: clients.RabbitMQ,
+				// events are kept in outbox until RabbitMQ is available
+				Criticality: healthcheck.CRITICALITY_Degraded,
+			}},
+		})
+	} else {
+		log.Notice("rabbitmq is disabled, events are kept in outbox and consumers are not started")
+	}
+	app.Add(lifecycle.Component{
+		Name: "storage",
+		Health: []healthcheck.HealthItem{{
+			Name:        "storage",
+			Checker:     clients.Storage,
+			Criticality: healthcheck.CRITICALITY_Degraded,
+		}},
+	})
 
-	g.Go(func() error {
-		<-gCtx.Done()
-		return tp.Shutdown(context.Background())
+	// health checks of components added after monitor are not run
+	monitor := healthcheck.NewMonitor(healthcheck.MonitorConfig{
+		Interval: cfg.Health.Interval,
+		Timeout:  cfg.Health.Timeout,
+	}, app.HealthItems()...)
+	probes := healthcheck.NewProbes(monitor)
+	adminServer := transport.NewAdmin(transport.Config{
+		Addr:        fmt.Sprintf(":%s", cfg.Server.AdminPort),
+		ReadTimeout: cfg.Server.ReadTimeout,
+	}, probes)
+	app.Add(lifecycle.Component{
+		Name: "admin server",
+		Start: func(ctx context.Context) error {
+			return listenAndServe(adminServer, nil)
+		},
+		Stop: adminServer.Shutdown,
 	})
+	app.Add(lifecycle.Component{
+		Name:  "health monitor",
+		Start: monitor.Run,
+	})
+
+	repo := repository.New(clients.DB, appCache, clients.Storage, log)
+
+	// relay is stopped after in-flight requests and handlers, so their events are published.
+	// Without RabbitMQ events stay pending until instance with RabbitMQ publishes them.
+	if clients.RabbitMQ != nil {
+		relay := outbox.NewRelay(clients.DB, clients.RabbitMQ, log, outbox.RelayConfig{
+			Interval:       cfg.Outbox.Interval,
+			BatchSize:      cfg.Outbox.BatchSize,
+			PublishTimeout: cfg.Outbox.PublishTimeout,
+		})
+		relayCtx, stopRelay := context.WithCancel(context.Background())
+		relayDone := make(chan struct{})
+		app.Add(lifecycle.Component{
+			Name: "outbox relay",
+			Start: func(ctx context.Context) error {
+				defer close(relayDone)
+				return relay.Run(relayCtx)
+			},
+			Stop: func(ctx context.Context) error {
+				stopRelay()
+				select {
+				case <-relayDone:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			},
+		})
+	}
 
-	g.Go(func() error {
-		<-gCtx.Done()
-		return server.Shutdown(context.Background())
+	registry := consumer.New(log)
+	registry.SetRetry(repo, consumer.RetryConfig{
+		MaxRetries: cfg.Consumer.MaxRetries,
+		MinBackoff: cfg.Consumer.MinBackoff,
+		MaxBackoff: cfg.Consumer.MaxBackoff,
 	})
+	registerHandlers(registry, log)
+	if clients.RabbitMQ != nil {
+		app.Add(lifecycle.Component{
+			Name: "consumers",
+			Start: func(ctx context.Context) error {
+				// messages are not received after ctx is done
+				go clients.RabbitMQ.ReadMsgs(ctx, cfg.Consumer.MaxMessages, cfg.Consumer.Wait, registry.Consume)
+				return nil
+			},
+			Stop: registry.Stop,
+		})
+	}
 
-	g.Go(func() error {
-		<-gCtx.Done()
-		return rabbitmq.Close()
+	app.Add(lifecycle.Component{
+		Name: "config reloader",
+		Start: func(ctx context.Context) error {
+			go func() {
+				// config still can be reloaded with SIGHUP
+				if err := reloader.Watch(ctx); err != nil {
+					log.Error("config watcher", "error", err)
+				}
+			}()
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-reload:
+					if err := reloader.Reload(ctx); err != nil {
+						log.Error("config reload", "error", err)
+					}
+				}
+			}
+		},
 	})
 
-	g.Go(func() error {
-		return server.ListenAndServe()
+	svc := service.New(log, repo)
+	mwOptions := []middleware.Option{
+		middleware.WithTokenStore(auth.NewStore(clients.DB, appCache, log)),
+		middleware.WithRateLimiter(limiter),
+		middleware.WithIPRateLimit(cfg.Server.IPRateLimit.Limit()),
+		middleware.WithTrustedProxies(cfg.Server.Proxies()),
+		middleware.WithIdempotencyStore(idempotency.New(appCache)),
+	}
+	if cfg.JWT.Enabled() {
+		mwOptions = append(mwOptions, middleware.WithJWTVerifier(auth.NewVerifier(jwtConfig(cfg.JWT))))
+	}
+	mw := middleware.New(log, mwOptions...)
+	reloader.Subscribe(func(cfg *config.Config) {
+		mw.SetCORS(corsPolicy(cfg.CORS))
+		mw.SetOverrides(endpointOverrides(cfg.Endpoints))
+	})
+	routes := endpoints.MakeEndpoints(svc, mw)
+	for _, endpoint := range routes {
+		for _, method := range endpoint.Methods {
+			name := fmt.Sprintf("timeout of endpoint %s %s", method, endpoint.Pattern)
+			if err := config.ValidateEndpointTimeout(name, endpoint.Timeout, cfg.Server.WriteTimeout); err != nil {
+				return nil, err
+			}
+		}
+	}
+	server := transport.New(transport.Config{
+		Addr:         fmt.Sprintf(":%s", cfg.Server.Port),
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+	}, routes, mw)
+	app.Add(lifecycle.Component{
+		Name: "http server",
+		Start: func(ctx context.Context) error {
+			// instance is ready only when port is bound and requests are accepted
+			return listenAndServe(server, func() {
+				probes.SetState(healthcheck.STATE_Ready)
+			})
+		},
+		Stop: func(ctx context.Context) error {
+			// load balancer stops sending traffic before connections are closed
+			probes.SetState(healthcheck.STATE_Draining)
+			drain := time.NewTimer(cfg.Server.DrainDelay)
+			defer drain.Stop()
+			select {
+			case <-drain.C:
+			case <-ctx.Done():
+			}
+			return server.Shutdown(ctx)
+		},
 	})
 
-	if err := g.Wait(); err != nil {
-		log.Infof("exit with: %s", err)
+	return app, nil
+}
+
+func jwtConfig(c config.JWTConfig) auth.JWTConfig {
+	return auth.JWTConfig{
+		JWKSURL:    c.JWKSURL,
+		JWKSTTL:    c.JWKSTTL,
+		HMACSecret: []byte(c.HMACSecret),
+		Issuer:     c.Issuer,
+		Audience:   c.Audience,
+		ClockSkew:  c.ClockSkew,
+	}
+}
+
+// Default CORS policy, nil when CORS is disabled
+func corsPolicy(c config.CORSConfig) *middleware.CORSPolicy {
+	if !c.Enabled() {
+		return nil
+	}
+	return &middleware.CORSPolicy{
+		AllowedOrigins:   c.AllowedOrigins,
+		AllowedMethods:   c.AllowedMethods,
+		AllowedHeaders:   c.AllowedHeaders,
+		ExposedHeaders:   c.ExposedHeaders,
+		AllowCredentials: c.AllowCredentials,
+		MaxAge:           c.MaxAge,
 	}
 }
 
-// Example of consume
-// You can provide any logic in this callback for each received message
-//
-// Requeue received message
-// If it was requeued already - just Ack this
-func ConsumeMessage(ctx context.Context, d rabbitmq.RabbitDelivery) error {
-	if d.Redelivered() {
-		d.Ack(true)
-		fmt.Println("Message redelivered: ", string(d.Body()), d.DeliveryTag())
-		return errors.New("message redelivered")
+func endpointOverrides(endpoints map[string]config.EndpointConfig) map[string]middleware.EndpointOverride {
+	overrides := make(map[string]middleware.EndpointOverride, len(endpoints))
+	for key, endpoint := range endpoints {
+		override := middleware.EndpointOverride{Timeout: endpoint.Timeout}
+		if endpoint.RateLimit != nil {
+			limit := endpoint.RateLimit.Limit()
+			override.RateLimit = &limit
+		}
+		overrides[key] = override
 	}
+	return overrides
+}
 
-	fmt.Println("Message: ", string(d.Body()), d.DeliveryTag(), d.MessageCount())
-	d.Nack(false, true)
+// Calls listening after address is bound, if set.
+// Returns nil when server is closed by shutdown
+func listenAndServe(server *http.Server, listening func()) error {
+	ln, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return err
+	}
+	if listening != nil {
+		listening()
+	}
+	if err := server.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
 	return nil
 }
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Moranilt/http-utils/clients/database"
+	"github.com/Moranilt/http-utils/clients/rabbitmq"
+	redis_mock "github.com/Moranilt/http-utils/clients/redis/mock"
+	"github.com/Moranilt/http-utils/logger"
+	"github.com/Moranilt/http_template/config"
+	"github.com/Moranilt/http_template/healthcheck"
+	"github.com/Moranilt/http_template/lifecycle"
+	"github.com/Moranilt/http_template/storage"
+	"github.com/jmoiron/sqlx"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+)
+
+type fakeRabbitMQ struct {
+	reading atomic.Bool
+	closed  atomic.Bool
+}
+
+func (f *fakeRabbitMQ) Check(ctx context.Context) error { return nil }
+
+func (f *fakeRabbitMQ) ReadMsgs(ctx context.Context, maxAmount int, wait time.Duration, callback rabbitmq.ReadMsgCallback) {
+	f.reading.Store(true)
+}
+
+func (f *fakeRabbitMQ) Push(ctx context.Context, data []byte) error { return nil }
+
+func (f *fakeRabbitMQ) UnsafePush(ctx context.Context, data []byte) error { return nil }
+
+func (f *fakeRabbitMQ) Consume() (<-chan amqp.Delivery, error) { return nil, nil }
+
+func (f *fakeRabbitMQ) Close() error {
+	f.closed.Store(true)
+	return nil
+}
+
+func newTestApp(t *testing.T, clients *Clients) (*lifecycle.App, sqlmock.Sqlmock) {
+	t.Helper()
+	mockDB, sqlMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlMock.ExpectClose()
+	fileStorage, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients.DB = &database.Client{DB: sqlx.NewDb(mockDB, "sqlmock")}
+	clients.Storage = fileStorage
+
+	log := logger.New(io.Discard, logger.TYPE_JSON)
+	cfg := &config.Config{
+		Server: config.ServerConfig{Port: "0", AdminPort: "0", GracePeriod: time.Second},
+	}
+	app, err := New(config.NewReloader(cfg, nil, log), clients, log, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return app, sqlMock
+}
+
+func healthCriticality(app *lifecycle.App) map[string]healthcheck.Criticality {
+	criticality := make(map[string]healthcheck.Criticality)
+	for _, item := range app.HealthItems() {
+		criticality[item.Name] = item.Criticality
+	}
+	return criticality
+}
+
+// Runs application until it is started and stops it
+func runTestApp(t *testing.T, app *lifecycle.App, started func() bool) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- app.Run(ctx)
+	}()
+	assert.Eventually(t, started, time.Second, 10*time.Millisecond, "application is not started")
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("application is not stopped")
+	}
+}
+
+func TestApp(t *testing.T) {
+	t.Run("All clients", func(t *testing.T) {
+		redisClient, _ := redis_mock.New()
+		rabbit := &fakeRabbitMQ{}
+		app, sqlMock := newTestApp(t, &Clients{RabbitMQ: rabbit, Redis: redisClient})
+
+		assert.Equal(t, map[string]healthcheck.Criticality{
+			"database": healthcheck.CRITICALITY_Critical,
+			"redis":    healthcheck.CRITICALITY_Degraded,
+			"rabbitmq": healthcheck.CRITICALITY_Degraded,
+			"storage":  healthcheck.CRITICALITY_Degraded,
+		}, healthCriticality(app))
+
+		runTestApp(t, app, rabbit.reading.Load)
+		assert.True(t, rabbit.closed.Load())
+		assert.NoError(t, sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("Only database", func(t *testing.T) {
+		app, sqlMock := newTestApp(t, &Clients{})
+
+		assert.Equal(t, map[string]healthcheck.Criticality{
+			"database": healthcheck.CRITICALITY_Critical,
+			"storage":  healthcheck.CRITICALITY_Degraded,
+		}, healthCriticality(app))
+
+		runTestApp(t, app, func() bool { return true })
+		assert.NoError(t, sqlMock.ExpectationsWereMet())
+	})
+}
+
+func TestClientsClose(t *testing.T) {
+	mockDB, sqlMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlMock.ExpectClose()
+	rabbit := &fakeRabbitMQ{}
+	clients := &Clients{
+		DB:       &database.Client{DB: sqlx.NewDb(mockDB, "sqlmock")},
+		RabbitMQ: rabbit,
+		Tracer:   tracesdk.NewTracerProvider(),
+	}
+
+	assert.NoError(t, clients.Close(context.Background()))
+	assert.True(t, rabbit.closed.Load())
+	assert.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestNewRejectsEndpointTimeout(t *testing.T) {
+	log := logger.New(io.Discard, logger.TYPE_JSON)
+	cfg := &config.Config{
+		Server: config.ServerConfig{Port: "0", AdminPort: "0", WriteTimeout: time.Second},
+	}
+
+	_, err := New(config.NewReloader(cfg, nil, log), &Clients{}, log, nil)
+	assert.ErrorContains(t, err, "must be at least 1s less than server.write_timeout")
+}
+
+func TestEndpointOverrides(t *testing.T) {
+	overrides := endpointOverrides(map[string]config.EndpointConfig{
+		"post /user": {Timeout: 2 * time.Second, RateLimit: &config.RateLimitConfig{Requests: 1, Period: time.Second}},
+		"get /user":  {Timeout: time.Second},
+	})
+
+	if override, ok := overrides["post /user"]; assert.True(t, ok) {
+		assert.Equal(t, 2*time.Second, override.Timeout)
+		assert.Equal(t, 1, override.RateLimit.Requests)
+	}
+	assert.Nil(t, overrides["get /user"].RateLimit, "limit of endpoint must be kept")
+}
@@ -1,17 +1,47 @@
 package service
 
 import (
+	"context"
+	"io"
+	"mime"
 	"net/http"
+	"slices"
+	"strconv"
 
 	"github.com/Moranilt/http-utils/handler"
 	"github.com/Moranilt/http-utils/logger"
+	"github.com/Moranilt/http-utils/response"
+	"github.com/Moranilt/http-utils/tiny_errors"
+	"github.com/Moranilt/http_template/custom_errors"
+	"github.com/Moranilt/http_template/models"
 	"github.com/Moranilt/http_template/repository"
+	"github.com/gorilla/mux"
 )
 
+// Content types which browser can not execute. Other stored types are downloaded as application/octet-stream,
+// so uploaded HTML or SVG is never rendered from API origin.
+var DOWNLOAD_SAFE_TYPES = []string{
+	"text/plain",
+	"image/png",
+	"image/jpeg",
+	"image/gif",
+	"image/webp",
+	"application/pdf",
+}
+
 type Service interface {
 	CreateUser(http.ResponseWriter, *http.Request)
+	GetUser(w http.ResponseWriter, r *http.Request)
+	ReplaceUser(w http.ResponseWriter, r *http.Request)
+	UpdateUser(w http.ResponseWriter, r *http.Request)
+	DeleteUser(w http.ResponseWriter, r *http.Request)
+	ListUsers(w http.ResponseWriter, r *http.Request)
 	Files(w http.ResponseWriter, r *http.Request)
+	StreamFiles(w http.ResponseWriter, r *http.Request)
+	DownloadFile(w http.ResponseWriter, r *http.Request)
 	GetRandomNumber(w http.ResponseWriter, r *http.Request)
+	ListDeadLetters(w http.ResponseWriter, r *http.Request)
+	ReplayDeadLetter(w http.ResponseWriter, r *http.Request)
 }
 
 type service struct {
@@ -32,14 +62,99 @@ func (s *service) CreateUser(w http.ResponseWriter, r *http.Request) {
 		Run(http.StatusOK)
 }
 
+func (s *service) GetUser(w http.ResponseWriter, r *http.Request) {
+	handler.New(w, r, s.log, s.repo.GetUser).
+		WithVars().
+		Run(http.StatusOK)
+}
+
+func (s *service) ReplaceUser(w http.ResponseWriter, r *http.Request) {
+	handler.New(w, r, s.log, s.repo.ReplaceUser).
+		WithJSON().
+		WithVars().
+		Run(http.StatusOK)
+}
+
+func (s *service) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	handler.New(w, r, s.log, s.repo.UpdateUser).
+		WithJSON().
+		WithVars().
+		Run(http.StatusOK)
+}
+
+func (s *service) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	handler.New(w, r, s.log, s.repo.DeleteUser).
+		WithVars().
+		Run(http.StatusOK)
+}
+
+func (s *service) ListUsers(w http.ResponseWriter, r *http.Request) {
+	handler.New(w, r, s.log, s.repo.ListUsers).
+		WithQuery().
+		Run(http.StatusOK)
+}
+
 func (s *service) Files(w http.ResponseWriter, r *http.Request) {
 	handler.New(w, r, s.log, s.repo.Files).
 		WithMultipart(32 << 20).
 		Run(http.StatusOK)
 }
 
+// Passes multipart reader to repository, so body is not parsed before upload
+func (s *service) StreamFiles(w http.ResponseWriter, r *http.Request) {
+	handler.New(w, r, s.log, func(ctx context.Context, _ *models.StreamFilesRequest) (*models.FileResponse, tiny_errors.ErrorHandler) {
+		reader, err := r.MultipartReader()
+		if err != nil {
+			return nil, tiny_errors.New(custom_errors.ERR_CODE_NotValid, tiny_errors.Message(err.Error()))
+		}
+		return s.repo.StreamFiles(ctx, &models.StreamFilesRequest{Reader: reader})
+	}).Run(http.StatusOK)
+}
+
+// Streams file content, so response is not wrapped into JSON on success
+func (s *service) DownloadFile(w http.ResponseWriter, r *http.Request) {
+	file, err := s.repo.GetFile(r.Context(), &models.GetFileRequest{ID: mux.Vars(r)["id"]})
+	if err != nil {
+		s.log.WithRequestId(r.Context()).Error(err.Error(), "code", err.GetCode(), "details", err.GetDetails())
+		response.ErrorResponse(w, err, err.GetHTTPStatus())
+		return
+	}
+	defer file.Body.Close()
+
+	w.Header().Set("Content-Type", downloadContentType(file.ContentType))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Length", strconv.FormatInt(file.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.Name}))
+	w.Header().Set("ETag", strconv.Quote(file.SHA256))
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(w, file.Body); err != nil {
+		s.log.WithRequestId(r.Context()).Error("download file", "id", file.ID, "error", err)
+	}
+}
+
+func downloadContentType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !slices.Contains(DOWNLOAD_SAFE_TYPES, mediaType) {
+		return "application/octet-stream"
+	}
+	return contentType
+}
+
 func (s *service) GetRandomNumber(w http.ResponseWriter, r *http.Request) {
 	handler.New(w, r, s.log, s.repo.GetRandomNumber).
 		WithQuery().
 		Run(http.StatusOK)
 }
+
+func (s *service) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	handler.New(w, r, s.log, s.repo.ListDeadLetters).
+		WithQuery().
+		Run(http.StatusOK)
+}
+
+func (s *service) ReplayDeadLetter(w http.ResponseWriter, r *http.Request) {
+	handler.New(w, r, s.log, s.repo.ReplayDeadLetter).
+		WithVars().
+		Run(http.StatusOK)
+}
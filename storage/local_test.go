@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocal(t *testing.T) {
+	local, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	t.Run("Put and Get", func(t *testing.T) {
+		err := local.Put(ctx, "files/1", strings.NewReader("content"), -1, "text/plain")
+		assert.NoError(t, err)
+
+		r, err := local.Get(ctx, "files/1")
+		if assert.NoError(t, err) {
+			defer r.Close()
+			b, _ := io.ReadAll(r)
+			assert.Equal(t, "content", string(b))
+		}
+	})
+
+	t.Run("Not found", func(t *testing.T) {
+		_, err := local.Get(ctx, "not-exists")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		assert.NoError(t, local.Put(ctx, "2", strings.NewReader("content"), 7, "text/plain"))
+		assert.NoError(t, local.Delete(ctx, "2"))
+		assert.NoError(t, local.Delete(ctx, "2"))
+
+		_, err := local.Get(ctx, "2")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("Key outside of directory", func(t *testing.T) {
+		err := local.Put(ctx, "../escape", strings.NewReader("content"), 7, "text/plain")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// In-memory stand-in of S3 compatible service
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	types   map[string]string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/") ||
+		r.Header.Get("X-Amz-Date") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodHead:
+		if r.URL.Path != "/bucket" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case http.MethodPut:
+		if r.ContentLength < 0 {
+			w.WriteHeader(http.StatusLengthRequired)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		f.objects[r.URL.Path] = b
+		f.types[r.URL.Path] = r.Header.Get("Content-Type")
+	case http.MethodGet:
+		b, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("<Error><Code>NoSuchKey</Code></Error>"))
+			return
+		}
+		w.Write(b)
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func mockS3(t *testing.T) (*S3, *fakeS3) {
+	fake := &fakeS3{objects: make(map[string][]byte), types: make(map[string]string)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	s3, err := NewS3(&S3Config{
+		Endpoint:  server.URL,
+		Bucket:    "bucket",
+		AccessKey: "access",
+		SecretKey: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s3, fake
+}
+
+func TestS3(t *testing.T) {
+	s3, fake := mockS3(t)
+	ctx := context.Background()
+
+	t.Run("Put and Get", func(t *testing.T) {
+		err := s3.Put(ctx, "file.txt", strings.NewReader("content"), 7, "text/plain")
+		assert.NoError(t, err)
+		assert.Equal(t, "text/plain", fake.types["/bucket/file.txt"])
+
+		r, err := s3.Get(ctx, "file.txt")
+		if assert.NoError(t, err) {
+			defer r.Close()
+			b, _ := io.ReadAll(r)
+			assert.Equal(t, "content", string(b))
+		}
+	})
+
+	t.Run("Put with unknown size", func(t *testing.T) {
+		err := s3.Put(ctx, "unknown.txt", strings.NewReader("content"), -1, "text/plain")
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("content"), fake.objects["/bucket/unknown.txt"])
+	})
+
+	t.Run("Not found", func(t *testing.T) {
+		_, err := s3.Get(ctx, "not-exists")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		assert.NoError(t, s3.Delete(ctx, "file.txt"))
+		_, err := s3.Get(ctx, "file.txt")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("Check", func(t *testing.T) {
+		assert.NoError(t, s3.Check(ctx))
+	})
+}
+
+func TestS3Sign(t *testing.T) {
+	s3, err := NewS3(&S3Config{
+		Endpoint:  "http://127.0.0.1:9000",
+		Bucket:    "bucket",
+		AccessKey: "access",
+		SecretKey: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := s3.newRequest(context.Background(), http.MethodPut, "file.txt", nil)
+	s3.sign(req, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	assert.Equal(t, "20240101T000000Z", req.Header.Get("X-Amz-Date"))
+	assert.Equal(t,
+		"AWS4-HMAC-SHA256 Credential=access/20240101/us-east-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=c9d69b93cb6f37fbe7922dd825196267adf149fa72f6475d79a6b13ec3198d4e",
+		req.Header.Get("Authorization"),
+	)
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const TracerName string = "storage"
+
+const (
+	DRIVER_Local = "local"
+	DRIVER_S3    = "s3"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+// Blob store for uploaded files
+type Storage interface {
+	// Store object with provided key. Size -1 means that size is unknown.
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	// Returns ErrNotFound if object does not exist
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Deleting of not existing object is not an error
+	Delete(ctx context.Context, key string) error
+	Check(ctx context.Context) error
+}
+
+type Config struct {
+	Driver   string
+	LocalDir string
+	S3       *S3Config
+}
+
+// Creates Storage using provided driver
+func New(cfg *Config) (Storage, error) {
+	switch cfg.Driver {
+	case DRIVER_Local, "":
+		return NewLocal(cfg.LocalDir)
+	case DRIVER_S3:
+		if cfg.S3 == nil {
+			return nil, errors.New("s3 config is empty")
+		}
+		return NewS3(cfg.S3)
+	}
+	return nil, fmt.Errorf("unknown storage driver %q", cfg.Driver)
+}
@@ -0,0 +1,43 @@
+package transport
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/Moranilt/http_template/buildinfo"
+	"github.com/Moranilt/http_template/healthcheck"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Ops server with metrics, probes, pprof and build info. Requests are not passed
+// through application middleware, so they are not logged and not counted in application metrics.
+// Do not expose its port publicly. WriteTimeout of cfg is ignored, because profile and trace
+// are written for ?seconds=N.
+func NewAdmin(cfg Config, probes *healthcheck.Probes) *http.Server {
+	router := mux.NewRouter()
+	router.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet)
+	router.Handle("/health", probes.Health()).Methods(http.MethodGet)
+	router.Handle("/live", probes.Liveness()).Methods(http.MethodGet)
+	router.Handle("/ready", probes.Readiness()).Methods(http.MethodGet)
+	router.Handle("/startup", probes.Startup()).Methods(http.MethodGet)
+	router.HandleFunc("/version", version).Methods(http.MethodGet)
+
+	router.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	router.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	router.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	router.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	router.PathPrefix("/debug/pprof/").HandlerFunc(pprof.Index)
+
+	return &http.Server{
+		Addr:        cfg.Addr,
+		ReadTimeout: cfg.ReadTimeout,
+		Handler:     router,
+	}
+}
+
+func version(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildinfo.Get())
+}
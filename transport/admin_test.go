@@ -0,0 +1,39 @@
+package transport
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Moranilt/http_template/healthcheck"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAdmin(t *testing.T) {
+	monitor := healthcheck.NewMonitor(healthcheck.MonitorConfig{})
+	monitor.Check(context.Background())
+	probes := healthcheck.NewProbes(monitor)
+	server := NewAdmin(Config{}, probes)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, get("/metrics").Code)
+	assert.Equal(t, http.StatusOK, get("/health").Code)
+	assert.Equal(t, http.StatusOK, get("/live").Code)
+	assert.Equal(t, http.StatusOK, get("/debug/pprof/").Code)
+
+	w := get("/version")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"version":"dev"`)
+
+	assert.Equal(t, http.StatusServiceUnavailable, get("/ready").Code)
+	assert.Equal(t, http.StatusServiceUnavailable, get("/startup").Code)
+	probes.SetState(healthcheck.STATE_Ready)
+	assert.Equal(t, http.StatusOK, get("/ready").Code)
+	assert.Equal(t, http.StatusOK, get("/startup").Code)
+}
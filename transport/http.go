@@ -2,34 +2,82 @@ package transport
 
 import (
 	"net/http"
+	"slices"
 	"time"
 
 	"github.com/Moranilt/http_template/endpoints"
 	"github.com/Moranilt/http_template/middleware"
+	"github.com/Moranilt/http_template/ratelimit"
 	"github.com/gorilla/mux"
 )
 
-func New(addr string, endpoints []endpoints.Endpoint, mw *middleware.Middleware) *http.Server {
+type Config struct {
+	Addr string
+	// Zero value means no timeout
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+}
+
+func New(cfg Config, endpoints []endpoints.Endpoint, mw *middleware.Middleware) *http.Server {
 	router := mux.NewRouter()
-	router.Use(mw.Default, mw.Otel, mw.Prometheus)
+	router.Use(mw.Default, mw.Otel, mw.Prometheus, mw.Compress, mw.Recover)
 
+	// CORS policy of every method by route, used to answer OPTIONS requests
+	preflight := make(map[string]map[string]*middleware.CORSPolicy)
+	var patterns []string
 	for _, endpoint := range endpoints {
-		handler := applyMiddleware(endpoint.HandleFunc, endpoint.Middleware)
+		handler := applyMiddleware(endpoint.HandleFunc, endpointMiddleware(endpoint, mw))
 		router.Handle(endpoint.Pattern, handler).Methods(endpoint.Methods...)
+
+		if slices.Contains(endpoint.Methods, http.MethodOptions) {
+			continue
+		}
+		if _, ok := preflight[endpoint.Pattern]; !ok {
+			preflight[endpoint.Pattern] = make(map[string]*middleware.CORSPolicy)
+			patterns = append(patterns, endpoint.Pattern)
+		}
+		for _, method := range endpoint.Methods {
+			preflight[endpoint.Pattern][method] = endpoint.CORS
+		}
+	}
+
+	for _, pattern := range patterns {
+		router.Handle(pattern, mw.Preflight(preflight[pattern])).Methods(http.MethodOptions)
 	}
 
 	server := &http.Server{
-		Addr:         addr,
-		ReadTimeout:  40 * time.Second,
-		WriteTimeout: 40 * time.Second,
+		Addr:         cfg.Addr,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
 		Handler:      router,
 	}
 	return server
 }
 
+// Puts CORS, timeout and per IP rate limit before middlewares of endpoint, so errors are readable by browser,
+// timeout includes authorization and unauthenticated requests are throttled before they reach token store.
+// Appends scopes and roles checks and rate limit per client after them.
+// Timeout and rate limit are added to every endpoint, so they can be enabled at runtime with overrides.
+func endpointMiddleware(endpoint endpoints.Endpoint, mw *middleware.Middleware) []middleware.EndpointMiddlewareFunc {
+	mws := []middleware.EndpointMiddlewareFunc{mw.CORS(endpoint.CORS), mw.Timeout(endpoint.Timeout), mw.IPRateLimit}
+	mws = append(mws, endpoint.Middleware...)
+	if len(endpoint.Scopes) > 0 {
+		mws = append(mws, mw.ScopesRequired(endpoint.Scopes...))
+	}
+	if len(endpoint.Roles) > 0 {
+		mws = append(mws, mw.RolesRequired(endpoint.Roles...))
+	}
+	var limit ratelimit.Limit
+	if endpoint.RateLimit != nil {
+		limit = *endpoint.RateLimit
+	}
+	return append(mws, mw.RateLimit(limit))
+}
+
+// First middleware in list is executed first
 func applyMiddleware(handler http.Handler, mws []middleware.EndpointMiddlewareFunc) http.Handler {
-	for _, mw := range mws {
-		handler = mw(handler)
+	for i := len(mws) - 1; i >= 0; i-- {
+		handler = mws[i](handler)
 	}
 	return handler
 }